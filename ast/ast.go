@@ -21,8 +21,11 @@ type Expression interface {
 	expressionNode()
 }
 
+// Program is a parsed source file. Comments holds its `//` comments in
+// source order; they are not part of any statement.
 type Program struct {
 	Statements []Statement
+	Comments   []token.Token
 }
 
 func (p *Program) TokenLiteral() string {
@@ -42,18 +45,37 @@ func (p *Program) String() string {
 	return out.String()
 }
 
+// LetStatement binds Value either to the single name Bind or, for
+// destructuring forms like `let [a, ...rest] = xs;`, to the names in
+// Pattern. Exactly one of Bind and Pattern is set. A `const` declaration
+// is a LetStatement whose Token is token.CONST.
 type LetStatement struct {
-	Token token.Token
-	Bind  *Identifier
-	Value Expression
+	Token   token.Token
+	Bind    *Identifier
+	Pattern Pattern
+	Value   Expression
 }
 
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
 func (ls *LetStatement) statementNode()       {}
+func (ls *LetStatement) IsConst() bool        { return ls.Token.Type == token.CONST }
+
+// Names returns the identifiers the statement binds, in source order.
+func (ls *LetStatement) Names() []*Identifier {
+	if ls.Pattern != nil {
+		return PatternNames(ls.Pattern)
+	}
+	return []*Identifier{ls.Bind}
+}
+
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString(ls.TokenLiteral() + " ")
-	out.WriteString(ls.Bind.String() + " = ")
+	if ls.Pattern != nil {
+		out.WriteString(ls.Pattern.String() + " = ")
+	} else {
+		out.WriteString(ls.Bind.String() + " = ")
+	}
 
 	if ls.Value != nil {
 		out.WriteString(ls.Value.String())
@@ -63,14 +85,43 @@ func (ls *LetStatement) String() string {
 	return out.String()
 }
 
+// Identifier is a variable name. A name declared by let or as a function
+// parameter may carry a Type annotation. The resolver fills in where the
+// variable lives: Depth scopes out from the one the identifier appears in,
+// at index Slot of that scope, or among the builtins when Depth is -1.
+// Identifiers the resolver has not seen are looked up by name.
 type Identifier struct {
-	Token token.Token
-	Value string
+	Token    token.Token
+	Value    string
+	Type     *TypeAnnotation `json:",omitempty"`
+	Resolved bool            `json:"-"`
+	Depth    int             `json:"-"`
+	Slot     int             `json:"-"`
 }
 
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
 func (i *Identifier) expressionNode()      {}
-func (i *Identifier) String() string       { return i.Value }
+func (i *Identifier) String() string {
+	if i.Type != nil {
+		return i.Value + ": " + i.Type.String()
+	}
+	return i.Value
+}
+
+// TypeAnnotation is the type written after a declared name or a parameter
+// list, as in `let n: int = 1;` and `fn(s: string) -> bool { ... }`. Name
+// is one of TypeNames.
+type TypeAnnotation struct {
+	Token token.Token
+	Name  string
+}
+
+func (ta *TypeAnnotation) TokenLiteral() string { return ta.Token.Literal }
+func (ta *TypeAnnotation) String() string       { return ta.Name }
+
+// TypeNames are the types an annotation may name; `any` allows every
+// value and `fn` both functions and builtins.
+var TypeNames = []string{"int", "string", "bool", "null", "array", "hash", "fn", "error", "any"}
 
 type ReturnStatement struct {
 	Token token.Token
@@ -91,6 +142,81 @@ func (r *ReturnStatement) String() string {
 	return out.String()
 }
 
+type ThrowStatement struct {
+	Token token.Token
+	Value Expression
+}
+
+func (t *ThrowStatement) TokenLiteral() string { return t.Token.Literal }
+func (t *ThrowStatement) statementNode()       {}
+func (t *ThrowStatement) String() string {
+	return t.TokenLiteral() + " " + t.Value.String() + ";"
+}
+
+// DeferStatement is `defer expr;`. When Call is a call expression its
+// callee and arguments are evaluated at the defer statement and the call
+// itself is made when the enclosing function exits.
+type DeferStatement struct {
+	Token token.Token
+	Call  Expression
+}
+
+func (d *DeferStatement) TokenLiteral() string { return d.Token.Literal }
+func (d *DeferStatement) statementNode()       {}
+func (d *DeferStatement) String() string {
+	return d.TokenLiteral() + " " + d.Call.String() + ";"
+}
+
+// ImportStatement loads the module at Path. `import "lib.mk" as m;` binds
+// the whole module to Alias, while `import {a, b as c} from "lib.mk";`
+// binds each export in Names to the identifier at the same position in
+// Bindings.
+type ImportStatement struct {
+	Token    token.Token
+	Path     *StringLiteral
+	Alias    *Identifier
+	Names    []*Identifier
+	Bindings []*Identifier
+}
+
+func (i *ImportStatement) TokenLiteral() string { return i.Token.Literal }
+func (i *ImportStatement) statementNode()       {}
+func (i *ImportStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString(i.TokenLiteral() + " ")
+	if i.Names != nil {
+		names := []string{}
+		for j, name := range i.Names {
+			if name.Value == i.Bindings[j].Value {
+				names = append(names, name.String())
+			} else {
+				names = append(names, name.String()+" as "+i.Bindings[j].String())
+			}
+		}
+		out.WriteString("{" + strings.Join(names, ", ") + "} from ")
+	}
+	out.WriteString(`"` + i.Path.Value + `"`)
+	if i.Alias != nil {
+		out.WriteString(" as " + i.Alias.String())
+	}
+	out.WriteString(";")
+	return out.String()
+}
+
+// ExportStatement is `export let ...;` or `export const ...;` at the top
+// level of a module; the names Statement binds become the module's
+// exports.
+type ExportStatement struct {
+	Token     token.Token
+	Statement *LetStatement
+}
+
+func (e *ExportStatement) TokenLiteral() string { return e.Token.Literal }
+func (e *ExportStatement) statementNode()       {}
+func (e *ExportStatement) String() string {
+	return e.TokenLiteral() + " " + e.Statement.String()
+}
+
 type ExpressionStatement struct {
 	Token      token.Token
 	Expression Expression
@@ -174,17 +300,66 @@ func (if_ *IfExpression) String() string {
 
     out.WriteString("if ")
     out.WriteString(if_.Condition.String() + " ")
-    out.WriteString(if_.Consequence.String())
+    out.WriteString("{ " + if_.Consequence.String() + " }")
+
+    if elseIf := if_.ElseIf(); elseIf != nil {
+        out.WriteString(" else " + elseIf.String())
+    } else if if_.Alternative != nil {
+        out.WriteString(" else { " + if_.Alternative.String() + " }")
+    }
+    return out.String()
+}
+
+// ElseIf returns the nested IfExpression when the alternative was written
+// as `else if`, and nil otherwise.
+func (if_ *IfExpression) ElseIf() *IfExpression {
+    alt := if_.Alternative
+    if alt == nil || alt.Token.Type != token.IF || len(alt.Statements) != 1 {
+        return nil
+    }
+    stmt, ok := alt.Statements[0].(*ExpressionStatement)
+    if !ok {
+        return nil
+    }
+    elseIf, _ := stmt.Expression.(*IfExpression)
+    return elseIf
+}
+
+// TryExpression is `try { } catch (e) { } finally { }`. At least one of
+// Catch and Finally is set; Param is nil for a bare `catch { }`.
+type TryExpression struct {
+    Token token.Token
+    Block *BlockStatement
+    Param *Identifier
+    Catch *BlockStatement
+    Finally *BlockStatement
+}
+
+func (te *TryExpression) expressionNode() {}
+func (te *TryExpression) TokenLiteral() string { return te.Token.Literal }
+func (te *TryExpression) String() string {
+    var out bytes.Buffer
 
-    if if_.Alternative != nil {
-        out.WriteString("else " + if_.Alternative.String())
+    out.WriteString("try " + te.Block.String())
+    if te.Catch != nil {
+        out.WriteString(" catch ")
+        if te.Param != nil {
+            out.WriteString("(" + te.Param.String() + ") ")
+        }
+        out.WriteString(te.Catch.String())
+    }
+    if te.Finally != nil {
+        out.WriteString(" finally " + te.Finally.String())
     }
     return out.String()
 }
 
+// BlockStatement is the statements between braces. Token is the opening
+// brace and Rbrace the closing one, which positions the end of the block.
 type BlockStatement struct {
     Token token.Token
     Statements []Statement
+    Rbrace token.Token
 }
 
 func (bs *BlockStatement) statementNode() {}
@@ -202,6 +377,7 @@ func (bs *BlockStatement) String() string {
 type FunctionLiteral struct {
     Token token.Token
     Parameters []*Identifier
+    ReturnType *TypeAnnotation `json:",omitempty"`
     Body *BlockStatement
 }
 
@@ -216,15 +392,46 @@ func (fl *FunctionLiteral) String() string {
     }
     out.WriteString(fl.TokenLiteral() + "(")
     out.WriteString(strings.Join(params,","))
-    out.WriteString(")" + fl.Body.String())
+    out.WriteString(")")
+    if fl.ReturnType != nil {
+        out.WriteString(" -> " + fl.ReturnType.String() + " ")
+    }
+    out.WriteString(fl.Body.String())
+
+    return out.String()
+}
+
+// MacroLiteral is `macro(params) { body }`. Macros are bound with a
+// top-level let and expanded before the program is evaluated.
+type MacroLiteral struct {
+    Token token.Token
+    Parameters []*Identifier
+    Body *BlockStatement
+}
+
+func (ml *MacroLiteral) expressionNode() {}
+func (ml *MacroLiteral) TokenLiteral() string { return ml.Token.Literal }
+func (ml *MacroLiteral) String() string {
+    var out bytes.Buffer
+
+    params := []string{}
+    for _, p := range ml.Parameters {
+        params = append(params, p.String())
+    }
+    out.WriteString(ml.TokenLiteral() + "(")
+    out.WriteString(strings.Join(params, ","))
+    out.WriteString(")" + ml.Body.String())
 
     return out.String()
 }
 
+// CallExpression is `function(arguments)`. Rparen is the closing
+// parenthesis, which positions the end of the arguments.
 type CallExpression struct {
     Token token.Token
     Function Expression
     Arguments []Expression
+    Rparen token.Token
 }
 
 func (c *CallExpression) expressionNode() {}
@@ -252,9 +459,23 @@ func (sl *StringLiteral) expressionNode() {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
 func (sl *StringLiteral) String() string { return sl.Token.Literal }
 
+// InterpolatedString is a string literal containing `${...}` segments.
+// Parts holds StringLiterals for the literal text and arbitrary expressions
+// for the embedded segments, in source order.
+type InterpolatedString struct {
+    Token token.Token
+    Parts []Expression
+}
+
+func (is *InterpolatedString) expressionNode() {}
+func (is *InterpolatedString) TokenLiteral() string { return is.Token.Literal }
+func (is *InterpolatedString) String() string { return is.Token.Literal }
+
+// ArrayLiteral is `[elements]`. Rbracket is the closing bracket.
 type ArrayLiteral struct {
     Token token.Token
     Elements []Expression
+    Rbracket token.Token
 }
 
 func (a *ArrayLiteral) expressionNode() {}
@@ -273,10 +494,13 @@ func (a *ArrayLiteral) String() string {
     return out.String()
 }
 
+// IndexExpression is `left[index]`. Optional marks the `left?.[index]` and
+// `left?.name` forms, which yield null instead of failing when left is null.
 type IndexExpression struct {
     Token token.Token
     Left Expression
     Index Expression
+    Optional bool
 }
 
 func (i *IndexExpression) expressionNode() {}
@@ -284,14 +508,76 @@ func (i *IndexExpression) TokenLiteral() string { return i.Token.Literal }
 func (i *IndexExpression) String() string {
     var out bytes.Buffer
 
-    out.WriteString("(" + i.Left.String() + "[" + i.Index.String() + "])")
+    out.WriteString("(" + i.Left.String())
+    if i.Optional {
+        out.WriteString("?.")
+    }
+    out.WriteString("[" + i.Index.String() + "])")
+
+    return out.String()
+}
+
+// SliceExpression is `left[start:end]`; Start and End are nil when omitted.
+type SliceExpression struct {
+    Token token.Token
+    Left Expression
+    Start Expression
+    End Expression
+}
+
+func (se *SliceExpression) expressionNode() {}
+func (se *SliceExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SliceExpression) String() string {
+    var out bytes.Buffer
+
+    out.WriteString("(" + se.Left.String() + "[")
+    if se.Start != nil {
+        out.WriteString(se.Start.String())
+    }
+    out.WriteString(":")
+    if se.End != nil {
+        out.WriteString(se.End.String())
+    }
+    out.WriteString("])")
 
     return out.String()
 }
 
+// RangeExpression is `start..end` (inclusive) or `start..<end` (exclusive);
+// Operator holds which of the two was written.
+type RangeExpression struct {
+    Token token.Token
+    Start Expression
+    Operator string
+    End Expression
+}
+
+func (re *RangeExpression) expressionNode() {}
+func (re *RangeExpression) TokenLiteral() string { return re.Token.Literal }
+func (re *RangeExpression) String() string {
+    return "(" + re.Start.String() + re.Operator + re.End.String() + ")"
+}
+
+// HashLiteral keeps its keys in source order in Order, since Pairs is an
+// unordered map. Rbrace is the closing brace.
 type HashLiteral struct {
     Token token.Token
     Pairs map[Expression]Expression
+    Order []Expression
+    Rbrace token.Token
+}
+
+// Keys returns the literal's keys in source order, falling back to map
+// order for literals built without Order.
+func (h *HashLiteral) Keys() []Expression {
+    if len(h.Order) == len(h.Pairs) {
+        return h.Order
+    }
+    keys := []Expression{}
+    for key := range h.Pairs {
+        keys = append(keys, key)
+    }
+    return keys
 }
 
 func (h *HashLiteral) expressionNode() {}
@@ -300,8 +586,8 @@ func (h *HashLiteral) String() string {
     var out bytes.Buffer
 
     pairs := []string{}
-    for key, value := range h.Pairs {
-        pairs = append(pairs, key.String() + ":" + value.String())
+    for _, key := range h.Keys() {
+        pairs = append(pairs, key.String() + ":" + h.Pairs[key].String())
     }
     out.WriteString("{")
     out.WriteString(strings.Join(pairs, ","))
@@ -309,3 +595,163 @@ func (h *HashLiteral) String() string {
 
     return out.String()
 }
+
+// Pattern is the left-hand side of a match arm. Patterns test the shape of
+// a value and bind the parts they name.
+type Pattern interface {
+    Node
+    patternNode()
+}
+
+// PatternNames returns the identifiers bound by pattern, in source order.
+func PatternNames(pattern Pattern) []*Identifier {
+	var names []*Identifier
+	switch pattern := pattern.(type) {
+	case *BindingPattern:
+		names = append(names, pattern.Name)
+	case *ArrayPattern:
+		for _, el := range pattern.Elements {
+			names = append(names, PatternNames(el)...)
+		}
+		if pattern.Rest != nil {
+			names = append(names, pattern.Rest)
+		}
+	case *HashPattern:
+		for _, value := range pattern.Values {
+			names = append(names, PatternNames(value)...)
+		}
+	}
+	return names
+}
+
+// WildcardPattern is `_`; it matches anything and binds nothing.
+type WildcardPattern struct {
+    Token token.Token
+}
+
+func (wp *WildcardPattern) patternNode() {}
+func (wp *WildcardPattern) TokenLiteral() string { return wp.Token.Literal }
+func (wp *WildcardPattern) String() string { return "_" }
+
+// BindingPattern matches anything and binds it to Name. Inside let
+// destructuring, Default is used when the element or key is missing.
+type BindingPattern struct {
+    Token token.Token
+    Name *Identifier
+    Default Expression
+}
+
+func (bp *BindingPattern) patternNode() {}
+func (bp *BindingPattern) TokenLiteral() string { return bp.Token.Literal }
+func (bp *BindingPattern) String() string {
+    if bp.Default != nil {
+        return bp.Name.String() + " = " + bp.Default.String()
+    }
+    return bp.Name.String()
+}
+
+// LiteralPattern matches values equal to an integer, string or boolean
+// literal.
+type LiteralPattern struct {
+    Token token.Token
+    Value Expression
+}
+
+func (lp *LiteralPattern) patternNode() {}
+func (lp *LiteralPattern) TokenLiteral() string { return lp.Token.Literal }
+func (lp *LiteralPattern) String() string {
+    switch value := lp.Value.(type) {
+    case *StringLiteral:
+        return `"` + value.String() + `"`
+    case *PrefixExpression:
+        return value.Operator + value.On.String()
+    default:
+        return value.String()
+    }
+}
+
+// ArrayPattern matches arrays element by element. Without Rest the array
+// must have exactly len(Elements) elements; with it, at least that many,
+// and the remainder is bound to Rest.
+type ArrayPattern struct {
+    Token token.Token
+    Elements []Pattern
+    Rest *Identifier
+}
+
+func (ap *ArrayPattern) patternNode() {}
+func (ap *ArrayPattern) TokenLiteral() string { return ap.Token.Literal }
+func (ap *ArrayPattern) String() string {
+    elements := []string{}
+    for _, el := range ap.Elements {
+        elements = append(elements, el.String())
+    }
+    if ap.Rest != nil {
+        elements = append(elements, "..."+ap.Rest.String())
+    }
+    return "[" + strings.Join(elements, ", ") + "]"
+}
+
+// HashPattern matches hashes that contain every key in Keys, matching the
+// value under Keys[i] against Values[i]. Other keys are ignored.
+type HashPattern struct {
+    Token token.Token
+    Keys []Expression
+    Values []Pattern
+}
+
+func (hp *HashPattern) patternNode() {}
+func (hp *HashPattern) TokenLiteral() string { return hp.Token.Literal }
+func (hp *HashPattern) String() string {
+    pairs := []string{}
+    for i, key := range hp.Keys {
+        k := key.String()
+        if _, ok := key.(*StringLiteral); ok {
+            k = `"` + k + `"`
+        }
+        pairs = append(pairs, k+": "+hp.Values[i].String())
+    }
+    return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+// MatchArm is `pattern if guard => body`; Guard is nil when absent and
+// Body is either an Expression or a *BlockStatement.
+type MatchArm struct {
+    Pattern Pattern
+    Guard Expression
+    Body Node
+}
+
+func (ma *MatchArm) String() string {
+    var out bytes.Buffer
+
+    out.WriteString(ma.Pattern.String())
+    if ma.Guard != nil {
+        out.WriteString(" if " + ma.Guard.String())
+    }
+    out.WriteString(" => ")
+    if block, ok := ma.Body.(*BlockStatement); ok {
+        out.WriteString("{ " + block.String() + " }")
+    } else {
+        out.WriteString(ma.Body.String())
+    }
+    return out.String()
+}
+
+// MatchExpression evaluates to the body of the first arm whose pattern
+// matches Subject and whose guard holds, or null if none does.
+type MatchExpression struct {
+    Token token.Token
+    Subject Expression
+    Arms []*MatchArm
+}
+
+func (me *MatchExpression) expressionNode() {}
+func (me *MatchExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MatchExpression) String() string {
+    arms := []string{}
+    for _, arm := range me.Arms {
+        arms = append(arms, arm.String())
+    }
+    return "match (" + me.Subject.String() + ") { " + strings.Join(arms, ", ") + " }"
+}
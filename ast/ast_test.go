@@ -2,6 +2,7 @@ package ast
 
 import (
 	"monkey/token"
+	"reflect"
 	"testing"
 )
 
@@ -25,3 +26,380 @@ func TestString(t *testing.T) {
 		t.Errorf("program.String() wrong. got=%q", program.String())
 	}
 }
+
+func TestModify(t *testing.T) {
+	one := func() Expression { return &IntegerLiteral{Value: 1} }
+	two := func() Expression { return &IntegerLiteral{Value: 2} }
+	ident := func(name string) *Identifier { return &Identifier{Value: name} }
+	block := func(e Expression) *BlockStatement {
+		return &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: e}}}
+	}
+
+	turnOneIntoTwo := func(node Node) Node {
+		integer, ok := node.(*IntegerLiteral)
+		if !ok {
+			return node
+		}
+		if integer.Value != 1 {
+			return node
+		}
+		integer.Value = 2
+		return integer
+	}
+
+	tests := []struct {
+		input    Node
+		expected Node
+	}{
+		{one(), two()},
+		{
+			&Program{Statements: []Statement{&ExpressionStatement{Expression: one()}}},
+			&Program{Statements: []Statement{&ExpressionStatement{Expression: two()}}},
+		},
+		{&InfixExpression{Left: one(), Operator: "+", Right: two()}, &InfixExpression{Left: two(), Operator: "+", Right: two()}},
+		{&InfixExpression{Left: two(), Operator: "+", Right: one()}, &InfixExpression{Left: two(), Operator: "+", Right: two()}},
+		{&PrefixExpression{Operator: "-", On: one()}, &PrefixExpression{Operator: "-", On: two()}},
+		{&IndexExpression{Left: one(), Index: one()}, &IndexExpression{Left: two(), Index: two()}},
+		{&SliceExpression{Left: one(), End: one()}, &SliceExpression{Left: two(), End: two()}},
+		{&RangeExpression{Start: one(), Operator: "..", End: one()}, &RangeExpression{Start: two(), Operator: "..", End: two()}},
+		{
+			&IfExpression{Condition: one(), Consequence: block(one()), Alternative: block(one())},
+			&IfExpression{Condition: two(), Consequence: block(two()), Alternative: block(two())},
+		},
+		{
+			&TryExpression{Block: block(one()), Param: ident("e"), Catch: block(one()), Finally: block(one())},
+			&TryExpression{Block: block(two()), Param: ident("e"), Catch: block(two()), Finally: block(two())},
+		},
+		{&ReturnStatement{Value: one()}, &ReturnStatement{Value: two()}},
+		{&ThrowStatement{Value: one()}, &ThrowStatement{Value: two()}},
+		{&DeferStatement{Call: one()}, &DeferStatement{Call: two()}},
+		{&LetStatement{Bind: ident("x"), Value: one()}, &LetStatement{Bind: ident("x"), Value: two()}},
+		{
+			&ExportStatement{Statement: &LetStatement{Bind: ident("x"), Value: one()}},
+			&ExportStatement{Statement: &LetStatement{Bind: ident("x"), Value: two()}},
+		},
+		{
+			&LetStatement{Pattern: &ArrayPattern{Elements: []Pattern{&BindingPattern{Name: ident("a"), Default: one()}}}, Value: one()},
+			&LetStatement{Pattern: &ArrayPattern{Elements: []Pattern{&BindingPattern{Name: ident("a"), Default: two()}}}, Value: two()},
+		},
+		{
+			&FunctionLiteral{Parameters: []*Identifier{}, Body: block(one())},
+			&FunctionLiteral{Parameters: []*Identifier{}, Body: block(two())},
+		},
+		{
+			&MacroLiteral{Parameters: []*Identifier{}, Body: block(one())},
+			&MacroLiteral{Parameters: []*Identifier{}, Body: block(two())},
+		},
+		{&CallExpression{Function: one(), Arguments: []Expression{one()}}, &CallExpression{Function: two(), Arguments: []Expression{two()}}},
+		{&ArrayLiteral{Elements: []Expression{one(), one()}}, &ArrayLiteral{Elements: []Expression{two(), two()}}},
+		{&InterpolatedString{Parts: []Expression{&StringLiteral{Value: "n="}, one()}}, &InterpolatedString{Parts: []Expression{&StringLiteral{Value: "n="}, two()}}},
+		{
+			&MatchExpression{Subject: one(), Arms: []*MatchArm{
+				{Pattern: &LiteralPattern{Value: one()}, Guard: one(), Body: one()},
+				{Pattern: &HashPattern{Keys: []Expression{one()}, Values: []Pattern{&WildcardPattern{}}}, Body: block(one())},
+			}},
+			&MatchExpression{Subject: two(), Arms: []*MatchArm{
+				{Pattern: &LiteralPattern{Value: two()}, Guard: two(), Body: two()},
+				{Pattern: &HashPattern{Keys: []Expression{two()}, Values: []Pattern{&WildcardPattern{}}}, Body: block(two())},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		modified := Modify(tt.input, turnOneIntoTwo)
+
+		if !reflect.DeepEqual(modified, tt.expected) {
+			t.Errorf("not equal. got=%#v, want=%#v", modified, tt.expected)
+		}
+	}
+
+	hashLiteral := &HashLiteral{
+		Pairs: map[Expression]Expression{one(): one()},
+	}
+	Modify(hashLiteral, turnOneIntoTwo)
+
+	for key, val := range hashLiteral.Pairs {
+		key, _ := key.(*IntegerLiteral)
+		if key.Value != 2 {
+			t.Errorf("value is not %d, got=%d", 2, key.Value)
+		}
+		val, _ := val.(*IntegerLiteral)
+		if val.Value != 2 {
+			t.Errorf("value is not %d, got=%d", 2, val.Value)
+		}
+	}
+	if len(hashLiteral.Order) != 1 || hashLiteral.Order[0].(*IntegerLiteral).Value != 2 {
+		t.Errorf("hash order not updated. got=%v", hashLiteral.Order)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	ident := func(name string) *Identifier { return &Identifier{Value: name} }
+	block := func(e Expression) *BlockStatement {
+		return &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: e}}}
+	}
+	key := &StringLiteral{Value: "k"}
+
+	program := &Program{Statements: []Statement{
+		&LetStatement{Bind: ident("h"), Value: &HashLiteral{
+			Pairs: map[Expression]Expression{key: ident("v")},
+			Order: []Expression{key},
+		}},
+		&ExpressionStatement{Expression: &IfExpression{
+			Condition:   ident("c"),
+			Consequence: block(ident("yes")),
+			Alternative: block(&CallExpression{Function: ident("f"), Arguments: []Expression{ident("arg")}}),
+		}},
+		&ExpressionStatement{Expression: &SliceExpression{Left: ident("s"), End: ident("end")}},
+		(*LetStatement)(nil),
+	}}
+
+	var names []string
+	depth, maxDepth := 0, 0
+	Inspect(program, func(node Node) bool {
+		if node == nil {
+			depth--
+			return false
+		}
+		depth++
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+		switch node := node.(type) {
+		case *Identifier:
+			names = append(names, node.Value)
+		case *StringLiteral:
+			names = append(names, `"`+node.Value+`"`)
+		}
+		return true
+	})
+
+	expected := []string{"h", `"k"`, "v", "c", "yes", "f", "arg", "s", "end"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("wrong visit order. want=%v got=%v", expected, names)
+	}
+	if depth != 0 {
+		t.Errorf("Visit(nil) not called once per visited node. depth=%d", depth)
+	}
+	if maxDepth != 7 {
+		t.Errorf("wrong maximum depth. want=7 got=%d", maxDepth)
+	}
+
+	// Returning false skips a node's children.
+	var skipped []string
+	Inspect(program, func(node Node) bool {
+		if ident, ok := node.(*Identifier); ok {
+			skipped = append(skipped, ident.Value)
+		}
+		_, isIf := node.(*IfExpression)
+		return !isIf
+	})
+	expected = []string{"h", "v", "s", "end"}
+	if !reflect.DeepEqual(skipped, expected) {
+		t.Errorf("wrong identifiers outside ifs. want=%v got=%v", expected, skipped)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	ident := func(name string) *Identifier { return &Identifier{Value: name} }
+	program := &Program{Statements: []Statement{
+		&ExpressionStatement{Expression: &InfixExpression{Left: ident("a"), Operator: "+", Right: ident("b")}},
+		&DeferStatement{Call: ident("a")},
+		&ExpressionStatement{Expression: &FunctionLiteral{
+			Parameters: []*Identifier{ident("a")},
+			Body:       &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: ident("a")}}},
+		}},
+	}}
+
+	var post []string
+	rewritten := Rewrite(program,
+		func(node Node) (Node, bool) {
+			switch node := node.(type) {
+			case *DeferStatement:
+				return nil, false
+			case *FunctionLiteral:
+				return node, false
+			case *Identifier:
+				if node.Value == "a" {
+					return ident("x"), true
+				}
+			}
+			return node, true
+		},
+		func(node Node) Node {
+			if ident, ok := node.(*Identifier); ok {
+				post = append(post, ident.Value)
+			}
+			return node
+		},
+	)
+
+	if rewritten.String() != "(x + b)(a)a" {
+		t.Errorf("wrong rewrite. got=%q", rewritten.String())
+	}
+	if !reflect.DeepEqual(post, []string{"x", "b"}) {
+		t.Errorf("post not called bottom-up on replacements. got=%v", post)
+	}
+
+	// A replacement of the wrong kind clears the field.
+	let := &LetStatement{Bind: ident("y"), Value: ident("z")}
+	Rewrite(let, nil, func(node Node) Node {
+		if ident, ok := node.(*Identifier); ok && ident.Value == "z" {
+			return &BlockStatement{}
+		}
+		return node
+	})
+	if let.Value != nil {
+		t.Errorf("let value should be nil. got=%v", let.Value)
+	}
+}
+
+func TestUnmarshalJSONErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"kind": "Nope"}`, `unknown node kind "Nope"`},
+		{`{"value": 1}`, `node without a kind: {"value": 1}`},
+		{`{"kind": "ReturnStatement", "value": {"kind": "BlockStatement"}}`,
+			"ReturnStatement: value: BlockStatement cannot be used as ast.Expression"},
+		{`{"kind": "Program", "statements": [{"kind": "Identifier"}]}`,
+			"Program: statements: [0]: Identifier cannot be used as ast.Statement"},
+		{`{"kind": "IntegerLiteral", "value": "5"}`,
+			"IntegerLiteral: value: json: cannot unmarshal string into Go value of type int64"},
+	}
+	for _, tt := range tests {
+		_, err := UnmarshalJSON([]byte(tt.input))
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%s: wrong error. want=%q got=%v", tt.input, tt.expected, err)
+		}
+	}
+
+	if _, err := UnmarshalProgram([]byte(`{"kind": "Identifier", "value": "x"}`)); err == nil ||
+		err.Error() != "expected a Program, got Identifier" {
+		t.Errorf("wrong error for a non-program. got=%v", err)
+	}
+	if node, err := UnmarshalJSON([]byte(`null`)); node != nil || err != nil {
+		t.Errorf("null should decode to no node. got=%v, %v", node, err)
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	let := &LetStatement{
+		Token: token.Token{Type: token.LET, Literal: "let"},
+		Bind:  &Identifier{Token: token.Token{Type: token.IDENT, Literal: "x"}, Value: "x"},
+		Value: &IntegerLiteral{Token: token.Token{Type: token.INT, Literal: "5"}, Value: 5},
+	}
+	data, err := MarshalJSON(let)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"kind":"LetStatement","token":{"type":"LET","literal":"let","line":0,"column":0},` +
+		`"bind":{"kind":"Identifier","token":{"type":"IDENT","literal":"x","line":0,"column":0},"value":"x"},` +
+		`"pattern":null,` +
+		`"value":{"kind":"IntegerLiteral","token":{"type":"INT","literal":"5","line":0,"column":0},"value":5}}`
+	if string(data) != expected {
+		t.Errorf("wrong JSON.\nwant=%s\ngot=%s", expected, data)
+	}
+
+	let.Bind.Type = &TypeAnnotation{Token: token.Token{Type: token.IDENT, Literal: "int"}, Name: "int"}
+	data, err = MarshalJSON(let.Bind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = `{"kind":"Identifier","token":{"type":"IDENT","literal":"x","line":0,"column":0},"value":"x",` +
+		`"type":{"kind":"TypeAnnotation","token":{"type":"IDENT","literal":"int","line":0,"column":0},"name":"int"}}`
+	if string(data) != expected {
+		t.Errorf("wrong JSON for an annotation.\nwant=%s\ngot=%s", expected, data)
+	}
+}
+
+func TestUnmarshalJSONRequired(t *testing.T) {
+	// The error for each kind of node with no fields, "" if it decodes.
+	empty := map[string]string{
+		"Program":             "",
+		"LetStatement":        "missing value",
+		"ReturnStatement":     "",
+		"ThrowStatement":      "missing value",
+		"DeferStatement":      "missing call",
+		"ImportStatement":     "missing path",
+		"ExportStatement":     "missing statement",
+		"ExpressionStatement": "missing expression",
+		"BlockStatement":      "",
+		"Identifier":          "",
+		"IntegerLiteral":      "",
+		"Boolean":             "",
+		"StringLiteral":       "",
+		"InterpolatedString":  "",
+		"PrefixExpression":    "missing on",
+		"InfixExpression":     "missing left",
+		"IfExpression":        "missing condition",
+		"TryExpression":       "missing block",
+		"FunctionLiteral":     "missing body",
+		"MacroLiteral":        "missing body",
+		"CallExpression":      "missing function",
+		"ArrayLiteral":        "",
+		"IndexExpression":     "missing left",
+		"SliceExpression":     "missing left",
+		"RangeExpression":     "missing start",
+		"HashLiteral":         "",
+		"MatchExpression":     "missing subject",
+		"WildcardPattern":     "",
+		"BindingPattern":      "missing name",
+		"LiteralPattern":      "missing value",
+		"ArrayPattern":        "",
+		"HashPattern":         "",
+		"TypeAnnotation":      "",
+	}
+	for kind := range nodeKinds {
+		want, ok := empty[kind]
+		if !ok {
+			t.Errorf("no case for %s", kind)
+			continue
+		}
+		if want != "" {
+			want = kind + ": " + want
+		}
+		got := ""
+		if _, err := UnmarshalJSON([]byte(`{"kind": "` + kind + `"}`)); err != nil {
+			got = err.Error()
+		}
+		if got != want {
+			t.Errorf("empty %s: wrong error. want=%q got=%q", kind, want, got)
+		}
+	}
+
+	ident := `{"kind": "Identifier", "value": "x"}`
+	one := `{"kind": "IntegerLiteral", "value": 1}`
+	block := `{"kind": "BlockStatement", "statements": []}`
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"kind": "InfixExpression", "left": ` + one + `, "operator": "+"}`, "InfixExpression: missing right"},
+		{`{"kind": "LetStatement", "value": ` + one + `}`, "LetStatement: missing bind or pattern"},
+		{`{"kind": "IfExpression", "condition": ` + one + `, "alternative": ` + block + `}`, "IfExpression: missing consequence"},
+		{`{"kind": "IfExpression", "condition": ` + one + `, "consequence": ` + block + `}`, ""},
+		{`{"kind": "IndexExpression", "left": ` + ident + `}`, "IndexExpression: missing index"},
+		{`{"kind": "SliceExpression", "left": ` + ident + `, "start": null}`, ""},
+		{`{"kind": "Program", "statements": [null]}`, "Program: statements: [0]: missing node"},
+		{`{"kind": "CallExpression", "function": ` + ident + `, "arguments": [` + one + `, null]}`,
+			"CallExpression: arguments: [1]: missing node"},
+		{`{"kind": "HashLiteral", "pairs": [{"key": ` + one + `}]}`, "HashLiteral: pairs: [0]: missing key or value"},
+		{`{"kind": "MatchExpression", "subject": ` + one + `, "arms": [{"pattern": {"kind": "WildcardPattern"}}]}`,
+			"MatchExpression: arms: [0]: missing body"},
+		{`{"kind": "MatchExpression", "subject": ` + one + `, "arms": [null]}`, "MatchExpression: arms: [0]: missing node"},
+		{`{"kind": "HashPattern", "keys": [` + one + `]}`, "HashPattern: keys and values differ in length"},
+		{`{"kind": "ImportStatement", "path": {"kind": "StringLiteral"}, "names": [` + ident + `]}`,
+			"ImportStatement: names and bindings differ in length"},
+	}
+	for _, tt := range tests {
+		got := ""
+		if _, err := UnmarshalJSON([]byte(tt.input)); err != nil {
+			got = err.Error()
+		}
+		if got != tt.expected {
+			t.Errorf("%s: wrong error. want=%q got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
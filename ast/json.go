@@ -0,0 +1,431 @@
+package ast
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"monkey/token"
+	"reflect"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Nodes are serialized as JSON objects whose "kind" is the Go type name,
+// followed by the node's fields in declaration order under lowerCamel
+// names, e.g.
+//
+//	{"kind": "Identifier",
+//	 "token": {"type": "IDENT", "literal": "x", "line": 1, "column": 5},
+//	 "value": "x"}
+//
+// Missing children are null, except type annotations, which are left out
+// when absent. A HashLiteral stores its pairs in source
+// order as [{"key": ..., "value": ...}] instead of Pairs and Order, and
+// fields tagged `json:"-"`, which the resolver fills in, are left out.
+//
+// Decoding fails when a child the parser always sets is null or missing,
+// so a tree read back can be run like a parsed one.
+
+// nodeKinds lists every node type that can be serialized; new node types
+// must be added here.
+var nodeKinds = kindsOf(
+	&Program{},
+	&LetStatement{},
+	&ReturnStatement{},
+	&ThrowStatement{},
+	&DeferStatement{},
+	&ImportStatement{},
+	&ExportStatement{},
+	&ExpressionStatement{},
+	&BlockStatement{},
+	&Identifier{},
+	&IntegerLiteral{},
+	&Boolean{},
+	&StringLiteral{},
+	&InterpolatedString{},
+	&PrefixExpression{},
+	&InfixExpression{},
+	&IfExpression{},
+	&TryExpression{},
+	&FunctionLiteral{},
+	&MacroLiteral{},
+	&CallExpression{},
+	&ArrayLiteral{},
+	&IndexExpression{},
+	&SliceExpression{},
+	&RangeExpression{},
+	&HashLiteral{},
+	&MatchExpression{},
+	&WildcardPattern{},
+	&BindingPattern{},
+	&LiteralPattern{},
+	&ArrayPattern{},
+	&HashPattern{},
+	&TypeAnnotation{},
+)
+
+// optionalChildren lists, by kind, the children that may be null. Every
+// other child, and every element of a list of children, is required.
+var optionalChildren = map[string][]string{
+	"LetStatement":    {"bind", "pattern"}, // one of them, checked by validate
+	"ReturnStatement": {"value"},
+	"ImportStatement": {"alias"},
+	"IfExpression":    {"alternative"},
+	"TryExpression":   {"param", "catch", "finally"},
+	"SliceExpression": {"start", "end"},
+	"BindingPattern":  {"default"},
+	"ArrayPattern":    {"rest"},
+	"MatchArm":        {"guard"},
+}
+
+func kindsOf(nodes ...Node) map[string]reflect.Type {
+	kinds := map[string]reflect.Type{}
+	for _, node := range nodes {
+		t := reflect.TypeOf(node).Elem()
+		kinds[t.Name()] = t
+	}
+	return kinds
+}
+
+var (
+	nodeType      = reflect.TypeOf((*Node)(nil)).Elem()
+	tokenType     = reflect.TypeOf(token.Token{})
+	matchArmType  = reflect.TypeOf(MatchArm{})
+	hashLiteralTy = reflect.TypeOf(HashLiteral{})
+)
+
+// MarshalJSON serializes the tree rooted at node.
+func MarshalJSON(node Node) ([]byte, error) {
+	return json.Marshal(encodeNode(node))
+}
+
+// UnmarshalJSON rebuilds a tree serialized by MarshalJSON.
+func UnmarshalJSON(data []byte) (Node, error) {
+	return decodeNode(data)
+}
+
+// UnmarshalProgram is UnmarshalJSON for data holding a whole Program.
+func UnmarshalProgram(data []byte) (*Program, error) {
+	node, err := decodeNode(data)
+	if err != nil {
+		return nil, err
+	}
+	program, ok := node.(*Program)
+	if !ok {
+		return nil, fmt.Errorf("expected a Program, got %s", kindOf(node))
+	}
+	return program, nil
+}
+
+// jsonObject is a JSON object that keeps its fields in order, so "kind"
+// comes first and fields follow the struct declaration.
+type jsonObject []jsonField
+
+type jsonField struct {
+	name  string
+	value interface{}
+}
+
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var out bytes.Buffer
+	out.WriteString("{")
+	for i, field := range o {
+		if i > 0 {
+			out.WriteString(",")
+		}
+		name, _ := json.Marshal(field.name)
+		value, err := json.Marshal(field.value)
+		if err != nil {
+			return nil, err
+		}
+		out.Write(name)
+		out.WriteString(":")
+		out.Write(value)
+	}
+	out.WriteString("}")
+	return out.Bytes(), nil
+}
+
+func encodeNode(node Node) interface{} {
+	if isNil(node) {
+		return nil
+	}
+
+	v := reflect.ValueOf(node).Elem()
+	obj := jsonObject{{"kind", v.Type().Name()}}
+	if hash, ok := node.(*HashLiteral); ok {
+		pairs := []interface{}{}
+		for _, key := range hash.Keys() {
+			pairs = append(pairs, jsonObject{
+				{"key", encodeNode(key)},
+				{"value", encodeNode(hash.Pairs[key])},
+			})
+		}
+		return append(obj,
+			jsonField{"token", encodeToken(hash.Token)},
+			jsonField{"pairs", pairs},
+			jsonField{"rbrace", encodeToken(hash.Rbrace)})
+	}
+	return append(obj, encodeFields(v)...)
+}
+
+func encodeFields(v reflect.Value) jsonObject {
+	obj := jsonObject{}
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if skipField(field) || omitField(field, v.Field(i)) {
+			continue
+		}
+		obj = append(obj, jsonField{fieldName(field.Name), encodeValue(v.Field(i))})
+	}
+	return obj
+}
+
+func encodeValue(v reflect.Value) interface{} {
+	switch {
+	case v.Type() == tokenType:
+		return encodeToken(v.Interface().(token.Token))
+	case v.Type().Implements(nodeType):
+		if v.IsNil() {
+			return nil
+		}
+		return encodeNode(v.Interface().(Node))
+	case v.Kind() == reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		return encodeFields(v.Elem())
+	case v.Kind() == reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+		list := make([]interface{}, v.Len())
+		for i := range list {
+			list[i] = encodeValue(v.Index(i))
+		}
+		return list
+	default:
+		return v.Interface()
+	}
+}
+
+func encodeToken(tok token.Token) jsonObject {
+	return jsonObject{{"type", tok.Type}, {"literal", tok.Literal}, {"line", tok.Line}, {"column", tok.Column}}
+}
+
+func decodeNode(data []byte) (Node, error) {
+	if isNull(data) {
+		return nil, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	var kind string
+	if err := json.Unmarshal(fields["kind"], &kind); err != nil {
+		return nil, fmt.Errorf("node without a kind: %s", data)
+	}
+	t, ok := nodeKinds[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown node kind %q", kind)
+	}
+
+	node := reflect.New(t)
+	if t == hashLiteralTy {
+		if err := decodeHashLiteral(node.Interface().(*HashLiteral), fields); err != nil {
+			return nil, err
+		}
+		return node.Interface().(Node), nil
+	}
+	if err := decodeFields(kind, node.Elem(), fields); err != nil {
+		return nil, fmt.Errorf("%s: %w", kind, err)
+	}
+	if err := validate(node.Interface().(Node)); err != nil {
+		return nil, fmt.Errorf("%s: %w", kind, err)
+	}
+	return node.Interface().(Node), nil
+}
+
+// decodeFields decodes the fields of v, a node of the given kind or a
+// MatchArm, and checks that its required children are present.
+func decodeFields(kind string, v reflect.Value, fields map[string]json.RawMessage) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if skipField(field) {
+			continue
+		}
+		name := fieldName(field.Name)
+		if data, ok := fields[name]; ok {
+			if err := decodeValue(data, v.Field(i)); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		if isChild(field.Type) && v.Field(i).IsNil() && !optionalChild(kind, field) {
+			return fmt.Errorf("missing %s", name)
+		}
+	}
+	return nil
+}
+
+// isChild reports whether values of type t are child nodes.
+func isChild(t reflect.Type) bool {
+	return t.Implements(nodeType) || t == reflect.PtrTo(matchArmType)
+}
+
+func optionalChild(kind string, field reflect.StructField) bool {
+	if strings.HasSuffix(field.Tag.Get("json"), ",omitempty") {
+		return true
+	}
+	for _, name := range optionalChildren[kind] {
+		if name == fieldName(field.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks what a node needs beyond its required children.
+func validate(node Node) error {
+	switch node := node.(type) {
+	case *LetStatement:
+		if node.Bind == nil && node.Pattern == nil {
+			return errors.New("missing bind or pattern")
+		}
+	case *ImportStatement:
+		if len(node.Names) != len(node.Bindings) {
+			return errors.New("names and bindings differ in length")
+		}
+	case *HashPattern:
+		if len(node.Keys) != len(node.Values) {
+			return errors.New("keys and values differ in length")
+		}
+	}
+	return nil
+}
+
+func decodeValue(data []byte, v reflect.Value) error {
+	switch {
+	case v.Type() == tokenType:
+		var tok struct {
+			Type    token.TokenType `json:"type"`
+			Literal string          `json:"literal"`
+			Line    int             `json:"line"`
+			Column  int             `json:"column"`
+		}
+		if err := json.Unmarshal(data, &tok); err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(token.Token(tok)))
+	case v.Type().Implements(nodeType):
+		node, err := decodeNode(data)
+		if err != nil || node == nil {
+			return err
+		}
+		if !reflect.TypeOf(node).AssignableTo(v.Type()) {
+			return fmt.Errorf("%s cannot be used as %s", kindOf(node), v.Type())
+		}
+		v.Set(reflect.ValueOf(node))
+	case v.Type() == reflect.PtrTo(matchArmType):
+		if isNull(data) {
+			return nil
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return err
+		}
+		arm := reflect.New(matchArmType)
+		if err := decodeFields("MatchArm", arm.Elem(), fields); err != nil {
+			return err
+		}
+		v.Set(arm)
+	case v.Kind() == reflect.Slice:
+		if isNull(data) {
+			return nil
+		}
+		var items []json.RawMessage
+		if err := json.Unmarshal(data, &items); err != nil {
+			return err
+		}
+		slice := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := decodeValue(item, slice.Index(i)); err != nil {
+				return fmt.Errorf("[%d]: %w", i, err)
+			}
+			if isChild(v.Type().Elem()) && slice.Index(i).IsNil() {
+				return fmt.Errorf("[%d]: missing node", i)
+			}
+		}
+		v.Set(slice)
+	default:
+		return json.Unmarshal(data, v.Addr().Interface())
+	}
+	return nil
+}
+
+func decodeHashLiteral(hash *HashLiteral, fields map[string]json.RawMessage) error {
+	if data, ok := fields["token"]; ok {
+		if err := decodeValue(data, reflect.ValueOf(&hash.Token).Elem()); err != nil {
+			return fmt.Errorf("HashLiteral: token: %w", err)
+		}
+	}
+	if data, ok := fields["rbrace"]; ok {
+		if err := decodeValue(data, reflect.ValueOf(&hash.Rbrace).Elem()); err != nil {
+			return fmt.Errorf("HashLiteral: rbrace: %w", err)
+		}
+	}
+	var pairs []struct {
+		Key   json.RawMessage `json:"key"`
+		Value json.RawMessage `json:"value"`
+	}
+	if data, ok := fields["pairs"]; ok && !isNull(data) {
+		if err := json.Unmarshal(data, &pairs); err != nil {
+			return fmt.Errorf("HashLiteral: pairs: %w", err)
+		}
+	}
+
+	hash.Pairs = make(map[Expression]Expression)
+	hash.Order = []Expression{}
+	for i, pair := range pairs {
+		var key, value Expression
+		if err := decodeValue(pair.Key, reflect.ValueOf(&key).Elem()); err != nil {
+			return fmt.Errorf("HashLiteral: pairs: [%d]: %w", i, err)
+		}
+		if err := decodeValue(pair.Value, reflect.ValueOf(&value).Elem()); err != nil {
+			return fmt.Errorf("HashLiteral: pairs: [%d]: %w", i, err)
+		}
+		if key == nil || value == nil {
+			return fmt.Errorf("HashLiteral: pairs: [%d]: missing key or value", i)
+		}
+		hash.Pairs[key] = value
+		hash.Order = append(hash.Order, key)
+	}
+	return nil
+}
+
+func skipField(field reflect.StructField) bool {
+	return field.Tag.Get("json") == "-"
+}
+
+// omitField reports whether value is an empty field tagged omitempty.
+func omitField(field reflect.StructField, value reflect.Value) bool {
+	return strings.HasSuffix(field.Tag.Get("json"), ",omitempty") && value.IsZero()
+}
+
+func fieldName(name string) string {
+	r, size := utf8.DecodeRuneInString(name)
+	return string(unicode.ToLower(r)) + name[size:]
+}
+
+func kindOf(node Node) string {
+	if isNil(node) {
+		return "null"
+	}
+	return reflect.TypeOf(node).Elem().Name()
+}
+
+func isNull(data []byte) bool {
+	return len(data) == 0 || string(bytes.TrimSpace(data)) == "null"
+}
@@ -0,0 +1,205 @@
+package ast
+
+type ModifierFunc func(Node) Node
+
+// Modify rewrites the tree rooted at node bottom-up: the children of each
+// node are modified first, then modifier is applied to the node itself
+// and its result takes the node's place in the parent. It is Rewrite with
+// only a post function.
+func Modify(node Node, modifier ModifierFunc) Node {
+	return Rewrite(node, nil, modifier)
+}
+
+// Rewrite replaces nodes in the tree rooted at node and returns the new
+// root. pre is called on each node before its children and returns the
+// node to use in its place and whether to rewrite that node's children;
+// post is called after the children and returns the final replacement.
+// Either function may be nil.
+//
+// A replacement of the wrong kind for its position (say, a statement
+// where an expression belongs) leaves that field nil, and a nil
+// replacement for a statement in a program or block removes it.
+func Rewrite(node Node, pre func(Node) (Node, bool), post func(Node) Node) Node {
+	r := &rewriter{pre: pre, post: post}
+	return r.rewrite(node)
+}
+
+type rewriter struct {
+	pre  func(Node) (Node, bool)
+	post func(Node) Node
+}
+
+func (r *rewriter) rewrite(node Node) Node {
+	if isNil(node) {
+		return node
+	}
+
+	descend := true
+	if r.pre != nil {
+		node, descend = r.pre(node)
+		if isNil(node) {
+			return nil
+		}
+	}
+	if descend {
+		r.rewriteChildren(node)
+	}
+	if r.post != nil {
+		node = r.post(node)
+	}
+	return node
+}
+
+func (r *rewriter) rewriteChildren(node Node) {
+	switch node := node.(type) {
+	case *Program:
+		node.Statements = r.statements(node.Statements)
+	case *ExpressionStatement:
+		node.Expression = r.expression(node.Expression)
+	case *LetStatement:
+		if node.Pattern != nil {
+			node.Pattern = r.pattern(node.Pattern)
+		} else {
+			node.Bind = r.identifier(node.Bind)
+		}
+		node.Value = r.expression(node.Value)
+	case *ReturnStatement:
+		node.Value = r.expression(node.Value)
+	case *ThrowStatement:
+		node.Value = r.expression(node.Value)
+	case *DeferStatement:
+		node.Call = r.expression(node.Call)
+	case *ImportStatement:
+		node.Path, _ = r.rewrite(node.Path).(*StringLiteral)
+		node.Alias = r.identifier(node.Alias)
+		for i := range node.Names {
+			node.Names[i] = r.identifier(node.Names[i])
+			node.Bindings[i] = r.identifier(node.Bindings[i])
+		}
+	case *ExportStatement:
+		node.Statement, _ = r.rewrite(node.Statement).(*LetStatement)
+	case *BlockStatement:
+		node.Statements = r.statements(node.Statements)
+	case *PrefixExpression:
+		node.On = r.expression(node.On)
+	case *InfixExpression:
+		node.Left = r.expression(node.Left)
+		node.Right = r.expression(node.Right)
+	case *IfExpression:
+		node.Condition = r.expression(node.Condition)
+		node.Consequence = r.block(node.Consequence)
+		node.Alternative = r.block(node.Alternative)
+	case *TryExpression:
+		node.Block = r.block(node.Block)
+		node.Param = r.identifier(node.Param)
+		node.Catch = r.block(node.Catch)
+		node.Finally = r.block(node.Finally)
+	case *FunctionLiteral:
+		for i := range node.Parameters {
+			node.Parameters[i] = r.identifier(node.Parameters[i])
+		}
+		node.Body = r.block(node.Body)
+	case *MacroLiteral:
+		for i := range node.Parameters {
+			node.Parameters[i] = r.identifier(node.Parameters[i])
+		}
+		node.Body = r.block(node.Body)
+	case *CallExpression:
+		node.Function = r.expression(node.Function)
+		r.expressions(node.Arguments)
+	case *InterpolatedString:
+		r.expressions(node.Parts)
+	case *ArrayLiteral:
+		r.expressions(node.Elements)
+	case *IndexExpression:
+		node.Left = r.expression(node.Left)
+		node.Index = r.expression(node.Index)
+	case *SliceExpression:
+		node.Left = r.expression(node.Left)
+		node.Start = r.expression(node.Start)
+		node.End = r.expression(node.End)
+	case *RangeExpression:
+		node.Start = r.expression(node.Start)
+		node.End = r.expression(node.End)
+	case *HashLiteral:
+		pairs := make(map[Expression]Expression)
+		order := []Expression{}
+		for _, key := range node.Keys() {
+			newKey := r.expression(key)
+			pairs[newKey] = r.expression(node.Pairs[key])
+			order = append(order, newKey)
+		}
+		node.Pairs = pairs
+		node.Order = order
+	case *MatchExpression:
+		node.Subject = r.expression(node.Subject)
+		for _, arm := range node.Arms {
+			arm.Pattern = r.pattern(arm.Pattern)
+			arm.Guard = r.expression(arm.Guard)
+			arm.Body = r.rewrite(arm.Body)
+		}
+	case *BindingPattern:
+		node.Name = r.identifier(node.Name)
+		node.Default = r.expression(node.Default)
+	case *LiteralPattern:
+		node.Value = r.expression(node.Value)
+	case *ArrayPattern:
+		for i, el := range node.Elements {
+			node.Elements[i] = r.pattern(el)
+		}
+		node.Rest = r.identifier(node.Rest)
+	case *HashPattern:
+		for i := range node.Keys {
+			node.Keys[i] = r.expression(node.Keys[i])
+			node.Values[i] = r.pattern(node.Values[i])
+		}
+	}
+}
+
+func (r *rewriter) statements(stmts []Statement) []Statement {
+	result := stmts[:0]
+	for _, stmt := range stmts {
+		if replaced, ok := r.rewrite(stmt).(Statement); ok && !isNil(replaced) {
+			result = append(result, replaced)
+		}
+	}
+	return result
+}
+
+func (r *rewriter) expressions(exps []Expression) {
+	for i, exp := range exps {
+		exps[i] = r.expression(exp)
+	}
+}
+
+func (r *rewriter) expression(exp Expression) Expression {
+	if exp == nil {
+		return nil
+	}
+	replaced, _ := r.rewrite(exp).(Expression)
+	return replaced
+}
+
+func (r *rewriter) pattern(pattern Pattern) Pattern {
+	if pattern == nil {
+		return nil
+	}
+	replaced, _ := r.rewrite(pattern).(Pattern)
+	return replaced
+}
+
+func (r *rewriter) block(block *BlockStatement) *BlockStatement {
+	if block == nil {
+		return nil
+	}
+	replaced, _ := r.rewrite(block).(*BlockStatement)
+	return replaced
+}
+
+func (r *rewriter) identifier(ident *Identifier) *Identifier {
+	if ident == nil {
+		return nil
+	}
+	replaced, _ := r.rewrite(ident).(*Identifier)
+	return replaced
+}
@@ -0,0 +1,165 @@
+package ast
+
+import "reflect"
+
+// A Visitor's Visit method is invoked for each node encountered by Walk.
+// If the result visitor w is not nil, Walk visits each of the children of
+// node with w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses the tree rooted at node in depth-first order, children
+// in source order. Missing optional children (an if without else, a
+// slice bound that was left out) are skipped.
+func Walk(v Visitor, node Node) {
+	if isNil(node) {
+		return
+	}
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		walkStatements(v, n.Statements)
+	case *ExpressionStatement:
+		Walk(v, n.Expression)
+	case *LetStatement:
+		if n.Pattern != nil {
+			Walk(v, n.Pattern)
+		} else {
+			Walk(v, n.Bind)
+		}
+		Walk(v, n.Value)
+	case *ReturnStatement:
+		Walk(v, n.Value)
+	case *ThrowStatement:
+		Walk(v, n.Value)
+	case *DeferStatement:
+		Walk(v, n.Call)
+	case *ImportStatement:
+		Walk(v, n.Path)
+		Walk(v, n.Alias)
+		for i := range n.Names {
+			Walk(v, n.Names[i])
+			Walk(v, n.Bindings[i])
+		}
+	case *ExportStatement:
+		Walk(v, n.Statement)
+	case *BlockStatement:
+		walkStatements(v, n.Statements)
+	case *PrefixExpression:
+		Walk(v, n.On)
+	case *InfixExpression:
+		Walk(v, n.Left)
+		Walk(v, n.Right)
+	case *IfExpression:
+		Walk(v, n.Condition)
+		Walk(v, n.Consequence)
+		Walk(v, n.Alternative)
+	case *TryExpression:
+		Walk(v, n.Block)
+		Walk(v, n.Param)
+		Walk(v, n.Catch)
+		Walk(v, n.Finally)
+	case *Identifier:
+		Walk(v, n.Type)
+	case *FunctionLiteral:
+		for _, param := range n.Parameters {
+			Walk(v, param)
+		}
+		Walk(v, n.ReturnType)
+		Walk(v, n.Body)
+	case *MacroLiteral:
+		for _, param := range n.Parameters {
+			Walk(v, param)
+		}
+		Walk(v, n.Body)
+	case *CallExpression:
+		Walk(v, n.Function)
+		walkExpressions(v, n.Arguments)
+	case *InterpolatedString:
+		walkExpressions(v, n.Parts)
+	case *ArrayLiteral:
+		walkExpressions(v, n.Elements)
+	case *IndexExpression:
+		Walk(v, n.Left)
+		Walk(v, n.Index)
+	case *SliceExpression:
+		Walk(v, n.Left)
+		Walk(v, n.Start)
+		Walk(v, n.End)
+	case *RangeExpression:
+		Walk(v, n.Start)
+		Walk(v, n.End)
+	case *HashLiteral:
+		for _, key := range n.Keys() {
+			Walk(v, key)
+			Walk(v, n.Pairs[key])
+		}
+	case *MatchExpression:
+		Walk(v, n.Subject)
+		for _, arm := range n.Arms {
+			Walk(v, arm.Pattern)
+			Walk(v, arm.Guard)
+			Walk(v, arm.Body)
+		}
+	case *BindingPattern:
+		Walk(v, n.Name)
+		Walk(v, n.Default)
+	case *LiteralPattern:
+		Walk(v, n.Value)
+	case *ArrayPattern:
+		for _, el := range n.Elements {
+			Walk(v, el)
+		}
+		Walk(v, n.Rest)
+	case *HashPattern:
+		for i := range n.Keys {
+			Walk(v, n.Keys[i])
+			Walk(v, n.Values[i])
+		}
+	}
+
+	v.Visit(nil)
+}
+
+func walkStatements(v Visitor, stmts []Statement) {
+	for _, stmt := range stmts {
+		Walk(v, stmt)
+	}
+}
+
+func walkExpressions(v Visitor, exps []Expression) {
+	for _, exp := range exps {
+		Walk(v, exp)
+	}
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses the tree rooted at node in depth-first order, calling
+// f(node) for each node and skipping the children of nodes for which f
+// returns false. After a node's children are visited, f is called with
+// nil.
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
+
+// isNil reports whether node is nil, including a nil pointer stored in
+// the interface, as a missing optional child is.
+func isNil(node Node) bool {
+	if node == nil {
+		return true
+	}
+	v := reflect.ValueOf(node)
+	return v.Kind() == reflect.Ptr && v.IsNil()
+}
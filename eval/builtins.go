@@ -3,7 +3,8 @@ package eval
 import (
     "monkey/object"
     "fmt"
-//    "unicode/utf8"
+    "sort"
+    "unicode/utf8"
 )
 
 var builtins = map[string]*object.Builtin{
@@ -17,7 +18,9 @@ var builtins = map[string]*object.Builtin{
 			case *object.Array:
 				return &object.Integer{Value: int64(len(arg.Elements))}
 			case *object.String:
-				return &object.Integer{Value: int64(len(arg.Value))}
+				return &object.Integer{Value: int64(utf8.RuneCountInString(arg.Value))}
+			case *object.Hash:
+				return &object.Integer{Value: int64(arg.Len())}
 			default:
 				return newError("argument to `len` not supported, got=%s",
 					args[0].Type())
@@ -112,4 +115,140 @@ var builtins = map[string]*object.Builtin{
             return NULL
         },
     },
+	// error builds an error value without throwing it, so scripts can
+	// choose their own kind: throw error("bad input", "ValueError").
+	"error": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("wrong number of arguments, want=1 or 2 got=%d", len(args))
+			}
+			msg, ok := args[0].(*object.String)
+			if !ok {
+				return newError("argument to `error` must be STRING, got %s",
+					args[0].Type())
+			}
+			kind := "Error"
+			if len(args) == 2 {
+				k, ok := args[1].(*object.String)
+				if !ok {
+					return newError("second argument to `error` must be STRING, got %s",
+						args[1].Type())
+				}
+				kind = k.Value
+			}
+
+			return &object.ErrorValue{Error: &object.Error{Message: msg.Value, Kind: kind}}
+		},
+	},
+	"format": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) < 1 {
+				return newError("wrong number of arguments, want>=1 got=%d", len(args))
+			}
+			if args[0].Type() != object.STRING_OBJ {
+				return newError("argument to `format` must be STRING, got %s",
+					args[0].Type())
+			}
+
+			template := args[0].(*object.String).Value
+			formatted, err := formatObjects("format", template, args[1:])
+			if err != nil {
+				return err
+			}
+
+			return &object.String{Value: formatted}
+		},
+	},
+	"printf": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) < 1 {
+				return newError("wrong number of arguments, want>=1 got=%d", len(args))
+			}
+			if args[0].Type() != object.STRING_OBJ {
+				return newError("argument to `printf` must be STRING, got %s",
+					args[0].Type())
+			}
+
+			template := args[0].(*object.String).Value
+			formatted, err := formatObjects("printf", template, args[1:])
+			if err != nil {
+				return err
+			}
+			fmt.Print(formatted)
+
+			return NULL
+		},
+	},
+}
+
+// IsBuiltin reports whether name is a builtin function, which identifiers
+// resolve to when no binding of that name is in scope.
+func IsBuiltin(name string) bool {
+	_, ok := builtins[name]
+	return ok
+}
+
+// BuiltinNames returns the names of the builtin functions, sorted.
+func BuiltinNames() []string {
+	names := make([]string, 0, len(builtins))
+	for name := range builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// BuiltinSignature describes how builtin name is called, using the
+// annotation names for types; `?` marks optional parameters and `...`
+// repeated ones.
+func BuiltinSignature(name string) string {
+	return builtinSignatures[name]
+}
+
+var builtinSignatures = map[string]string{
+	"len":        "len(value: array | string | hash) -> int",
+	"first":      "first(arr: array)",
+	"last":       "last(arr: array)",
+	"rest":       "rest(arr: array) -> array | null",
+	"push":       "push(arr: array, value) -> array",
+	"puts":       "puts(values...) -> null",
+	"error":      "error(message: string, kind?: string) -> error",
+	"format":     "format(template: string, args...) -> string",
+	"printf":     "printf(template: string, args...) -> null",
+	"map":        "map(arr: array, f: fn) -> array",
+	"filter":     "filter(arr: array, keep: fn) -> array",
+	"reduce":     "reduce(arr: array, f: fn, initial?)",
+	"each":       "each(arr: array, f: fn) -> null",
+	"find":       "find(arr: array, match: fn)",
+	"any":        "any(arr: array, match: fn) -> bool",
+	"all":        "all(arr: array, match: fn) -> bool",
+	"sort":       "sort(arr: array, less?: fn) -> array",
+	"reverse":    "reverse(value: array | string)",
+	"zip":        "zip(arrays: array...) -> array",
+	"flatten":    "flatten(arr: array, depth?: int) -> array",
+	"uniq":       "uniq(arr: array) -> array",
+	"sum":        "sum(arr: array) -> int",
+	"min":        "min(arr: array)",
+	"max":        "max(arr: array)",
+	"keys":       "keys(h: hash) -> array",
+	"values":     "values(h: hash) -> array",
+	"entries":    "entries(h: hash) -> array",
+	"has":        "has(h: hash, key) -> bool",
+	"delete":     "delete(h: hash, key) -> hash",
+	"merge":      "merge(hashes: hash...) -> hash",
+	"split":      "split(s: string, sep: string) -> array",
+	"join":       "join(arr: array, sep: string) -> string",
+	"trim":       "trim(s: string) -> string",
+	"upper":      "upper(s: string) -> string",
+	"lower":      "lower(s: string) -> string",
+	"contains":   "contains(s: string, sub: string) -> bool",
+	"startsWith": "startsWith(s: string, prefix: string) -> bool",
+	"endsWith":   "endsWith(s: string, suffix: string) -> bool",
+	"replace":    "replace(s: string, old: string, new: string) -> string",
+	"indexOf":    "indexOf(s: string, sub: string) -> int",
+	"repeat":     "repeat(s: string, n: int) -> string",
+	"chars":      "chars(s: string) -> array",
+	"str":        "str(value) -> string",
+	"int":        "int(value: int | bool | string) -> int",
+	"parseInt":   "parseInt(s: string, base?: int) -> int | null",
 }
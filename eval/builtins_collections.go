@@ -0,0 +1,442 @@
+package eval
+
+import (
+	"monkey/object"
+	"sort"
+)
+
+// Collection builtins are registered from init rather than listed in the
+// builtins map literal: they call back into monkey functions through
+// applyFunction, and referencing it from the literal would create an
+// initialization cycle (builtins -> applyFunction -> Eval -> builtins).
+func init() {
+	for name, builtin := range collectionBuiltins {
+		builtins[name] = builtin
+	}
+}
+
+var collectionBuiltins = map[string]*object.Builtin{
+	"map": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			arr, fn, err := arrayAndFunctionArgs("map", args)
+			if err != nil {
+				return err
+			}
+
+			result := make([]object.Object, len(arr.Elements))
+			for i, el := range arr.Elements {
+				mapped := applyFunction(fn, []object.Object{el})
+				if isError(mapped) {
+					return mapped
+				}
+				result[i] = mapped
+			}
+			return &object.Array{Elements: result}
+		},
+	},
+	"filter": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			arr, fn, err := arrayAndFunctionArgs("filter", args)
+			if err != nil {
+				return err
+			}
+
+			result := []object.Object{}
+			for _, el := range arr.Elements {
+				keep := applyFunction(fn, []object.Object{el})
+				if isError(keep) {
+					return keep
+				}
+				if isTruthy(keep) {
+					result = append(result, el)
+				}
+			}
+			return &object.Array{Elements: result}
+		},
+	},
+	// reduce(arr, fn, initial) folds arr from the left with fn(acc, el).
+	// Without initial the first element seeds the accumulator and an empty
+	// array reduces to null.
+	"reduce": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 2 && len(args) != 3 {
+				return newError("wrong number of arguments, want=2 or 3 got=%d", len(args))
+			}
+			arr, fn, err := arrayAndFunctionArgs("reduce", args[:2])
+			if err != nil {
+				return err
+			}
+
+			elements := arr.Elements
+			var acc object.Object = NULL
+			if len(args) == 3 {
+				acc = args[2]
+			} else if len(elements) > 0 {
+				acc = elements[0]
+				elements = elements[1:]
+			}
+
+			for _, el := range elements {
+				acc = applyFunction(fn, []object.Object{acc, el})
+				if isError(acc) {
+					return acc
+				}
+			}
+			return acc
+		},
+	},
+	"each": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			arr, fn, err := arrayAndFunctionArgs("each", args)
+			if err != nil {
+				return err
+			}
+
+			for _, el := range arr.Elements {
+				if result := applyFunction(fn, []object.Object{el}); isError(result) {
+					return result
+				}
+			}
+			return NULL
+		},
+	},
+	"find": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			arr, fn, err := arrayAndFunctionArgs("find", args)
+			if err != nil {
+				return err
+			}
+
+			for _, el := range arr.Elements {
+				found := applyFunction(fn, []object.Object{el})
+				if isError(found) {
+					return found
+				}
+				if isTruthy(found) {
+					return el
+				}
+			}
+			return NULL
+		},
+	},
+	"any": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			arr, fn, err := arrayAndFunctionArgs("any", args)
+			if err != nil {
+				return err
+			}
+
+			for _, el := range arr.Elements {
+				result := applyFunction(fn, []object.Object{el})
+				if isError(result) {
+					return result
+				}
+				if isTruthy(result) {
+					return TRUE
+				}
+			}
+			return FALSE
+		},
+	},
+	"all": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			arr, fn, err := arrayAndFunctionArgs("all", args)
+			if err != nil {
+				return err
+			}
+
+			for _, el := range arr.Elements {
+				result := applyFunction(fn, []object.Object{el})
+				if isError(result) {
+					return result
+				}
+				if !isTruthy(result) {
+					return FALSE
+				}
+			}
+			return TRUE
+		},
+	},
+	// sort returns a sorted copy of an array of integers or of strings. A
+	// comparator fn(a, b) returning true when a belongs before b sorts
+	// anything else.
+	"sort": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("wrong number of arguments, want=1 or 2 got=%d", len(args))
+			}
+			arr, ok := args[0].(*object.Array)
+			if !ok {
+				return newError("argument to `sort` must be ARRAY, got %s",
+					args[0].Type())
+			}
+
+			elements := make([]object.Object, len(arr.Elements))
+			copy(elements, arr.Elements)
+
+			var failure object.Object
+			var less func(a, b object.Object) bool
+			if len(args) == 2 {
+				if !isCallable(args[1]) {
+					return newError("second argument to `sort` must be FUNCTION, got %s",
+						args[1].Type())
+				}
+				less = func(a, b object.Object) bool {
+					if failure != nil {
+						return false
+					}
+					result := applyFunction(args[1], []object.Object{a, b})
+					if isError(result) {
+						failure = result
+						return false
+					}
+					return isTruthy(result)
+				}
+			} else {
+				if err := checkOrderable("sort", elements); err != nil {
+					return err
+				}
+				less = lessObjects
+			}
+
+			sort.SliceStable(elements, func(i, j int) bool {
+				return less(elements[i], elements[j])
+			})
+			if failure != nil {
+				return failure
+			}
+			return &object.Array{Elements: elements}
+		},
+	},
+	"reverse": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments, want=1 got=%d", len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *object.Array:
+				length := len(arg.Elements)
+				elements := make([]object.Object, length)
+				for i, el := range arg.Elements {
+					elements[length-1-i] = el
+				}
+				return &object.Array{Elements: elements}
+			case *object.String:
+				runes := []rune(arg.Value)
+				for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+					runes[i], runes[j] = runes[j], runes[i]
+				}
+				return &object.String{Value: string(runes)}
+			default:
+				return newError("argument to `reverse` not supported, got=%s",
+					args[0].Type())
+			}
+		},
+	},
+	// zip pairs up elements of its array arguments, stopping at the
+	// shortest one.
+	"zip": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) < 2 {
+				return newError("wrong number of arguments, want>=2 got=%d", len(args))
+			}
+
+			shortest := -1
+			arrays := make([]*object.Array, len(args))
+			for i, arg := range args {
+				arr, ok := arg.(*object.Array)
+				if !ok {
+					return newError("argument to `zip` must be ARRAY, got %s", arg.Type())
+				}
+				arrays[i] = arr
+				if shortest < 0 || len(arr.Elements) < shortest {
+					shortest = len(arr.Elements)
+				}
+			}
+
+			result := make([]object.Object, shortest)
+			for i := 0; i < shortest; i++ {
+				tuple := make([]object.Object, len(arrays))
+				for j, arr := range arrays {
+					tuple[j] = arr.Elements[i]
+				}
+				result[i] = &object.Array{Elements: tuple}
+			}
+			return &object.Array{Elements: result}
+		},
+	},
+	// flatten removes every level of nesting, or only depth levels when a
+	// depth is given.
+	"flatten": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("wrong number of arguments, want=1 or 2 got=%d", len(args))
+			}
+			arr, ok := args[0].(*object.Array)
+			if !ok {
+				return newError("argument to `flatten` must be ARRAY, got %s",
+					args[0].Type())
+			}
+			depth := int64(-1)
+			if len(args) == 2 {
+				d, ok := args[1].(*object.Integer)
+				if !ok {
+					return newError("second argument to `flatten` must be INTEGER, got %s",
+						args[1].Type())
+				}
+				depth = d.Value
+			}
+
+			return &object.Array{Elements: flattenElements(arr.Elements, depth)}
+		},
+	},
+	"uniq": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments, want=1 got=%d", len(args))
+			}
+			arr, ok := args[0].(*object.Array)
+			if !ok {
+				return newError("argument to `uniq` must be ARRAY, got %s",
+					args[0].Type())
+			}
+
+			result := []object.Object{}
+			for _, el := range arr.Elements {
+				seen := false
+				for _, kept := range result {
+					if object.Equal(el, kept) {
+						seen = true
+						break
+					}
+				}
+				if !seen {
+					result = append(result, el)
+				}
+			}
+			return &object.Array{Elements: result}
+		},
+	},
+	"sum": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments, want=1 got=%d", len(args))
+			}
+			arr, ok := args[0].(*object.Array)
+			if !ok {
+				return newError("argument to `sum` must be ARRAY, got %s",
+					args[0].Type())
+			}
+
+			var total int64
+			for _, el := range arr.Elements {
+				integer, ok := el.(*object.Integer)
+				if !ok {
+					return newError("`sum` expects an array of INTEGER, found %s", el.Type())
+				}
+				total += integer.Value
+			}
+			return &object.Integer{Value: total}
+		},
+	},
+	"min": extremumBuiltin("min", func(a, b object.Object) bool { return lessObjects(a, b) }),
+	"max": extremumBuiltin("max", func(a, b object.Object) bool { return lessObjects(b, a) }),
+}
+
+func isCallable(obj object.Object) bool {
+	switch obj.(type) {
+	case *object.Function, *object.Builtin:
+		return true
+	default:
+		return false
+	}
+}
+
+func arrayAndFunctionArgs(name string, args []object.Object) (*object.Array, object.Object, *object.Error) {
+	if len(args) != 2 {
+		return nil, nil, newError("wrong number of arguments, want=2 got=%d", len(args))
+	}
+	arr, ok := args[0].(*object.Array)
+	if !ok {
+		return nil, nil, newError("argument to `%s` must be ARRAY, got %s",
+			name, args[0].Type())
+	}
+	if !isCallable(args[1]) {
+		return nil, nil, newError("second argument to `%s` must be FUNCTION, got %s",
+			name, args[1].Type())
+	}
+	return arr, args[1], nil
+}
+
+func flattenElements(elements []object.Object, depth int64) []object.Object {
+	result := []object.Object{}
+	for _, el := range elements {
+		if inner, ok := el.(*object.Array); ok && depth != 0 {
+			result = append(result, flattenElements(inner.Elements, depth-1)...)
+			continue
+		}
+		result = append(result, el)
+	}
+	return result
+}
+
+// checkOrderable reports an error unless elements are all integers or all
+// strings, the only values with a natural order.
+func checkOrderable(name string, elements []object.Object) *object.Error {
+	if len(elements) == 0 {
+		return nil
+	}
+	first := elements[0].Type()
+	if first != object.INTEGER_OBJ && first != object.STRING_OBJ {
+		return newError("`%s` cannot order %s values", name, first)
+	}
+	for _, el := range elements[1:] {
+		if el.Type() != first {
+			return newError("`%s` cannot order mixed %s and %s values", name, first, el.Type())
+		}
+	}
+	return nil
+}
+
+// lessObjects orders two integers or two strings; callers check the
+// element types with checkOrderable first.
+func lessObjects(a, b object.Object) bool {
+	switch a := a.(type) {
+	case *object.Integer:
+		return a.Value < b.(*object.Integer).Value
+	case *object.String:
+		return a.Value < b.(*object.String).Value
+	default:
+		return false
+	}
+}
+
+func extremumBuiltin(name string, better func(a, b object.Object) bool) *object.Builtin {
+	return &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments, want=1 got=%d", len(args))
+			}
+			arr, ok := args[0].(*object.Array)
+			if !ok {
+				return newError("argument to `%s` must be ARRAY, got %s",
+					name, args[0].Type())
+			}
+			if err := checkOrderable(name, arr.Elements); err != nil {
+				return err
+			}
+			if len(arr.Elements) == 0 {
+				return NULL
+			}
+
+			best := arr.Elements[0]
+			for _, el := range arr.Elements[1:] {
+				if better(el, best) {
+					best = el
+				}
+			}
+			return best
+		},
+	}
+}
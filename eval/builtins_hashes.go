@@ -0,0 +1,121 @@
+package eval
+
+import "monkey/object"
+
+func init() {
+	for name, builtin := range hashBuiltins {
+		builtins[name] = builtin
+	}
+}
+
+// hashBuiltins never modify their arguments: like `push`, `delete` and
+// `merge` return a new hash. Results follow the insertion order of the
+// hashes they are built from.
+var hashBuiltins = map[string]*object.Builtin{
+	"keys": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			hash, err := hashArg("keys", args, 1)
+			if err != nil {
+				return err
+			}
+
+			keys := []object.Object{}
+			for _, pair := range hash.Entries() {
+				keys = append(keys, pair.Key)
+			}
+			return &object.Array{Elements: keys}
+		},
+	},
+	"values": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			hash, err := hashArg("values", args, 1)
+			if err != nil {
+				return err
+			}
+
+			values := []object.Object{}
+			for _, pair := range hash.Entries() {
+				values = append(values, pair.Value)
+			}
+			return &object.Array{Elements: values}
+		},
+	},
+	"entries": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			hash, err := hashArg("entries", args, 1)
+			if err != nil {
+				return err
+			}
+
+			entries := []object.Object{}
+			for _, pair := range hash.Entries() {
+				entry := &object.Array{Elements: []object.Object{pair.Key, pair.Value}}
+				entries = append(entries, entry)
+			}
+			return &object.Array{Elements: entries}
+		},
+	},
+	"has": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			hash, err := hashArg("has", args, 2)
+			if err != nil {
+				return err
+			}
+			key, ok := object.AsHashable(args[1])
+			if !ok {
+				return newError("unusable as hash key: %s", args[1].Type())
+			}
+
+			_, found := hash.Get(key)
+			return nativeBoolToBooleanObject(found)
+		},
+	},
+	"delete": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			hash, err := hashArg("delete", args, 2)
+			if err != nil {
+				return err
+			}
+			key, ok := object.AsHashable(args[1])
+			if !ok {
+				return newError("unusable as hash key: %s", args[1].Type())
+			}
+
+			result := hash.Copy()
+			result.Delete(key)
+			return result
+		},
+	},
+	// merge combines any number of hashes; later keys win but keep the
+	// position they had in the earliest hash that defined them.
+	"merge": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) < 1 {
+				return newError("wrong number of arguments, want>=1 got=%d", len(args))
+			}
+
+			result := object.NewHash()
+			for _, arg := range args {
+				hash, ok := arg.(*object.Hash)
+				if !ok {
+					return newError("argument to `merge` must be HASH, got %s", arg.Type())
+				}
+				for _, pair := range hash.Entries() {
+					result.Set(pair.Key.(object.Hashable), pair.Value)
+				}
+			}
+			return result
+		},
+	},
+}
+
+func hashArg(name string, args []object.Object, want int) (*object.Hash, *object.Error) {
+	if len(args) != want {
+		return nil, newError("wrong number of arguments, want=%d got=%d", want, len(args))
+	}
+	hash, ok := args[0].(*object.Hash)
+	if !ok {
+		return nil, newError("argument to `%s` must be HASH, got %s", name, args[0].Type())
+	}
+	return hash, nil
+}
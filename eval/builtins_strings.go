@@ -0,0 +1,270 @@
+package eval
+
+import (
+	"monkey/object"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+func init() {
+	for name, builtin := range stringBuiltins {
+		builtins[name] = builtin
+	}
+}
+
+// maxRepeatLength is the most bytes `repeat` builds, which keeps a large
+// count from exhausting memory, as maxRangeLength does for ranges.
+const maxRepeatLength = 1 << 28
+
+// stringBuiltins operate on strings rune by rune, so indices reported by
+// `indexOf` line up with `s[i]`, `chars` and `len`.
+var stringBuiltins = map[string]*object.Builtin{
+	"split": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments, want=2 got=%d", len(args))
+			}
+			s, err := stringArg("split", args[0])
+			if err != nil {
+				return err
+			}
+			sep, err := stringArg("split", args[1])
+			if err != nil {
+				return err
+			}
+
+			return stringsToArray(strings.Split(s, sep))
+		},
+	},
+	"join": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments, want=2 got=%d", len(args))
+			}
+			arr, ok := args[0].(*object.Array)
+			if !ok {
+				return newError("argument to `join` must be ARRAY, got %s",
+					args[0].Type())
+			}
+			sep, err := stringArg("join", args[1])
+			if err != nil {
+				return err
+			}
+
+			parts := make([]string, len(arr.Elements))
+			for i, el := range arr.Elements {
+				parts[i] = el.Inspect()
+			}
+			return &object.String{Value: strings.Join(parts, sep)}
+		},
+	},
+	"trim":  stringTransform("trim", strings.TrimSpace),
+	"upper": stringTransform("upper", strings.ToUpper),
+	"lower": stringTransform("lower", strings.ToLower),
+	"contains": stringPredicate("contains", strings.Contains),
+	"startsWith": stringPredicate("startsWith", strings.HasPrefix),
+	"endsWith": stringPredicate("endsWith", strings.HasSuffix),
+	"replace": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 3 {
+				return newError("wrong number of arguments, want=3 got=%d", len(args))
+			}
+			strs := make([]string, 3)
+			for i, arg := range args {
+				s, err := stringArg("replace", arg)
+				if err != nil {
+					return err
+				}
+				strs[i] = s
+			}
+
+			return &object.String{Value: strings.ReplaceAll(strs[0], strs[1], strs[2])}
+		},
+	},
+	"indexOf": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments, want=2 got=%d", len(args))
+			}
+			s, err := stringArg("indexOf", args[0])
+			if err != nil {
+				return err
+			}
+			sub, err := stringArg("indexOf", args[1])
+			if err != nil {
+				return err
+			}
+
+			idx := strings.Index(s, sub)
+			if idx >= 0 {
+				idx = utf8.RuneCountInString(s[:idx])
+			}
+			return &object.Integer{Value: int64(idx)}
+		},
+	},
+	"repeat": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments, want=2 got=%d", len(args))
+			}
+			s, err := stringArg("repeat", args[0])
+			if err != nil {
+				return err
+			}
+			count, ok := args[1].(*object.Integer)
+			if !ok {
+				return newError("second argument to `repeat` must be INTEGER, got %s",
+					args[1].Type())
+			}
+			if count.Value < 0 {
+				return newError("negative count to `repeat`: %d", count.Value)
+			}
+			if len(s) > 0 && count.Value > maxRepeatLength/int64(len(s)) {
+				return newError("`repeat` result too large: %d copies of %d bytes", count.Value, len(s))
+			}
+
+			return &object.String{Value: strings.Repeat(s, int(count.Value))}
+		},
+	},
+	"chars": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments, want=1 got=%d", len(args))
+			}
+			s, err := stringArg("chars", args[0])
+			if err != nil {
+				return err
+			}
+
+			chars := []string{}
+			for _, r := range s {
+				chars = append(chars, string(r))
+			}
+			return stringsToArray(chars)
+		},
+	},
+	"str": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments, want=1 got=%d", len(args))
+			}
+			if s, ok := args[0].(*object.String); ok {
+				return s
+			}
+
+			return &object.String{Value: args[0].Inspect()}
+		},
+	},
+	// int converts integers, booleans and decimal strings, and fails loudly
+	// on anything else.
+	"int": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments, want=1 got=%d", len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *object.Integer:
+				return arg
+			case *object.Boolean:
+				if arg.Value {
+					return &object.Integer{Value: 1}
+				}
+				return &object.Integer{Value: 0}
+			case *object.String:
+				v, err := strconv.ParseInt(strings.TrimSpace(arg.Value), 10, 64)
+				if err != nil {
+					return newError("could not convert %q to INTEGER", arg.Value)
+				}
+				return &object.Integer{Value: v}
+			default:
+				return newError("argument to `int` not supported, got=%s",
+					args[0].Type())
+			}
+		},
+	},
+	// parseInt parses a string in an optional base (10 by default) and
+	// returns null instead of an error when the string is not a number.
+	"parseInt": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("wrong number of arguments, want=1 or 2 got=%d", len(args))
+			}
+			s, err := stringArg("parseInt", args[0])
+			if err != nil {
+				return err
+			}
+			base := int64(10)
+			if len(args) == 2 {
+				b, ok := args[1].(*object.Integer)
+				if !ok {
+					return newError("second argument to `parseInt` must be INTEGER, got %s",
+						args[1].Type())
+				}
+				if b.Value < 2 || b.Value > 36 {
+					return newError("invalid base to `parseInt`: %d", b.Value)
+				}
+				base = b.Value
+			}
+
+			v, perr := strconv.ParseInt(strings.TrimSpace(s), int(base), 64)
+			if perr != nil {
+				return NULL
+			}
+			return &object.Integer{Value: v}
+		},
+	},
+}
+
+func stringArg(name string, arg object.Object) (string, *object.Error) {
+	s, ok := arg.(*object.String)
+	if !ok {
+		return "", newError("argument to `%s` must be STRING, got %s", name, arg.Type())
+	}
+	return s.Value, nil
+}
+
+func stringsToArray(strs []string) *object.Array {
+	elements := make([]object.Object, len(strs))
+	for i, s := range strs {
+		elements[i] = &object.String{Value: s}
+	}
+	return &object.Array{Elements: elements}
+}
+
+func stringTransform(name string, fn func(string) string) *object.Builtin {
+	return &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments, want=1 got=%d", len(args))
+			}
+			s, err := stringArg(name, args[0])
+			if err != nil {
+				return err
+			}
+
+			return &object.String{Value: fn(s)}
+		},
+	}
+}
+
+func stringPredicate(name string, fn func(string, string) bool) *object.Builtin {
+	return &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments, want=2 got=%d", len(args))
+			}
+			s, err := stringArg(name, args[0])
+			if err != nil {
+				return err
+			}
+			sub, err := stringArg(name, args[1])
+			if err != nil {
+				return err
+			}
+
+			return nativeBoolToBooleanObject(fn(s, sub))
+		},
+	}
+}
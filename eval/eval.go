@@ -1,9 +1,12 @@
 package eval
 
 import (
+	"bytes"
 	"fmt"
+	"math/big"
 	"monkey/ast"
 	"monkey/object"
+	"unicode/utf8"
 )
 
 var (
@@ -24,6 +27,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	// 	defer untrace(trace("Eval"))
 	switch node := node.(type) {
 	case *ast.Program:
+		Resolve(node, env)
 		return evalProgram(node.Statements, env)
 	case *ast.ExpressionStatement:
 		return Eval(node.Expression, env)
@@ -44,6 +48,12 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(left) {
 			return left
 		}
+		if node.Operator == "??" {
+			if left.Type() != object.NULL_OBJ {
+				return left
+			}
+			return Eval(node.Right, env)
+		}
 		right := Eval(node.Right, env)
 		if isError(right) {
 			return right
@@ -59,28 +69,63 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
+	case *ast.ThrowStatement:
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		return throwValue(val)
+	case *ast.TryExpression:
+		return evalTryExpression(node, env)
+	case *ast.MatchExpression:
+		return evalMatchExpression(node, env)
+	case *ast.DeferStatement:
+		return evalDeferStatement(node, env)
+	case *ast.ImportStatement:
+		return evalImportStatement(node, env)
+	case *ast.ExportStatement:
+		return Eval(node.Statement, env)
 	case *ast.LetStatement:
 		val := Eval(node.Value, env)
 		if isError(val) {
 			return val
 		}
-		env.Set(node.Bind.Value, val)
+		for _, name := range node.Names() {
+			if isConstant(env, name) {
+				return newError("cannot reassign constant %s", name.Value)
+			}
+		}
+		if node.Pattern != nil {
+			return bindPattern(node.Pattern, val, env, node.IsConst())
+		}
+		if node.Bind.Type != nil {
+			if err := checkAnnotation(node.Bind.Type, val, "let "+node.Bind.Value); err != nil {
+				return err
+			}
+		}
+		if fn, ok := val.(*object.Function); ok && fn.Name == "" {
+			fn.Name = node.Bind.Value
+		}
+		declare(env, node.Bind, val, node.IsConst())
+	case *ast.MacroLiteral:
+		return newError("macros must be defined with a top-level let")
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Parameters: params, Body: body, Env: env}
+		return &object.Function{Parameters: params, ReturnType: node.ReturnType, Body: body, Env: env}
 	case *ast.CallExpression:
-		function := Eval(node.Function, env)
-		if isError(function) {
-			return function
-		}
-		args := evalExpressions(node.Arguments, env)
-		if len(args) == 1 && isError(args[0]) {
-			return args[0]
+		if isQuoteCall(node) {
+			if len(node.Arguments) != 1 {
+				return newError("wrong number of arguments, want=1 got=%d", len(node.Arguments))
+			}
+			return quote(node.Arguments[0], env)
 		}
-		return applyFunction(function, args)
+		val, _ := evalChainLink(node, env)
+		return val
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
+	case *ast.InterpolatedString:
+		return evalInterpolatedString(node, env)
 	case *ast.ArrayLiteral:
 		elements := evalExpressions(node.Elements, env)
 		if len(elements) == 1 && isError(elements[0]) {
@@ -88,15 +133,13 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		}
 		return &object.Array{Elements: elements}
 	case *ast.IndexExpression:
-		left := Eval(node.Left, env)
-		if isError(left) {
-			return left
-		}
-		index := Eval(node.Index, env)
-		if isError(index) {
-			return index
-		}
-		return evalIndexExpression(left, index)
+		val, _ := evalChainLink(node, env)
+		return val
+	case *ast.SliceExpression:
+		val, _ := evalChainLink(node, env)
+		return val
+	case *ast.RangeExpression:
+		return evalRangeExpression(node, env)
 	case *ast.HashLiteral:
 		return evalHashLiteral(node, env)
 	}
@@ -159,9 +202,32 @@ func evalExpressions(
 func applyFunction(fn object.Object, args []object.Object) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
+		if len(args) < len(fn.Parameters) {
+			return newError("wrong number of arguments, want=%d got=%d",
+				len(fn.Parameters), len(args))
+		}
+		for i, param := range fn.Parameters {
+			if param.Type == nil {
+				continue
+			}
+			use := "argument " + param.Value + " to " + functionName(fn)
+			if err := checkAnnotation(param.Type, args[i], use); err != nil {
+				return err
+			}
+		}
 		extendedEnv := extendFunctionEnv(fn, args)
 		evaluated := Eval(fn.Body, extendedEnv)
-		return unwrapReturnValue(evaluated)
+		evaluated = runDeferred(extendedEnv.Frame(), evaluated)
+		if err, ok := evaluated.(*object.Error); ok {
+			return withFrame(err, "at "+functionName(fn))
+		}
+		evaluated = unwrapReturnValue(evaluated)
+		if fn.ReturnType != nil {
+			if err := checkAnnotation(fn.ReturnType, evaluated, "return from "+functionName(fn)); err != nil {
+				return err
+			}
+		}
+		return evaluated
 
 	case *object.Builtin:
 		return fn.Fn(args...)
@@ -170,11 +236,60 @@ func applyFunction(fn object.Object, args []object.Object) object.Object {
 	}
 }
 
+func evalDeferStatement(node *ast.DeferStatement, env *object.Environment) object.Object {
+	frame := env.Frame()
+	if frame == nil {
+		return newError("defer outside of a function")
+	}
+
+	call, ok := node.Call.(*ast.CallExpression)
+	if !ok {
+		frame.Deferred = append(frame.Deferred, func() object.Object {
+			return Eval(node.Call, env)
+		})
+		return nil
+	}
+
+	function := Eval(call.Function, env)
+	if isError(function) {
+		return function
+	}
+	args := evalExpressions(call.Arguments, env)
+	if len(args) == 1 && isError(args[0]) {
+		return args[0]
+	}
+	frame.Deferred = append(frame.Deferred, func() object.Object {
+		return applyFunction(function, args)
+	})
+	return nil
+}
+
+// runDeferred runs the frame's deferred calls, last registered first, once
+// the function body has produced result. A failing deferred call replaces
+// a successful result but never masks an earlier error.
+func runDeferred(frame *object.Frame, result object.Object) object.Object {
+	for i := len(frame.Deferred) - 1; i >= 0; i-- {
+		deferred := frame.Deferred[i]()
+		if isError(deferred) && !isError(result) {
+			result = deferred
+		}
+	}
+	frame.Deferred = nil
+	return result
+}
+
+func functionName(fn *object.Function) string {
+	if fn.Name == "" {
+		return "<anonymous>"
+	}
+	return fn.Name
+}
+
 func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
-	env := object.NewEnclosedEnvironment(fn.Env)
+	env := object.NewFunctionEnvironment(fn.Env)
 
 	for paramIdx, param := range fn.Parameters {
-		env.Set(param.Value, args[paramIdx])
+		declare(env, param, args[paramIdx], false)
 	}
 
 	return env
@@ -189,8 +304,21 @@ func unwrapReturnValue(obj object.Object) object.Object {
 }
 
 func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
-	if val, ok := env.Get(node.Value); ok {
-		return val
+	switch {
+	case node.Resolved && node.Depth >= 0:
+		scope := env.Enclosing(node.Depth)
+		if val, ok := scope.GetAt(node.Slot); ok {
+			return val
+		}
+		// Not bound yet, as when a function runs before a later let it
+		// refers to: a lookup by name would carry on outwards.
+		if val, ok := scope.Enclosing(1).Get(node.Value); ok {
+			return val
+		}
+	case !node.Resolved:
+		if val, ok := env.Get(node.Value); ok {
+			return val
+		}
 	}
 	if builtin, ok := builtins[node.Value]; ok {
 		return builtin
@@ -213,16 +341,7 @@ func evalPrefixExpression(operator string, on object.Object) object.Object {
 
 func evalBangOperatorExpression(on object.Object) object.Object {
 	// 	defer untrace(trace("evalBangOperator"))
-	switch on {
-	case TRUE:
-		return FALSE
-	case FALSE:
-		return TRUE
-	case NULL:
-		return TRUE
-	default:
-		return FALSE
-	}
+	return nativeBoolToBooleanObject(!isTruthy(on))
 }
 
 func evalMinusOperatorExpression(on object.Object) object.Object {
@@ -246,9 +365,9 @@ func evalInfixExpression(
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
 		return evalStringInfixExpression(operator, left, right)
 	case operator == "==":
-		return nativeBoolToBooleanObject(left == right)
+		return nativeBoolToBooleanObject(object.Equal(left, right))
 	case operator == "!=":
-		return nativeBoolToBooleanObject(left != right)
+		return nativeBoolToBooleanObject(!object.Equal(left, right))
 	case left.Type() != right.Type():
 		return newError("type mismatch: %s %s %s",
 			left.Type(), operator, right.Type())
@@ -274,6 +393,9 @@ func evalIntegerInfixExpression(
 	case "*":
 		return &object.Integer{Value: leftVal * rightVal}
 	case "/":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
 		return &object.Integer{Value: leftVal / rightVal}
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
@@ -292,15 +414,41 @@ func evalIntegerInfixExpression(
 func evalStringInfixExpression(operator string,
 	left, right object.Object,
 ) object.Object {
-	if operator != "+" {
+	leftVal := left.(*object.String).Value
+	rightVal := right.(*object.String).Value
+
+	switch operator {
+	case "+":
+		return &object.String{Value: leftVal + rightVal}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
 		return newError("unknown operator: %s %s %s",
 			left.Type(), operator, right.Type())
 	}
+}
 
-	leftVal := left.(*object.String).Value
-	rightVal := right.(*object.String).Value
+func evalInterpolatedString(
+	node *ast.InterpolatedString,
+	env *object.Environment,
+) object.Object {
+	var out bytes.Buffer
 
-	return &object.String{Value: leftVal + rightVal}
+	for _, part := range node.Parts {
+		evaluated := Eval(part, env)
+		if isError(evaluated) {
+			return evaluated
+		}
+		out.WriteString(evaluated.Inspect())
+	}
+
+	return &object.String{Value: out.String()}
 }
 
 func evalIfExpression(node *ast.IfExpression, env *object.Environment) object.Object {
@@ -310,22 +458,96 @@ func evalIfExpression(node *ast.IfExpression, env *object.Environment) object.Ob
 		return condition
 	}
 	if isTruthy(condition) {
-		return Eval(node.Consequence, env)
+		return Eval(node.Consequence, object.NewEnclosedEnvironment(env))
 	} else if node.Alternative != nil {
-		return Eval(node.Alternative, env)
+		return Eval(node.Alternative, object.NewEnclosedEnvironment(env))
 	} else {
 		return NULL
 	}
 }
 
+// declare binds a name introduced by let, const, a parameter or a
+// pattern in env, in the slot the resolver gave it if any.
+func declare(env *object.Environment, name *ast.Identifier, val object.Object, constant bool) {
+	switch {
+	case name.Resolved && constant:
+		env.SetConstAt(name.Slot, name.Value, val)
+	case name.Resolved:
+		env.SetAt(name.Slot, name.Value, val)
+	case constant:
+		env.SetConst(name.Value, val)
+	default:
+		env.Set(name.Value, val)
+	}
+}
+
+// isConstant reports whether declaring name in env would rebind one of
+// its constants.
+func isConstant(env *object.Environment, name *ast.Identifier) bool {
+	if name.Resolved {
+		return env.IsConstAt(name.Slot)
+	}
+	return env.IsConst(name.Value)
+}
+
+// throwValue turns the operand of `throw` into an unwinding Error. A
+// caught ErrorValue is rethrown as-is so its kind and stack survive.
+func throwValue(val object.Object) *object.Error {
+	switch val := val.(type) {
+	case *object.ErrorValue:
+		// The caught error may still be bound to a name; copy it so the
+		// frames added while this throw unwinds do not change that one.
+		return withFrame(val.Error, "")
+	case *object.String:
+		return &object.Error{Message: val.Value, Kind: "Error", Value: val}
+	default:
+		return &object.Error{Message: val.Inspect(), Kind: "Error", Value: val}
+	}
+}
+
+// evalTryExpression runs the try block and, if it fails, the catch block
+// with the error bound as an ErrorValue. The finally block always runs
+// last; an error or return from it replaces the result of the others.
+func evalTryExpression(node *ast.TryExpression, env *object.Environment) object.Object {
+	result := Eval(node.Block, object.NewEnclosedEnvironment(env))
+
+	if err, ok := result.(*object.Error); ok && node.Catch != nil {
+		catchEnv := object.NewEnclosedEnvironment(env)
+		if node.Param != nil {
+			declare(catchEnv, node.Param, &object.ErrorValue{Error: err}, false)
+		}
+		result = Eval(node.Catch, catchEnv)
+	}
+
+	if node.Finally != nil {
+		finally := Eval(node.Finally, object.NewEnclosedEnvironment(env))
+		if isError(finally) {
+			return finally
+		}
+		if finally != nil && finally.Type() == object.RETURN_VALUE_OBJ {
+			return finally
+		}
+	}
+
+	if result == nil {
+		return NULL
+	}
+	return result
+}
+
+// isTruthy is the single truthiness rule used by conditions, `!` and the
+// predicate builtins: null, false, 0 and "" are falsy, every other value
+// (including empty arrays and hashes) is truthy.
 func isTruthy(obj object.Object) bool {
-	switch obj {
-	case TRUE:
-		return true
-	case FALSE:
+	switch obj := obj.(type) {
+	case *object.Null:
 		return false
-	case NULL:
-		return true
+	case *object.Boolean:
+		return obj.Value
+	case *object.Integer:
+		return obj.Value != 0
+	case *object.String:
+		return obj.Value != ""
 	default:
 		return true
 	}
@@ -335,33 +557,213 @@ func evalIndexExpression(left, index object.Object) object.Object {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return evalArrayIndexExpression(left, index)
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		return evalStringIndexExpression(left, index)
     case left.Type() == object.HASH_OBJ:
         return evalHashIndexExpression(left, index)
+	case left.Type() == object.ERROR_VALUE_OBJ && index.Type() == object.STRING_OBJ:
+		return evalErrorIndexExpression(left, index)
+	case left.Type() == object.MODULE_OBJ && index.Type() == object.STRING_OBJ:
+		return evalModuleIndexExpression(left, index)
 	default:
 		return newError("index operator not supported: %s", left.Type())
 	}
 }
 
+// Negative indices count back from the end, as in Python: a[-1] is the
+// last element. Anything still out of range yields null.
 func evalArrayIndexExpression(arr, index object.Object) object.Object {
 	arrObj := arr.(*object.Array)
-	idx := index.(*object.Integer).Value
-	max := int64(len(arrObj.Elements) - 1)
-
-	if idx < 0 || idx > max {
+	idx, ok := normalizeIndex(index.(*object.Integer).Value, int64(len(arrObj.Elements)))
+	if !ok {
 		return NULL
 	}
 
 	return arrObj.Elements[idx]
 }
 
+func evalStringIndexExpression(str, index object.Object) object.Object {
+	runes := []rune(str.(*object.String).Value)
+	idx, ok := normalizeIndex(index.(*object.Integer).Value, int64(len(runes)))
+	if !ok {
+		return NULL
+	}
+
+	return &object.String{Value: string(runes[idx])}
+}
+
+func normalizeIndex(idx, length int64) (int64, bool) {
+	if idx < 0 {
+		idx += length
+	}
+	return idx, idx >= 0 && idx < length
+}
+
+// evalChainLink evaluates an index, slice or call, and reports whether an
+// optional index in the chain it ends found null. That ends the whole
+// chain: `a?.b["c"]`, `a?.b[1:]` and `a?.b()` are null when a is null,
+// rather than indexing, slicing or calling null.
+func evalChainLink(node ast.Expression, env *object.Environment) (object.Object, bool) {
+	switch node := node.(type) {
+	case *ast.IndexExpression:
+		left, skipped := evalChainLink(node.Left, env)
+		if skipped || isError(left) {
+			return left, skipped
+		}
+		if node.Optional && left.Type() == object.NULL_OBJ {
+			return NULL, true
+		}
+		index := Eval(node.Index, env)
+		if isError(index) {
+			return index, false
+		}
+		return evalIndexExpression(left, index), false
+	case *ast.SliceExpression:
+		left, skipped := evalChainLink(node.Left, env)
+		if skipped || isError(left) {
+			return left, skipped
+		}
+		return evalSliceExpression(node, left, env), false
+	case *ast.CallExpression:
+		if isQuoteCall(node) {
+			break
+		}
+		function, skipped := evalChainLink(node.Function, env)
+		if skipped || isError(function) {
+			return function, skipped
+		}
+		args := evalExpressions(node.Arguments, env)
+		if len(args) == 1 && isError(args[0]) {
+			return args[0], false
+		}
+		return applyFunction(function, args), false
+	}
+	return Eval(node, env), false
+}
+
+func evalSliceExpression(node *ast.SliceExpression, left object.Object, env *object.Environment) object.Object {
+	var length int64
+	switch left := left.(type) {
+	case *object.String:
+		length = int64(utf8.RuneCountInString(left.Value))
+	case *object.Array:
+		length = int64(len(left.Elements))
+	default:
+		return newError("slice operator not supported: %s", left.Type())
+	}
+
+	start, err := evalSliceBound(node.Start, env, 0, length)
+	if err != nil {
+		return err
+	}
+	end, err := evalSliceBound(node.End, env, length, length)
+	if err != nil {
+		return err
+	}
+	if end < start {
+		end = start
+	}
+
+	switch left := left.(type) {
+	case *object.String:
+		runes := []rune(left.Value)
+		return &object.String{Value: string(runes[start:end])}
+	default:
+		elements := make([]object.Object, end-start)
+		copy(elements, left.(*object.Array).Elements[start:end])
+		return &object.Array{Elements: elements}
+	}
+}
+
+// evalSliceBound evaluates one side of a slice, using def when it is
+// omitted. Negative bounds count back from the end and the result is
+// clamped to [0, length].
+func evalSliceBound(
+	node ast.Expression,
+	env *object.Environment,
+	def, length int64,
+) (int64, object.Object) {
+	if node == nil {
+		return def, nil
+	}
+
+	bound := Eval(node, env)
+	if isError(bound) {
+		return 0, bound
+	}
+	integer, ok := bound.(*object.Integer)
+	if !ok {
+		return 0, newError("slice bound must be INTEGER, got %s", bound.Type())
+	}
+
+	idx := integer.Value
+	if idx < 0 {
+		idx += length
+	}
+	if idx < 0 {
+		idx = 0
+	}
+	if idx > length {
+		idx = length
+	}
+	return idx, nil
+}
+
+// evalRangeExpression materializes start..end (inclusive) or start..<end
+// (exclusive) into an array. A range whose end precedes its start is empty.
+func evalRangeExpression(node *ast.RangeExpression, env *object.Environment) object.Object {
+	start := Eval(node.Start, env)
+	if isError(start) {
+		return start
+	}
+	end := Eval(node.End, env)
+	if isError(end) {
+		return end
+	}
+	if start.Type() != object.INTEGER_OBJ || end.Type() != object.INTEGER_OBJ {
+		return newError("range bounds must be INTEGER, got %s%s%s",
+			start.Type(), node.Operator, end.Type())
+	}
+
+	from := start.(*object.Integer).Value
+	to := end.(*object.Integer).Value
+	// The length is worked out in uint64, where to - from cannot overflow,
+	// and checked before counting an inclusive end.
+	var length uint64
+	if to >= from {
+		length = uint64(to) - uint64(from)
+	}
+	inclusive := node.Operator == ".." && to >= from
+	if length > maxRangeLength || inclusive && length == maxRangeLength {
+		count := new(big.Int).SetUint64(length)
+		if inclusive {
+			count.Add(count, big.NewInt(1))
+		}
+		return newError("range too large: %s elements", count)
+	}
+	if inclusive {
+		length++
+	}
+
+	elements := make([]object.Object, length)
+	for i := range elements {
+		elements[i] = &object.Integer{Value: from + int64(i)}
+	}
+	return &object.Array{Elements: elements}
+}
+
+// maxRangeLength guards against ranges that would exhaust memory when
+// materialized.
+const maxRangeLength = 1 << 24
+
 func evalHashIndexExpression(hash, index object.Object) object.Object {
     hashObject := hash.(*object.Hash)
 
-    key, ok := index.(object.Hashable)
+    key, ok := object.AsHashable(index)
     if !ok {
         return newError("unusable as hash key: %s", index.Type())
     }
-    pair, ok := hashObject.Pairs[key.HashKey()]
+    pair, ok := hashObject.Get(key)
     if !ok {
         return NULL
     }
@@ -369,19 +771,42 @@ func evalHashIndexExpression(hash, index object.Object) object.Object {
     return pair.Value
 }
 
+// evalErrorIndexExpression exposes the fields of a caught error:
+// "message", "kind", "stack" and "value".
+func evalErrorIndexExpression(errValue, index object.Object) object.Object {
+	err := errValue.(*object.ErrorValue).Error
+
+	switch index.(*object.String).Value {
+	case "message":
+		return &object.String{Value: err.Message}
+	case "kind":
+		return &object.String{Value: err.Kind}
+	case "stack":
+		return stringsToArray(err.Stack)
+	case "value":
+		if err.Value == nil {
+			return NULL
+		}
+		return err.Value
+	default:
+		return NULL
+	}
+}
+
 func evalHashLiteral(
 	node *ast.HashLiteral,
 	env *object.Environment,
 ) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair)
+	hash := object.NewHash()
 
-	for keyN, valueN := range node.Pairs {
+	for _, keyN := range node.Keys() {
+		valueN := node.Pairs[keyN]
 		key := Eval(keyN, env)
 		if isError(key) {
 			return key
 		}
 
-		hashKey, ok := key.(object.Hashable)
+		hashKey, ok := object.AsHashable(key)
 		if !ok {
 			return newError("unusable as hash key: %s", key.Type())
 		}
@@ -391,15 +816,26 @@ func evalHashLiteral(
 			return value
 		}
 
-		hashed := hashKey.HashKey()
-		pairs[hashed] = object.HashPair{Key: key, Value: value}
+		hash.Set(hashKey, value)
 	}
 
-	return &object.Hash{Pairs: pairs}
+	return hash
 }
 
 func newError(f string, a ...interface{}) *object.Error {
-	return &object.Error{Message: fmt.Sprintf(f, a...)}
+	return &object.Error{Message: fmt.Sprintf(f, a...), Kind: "RuntimeError"}
+}
+
+// withFrame returns a copy of err with frame, if not empty, added to its
+// stack. Errors are copied rather than changed because a caught error can
+// be thrown again, and each throw unwinds through its own frames.
+func withFrame(err *object.Error, frame string) *object.Error {
+	copied := *err
+	copied.Stack = append([]string(nil), err.Stack...)
+	if frame != "" {
+		copied.Stack = append(copied.Stack, frame)
+	}
+	return &copied
 }
 
 func isError(obj object.Object) bool {
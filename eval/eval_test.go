@@ -1,9 +1,14 @@
 package eval
 
 import (
+	"fmt"
+	"monkey/ast"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -65,6 +70,12 @@ func TestBangOperator(t *testing.T) {
 		{"!!true", true},
 		{"!!false", false},
 		{"!!22", true},
+		{"!0", true},
+		{`!""`, true},
+		{`!"a"`, false},
+		{"![]", false},
+		{"!if (false) { 1 }", true},
+		{"!!if (false) { 1 }", false},
 	}
 
 	for _, tt := range tests {
@@ -76,7 +87,7 @@ func TestBangOperator(t *testing.T) {
 func TestEvalIntegerExpression(t *testing.T) {
 	tests := []struct {
 		input    string
-		expected int64
+		expected interface{}
 	}{
 		{"-5", -5},
 		{"111", 111},
@@ -85,11 +96,11 @@ func TestEvalIntegerExpression(t *testing.T) {
 		{"10 + 99 + 00 + 66 * 99", 6643},
 		{"99 * 22 / 55 - 33 + 99", 105},
 		{"(1+2) * 44 + 66", 198},
+		{"1 / 0", errorMessage("division by zero")},
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
-		testIntegerObject(t, evaluated, tt.expected)
+		testExpectedObject(t, tt.input, testEval(tt.input), tt.expected)
 	}
 }
 
@@ -105,6 +116,17 @@ func TestIfElseExpression(t *testing.T) {
 		{"if (1 > 2) { 10 }", nil},
 		{"if (1 > 2) { 10 } else { 20 }", 20},
 		{"if (1 < 2) { 10 } else { 20 }", 10},
+		{"if (0) { 10 }", nil},
+		{`if ("") { 10 }`, nil},
+		{`if ("0") { 10 }`, 10},
+		{"if ([]) { 10 }", 10},
+		{"if ({}) { 10 }", 10},
+		{"if (if (false) { 1 }) { 10 } else { 20 }", 20},
+		{"if (1 > 2) { 10 } else if (2 > 1) { 20 } else { 30 }", 20},
+		{"if (1 > 2) { 10 } else if (2 > 3) { 20 } else { 30 }", 30},
+		{"if (1 > 2) { 10 } else if (2 > 3) { 20 }", nil},
+		{"if (1 < 2) { 10 } else if (1 + true) { 20 }", 10},
+		{"let f = fn(n) { if (n == 0) { 0 } else if (n < 0) { -1 } else { 1 } }; f(-5)", -1},
 	}
 
 	for _, tt := range tests {
@@ -118,6 +140,40 @@ func TestIfElseExpression(t *testing.T) {
 	}
 }
 
+func TestNullCoalescingAndOptionalChaining(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`let h = {"a": 1}; h["b"] ?? 5`, 5},
+		{`let h = {"a": 1}; h["a"] ?? 5`, 1},
+		{"0 ?? 5", 0},
+		{"false ?? 5", false},
+		{`if (false) { 1 } ?? if (false) { 2 } ?? 3`, 3},
+		{"1 ?? undefinedName", 1},
+		{"if (false) { 1 } ?? undefinedName", errorMessage("identifier not found: undefinedName")},
+		{`let u = {"name": "Ann"}; u?.name`, "Ann"},
+		{`let u = {"name": "Ann"}; u?.["name"]`, "Ann"},
+		{`let u = {"name": "Ann"}; u?.age`, nil},
+		{`let u = {"name": "Ann"}; u?.age?.years`, nil},
+		{`let u = if (false) { 1 }; u?.name`, nil},
+		{`let u = if (false) { 1 }; u?.name ?? "anon"`, "anon"},
+		{`let xs = [1, 2]; xs?.[1]`, 2},
+		{`let h = {"a": {"b": 3}}; h?.a?.b`, 3},
+		{`let u = if (false) { 1 }; u["name"]`, errorMessage("index operator not supported: NULL")},
+		{`let u = if (false) { 1 }; u?.a["b"]`, nil},
+		{`let u = if (false) { 1 }; u?.a[0:1]`, nil},
+		{`let u = if (false) { 1 }; u?.f(nope)`, nil},
+		{`let u = if (false) { 1 }; u?.a["b"]?.c["d"] ?? "none"`, "none"},
+		{`let u = {"f": fn(x) { x * 2 }}; u?.f(4)`, 8},
+		{`let u = {"a": {"b": "xyz"}}; u?.a["b"][1:]`, "yz"},
+		{`let u = {}; u?.a["b"]`, errorMessage("index operator not supported: NULL")},
+	}
+	for _, tt := range tests {
+		testExpectedObject(t, tt.input, testEval(tt.input), tt.expected)
+	}
+}
+
 func TestReturnStatements(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -191,34 +247,998 @@ func TestErrorHandling(t *testing.T) {
         },
 	}
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
-		errObj, ok := evaluated.(*object.Error)
-		if !ok {
-			t.Errorf("no error object returned. got=%T(%+v)",
-				evaluated, evaluated)
-			continue
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)",
+				evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != tt.expected {
+			t.Errorf("wrong error message. expected=%q, got=%q",
+				tt.expected, errObj.Message)
+		}
+	}
+}
+
+func TestTryCatchFinally(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`try { 1 } catch (e) { 2 }`, 1},
+		{`try { 1 + true } catch (e) { 2 }`, 2},
+		{`try { 1 + true } catch (e) { e["message"] }`, "type mismatch: INTEGER + BOOLEAN"},
+		{`try { 1 + true } catch (e) { e["kind"] }`, "RuntimeError"},
+		{`try { throw "boom" } catch (e) { e["message"] }`, "boom"},
+		{`try { throw "boom" } catch (e) { e["kind"] }`, "Error"},
+		{`try { throw [1, 2] } catch (e) { e["value"] }`, []int{1, 2}},
+		{`try { throw error("bad", "ValueError") } catch (e) { e["kind"] }`, "ValueError"},
+		{`try { throw "x" } catch (e) { str(e) }`, "Error: x"},
+		{`try { throw "x" } catch { 5 }`, 5},
+		{`let f = fn() { try { throw "x" } finally { return "cleaned" } }; f()`, "cleaned"},
+		{`try { throw "x" } finally { 1 }`, errorMessage("x")},
+		{`try { throw "x" } catch (e) { throw "y" }`, errorMessage("y")},
+		{`try { try { throw "inner" } catch (e) { throw e } } catch (e) { e["message"] }`, "inner"},
+		{`try { throw "x" } catch (e) { 1 } finally { throw "from finally" }`, errorMessage("from finally")},
+		{`let f = fn() { try { return 1 } finally { 2 }; 3 }; f()`, 1},
+		{`let f = fn() { try { return 1 } finally { return 2 } }; f()`, 2},
+		{`let f = fn() { try { throw "x" } catch (e) { return 4 }; 5 }; f()`, 4},
+		{`let f = fn(n) { if (n == 0) { throw "retry" } n }; try { f(0) } catch (e) { f(1) }`, 1},
+		{`try { 1 } catch (e) { 2 }; e`, errorMessage("identifier not found: e")},
+		{`throw 5`, errorMessage("5")},
+	}
+	for _, tt := range tests {
+		testExpectedObject(t, tt.input, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestErrorStack(t *testing.T) {
+	input := `
+	let inner = fn() { throw "deep" };
+	let outer = fn() { inner() };
+	try { outer() } catch (e) { e["stack"] }`
+	testExpectedObject(t, input, testEval(input), []string{"at inner", "at outer"})
+
+	input = `try { fn() { 1 + "a" }() } catch (e) { e["stack"] }`
+	testExpectedObject(t, input, testEval(input), []string{"at <anonymous>"})
+
+	// Rethrowing a caught error leaves the one bound in the catch block as
+	// it was.
+	rethrow := `
+	let inner = fn() { throw "deep" };
+	let rethrow = fn(e) { throw e };
+	try { inner() } catch (e) { try { rethrow(e) } catch (again) { %s["stack"] } }`
+	input = fmt.Sprintf(rethrow, "e")
+	testExpectedObject(t, input, testEval(input), []string{"at inner"})
+	input = fmt.Sprintf(rethrow, "again")
+	testExpectedObject(t, input, testEval(input), []string{"at inner", "at rethrow"})
+}
+
+func TestDeferStatements(t *testing.T) {
+	var log []string
+	builtins["record"] = &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			for _, arg := range args {
+				log = append(log, arg.Inspect())
+			}
+			return NULL
+		},
+	}
+	defer delete(builtins, "record")
+
+	tests := []struct {
+		input       string
+		expected    interface{}
+		expectedLog []string
+	}{
+		{`fn() { defer record(1); defer record(2); record(0); 10 }()`, 10, []string{"0", "2", "1"}},
+		{`fn() { defer record("d"); return 5; record("x") }()`, 5, []string{"d"}},
+		{`fn() { defer record("d"); 1 + true }()`, errorMessage("type mismatch: INTEGER + BOOLEAN"), []string{"d"}},
+		{`fn() { let x = 1; defer record(x); let x = 2; x }()`, 2, []string{"1"}},
+		{`fn() { let x = 1; defer record(x + 10) ; let x = 2; x }()`, 2, []string{"11"}},
+		{`fn() { if (true) { defer record(1) }; record(0) }()`, nil, []string{"0", "1"}},
+		{`fn() { defer fn() { record("inner") }(); try { throw "x" } catch (e) { 3 } }()`, 3, []string{"inner"}},
+		{`let fail = fn() { throw "cleanup failed" }; fn() { defer fail(); 1 }()`, errorMessage("cleanup failed"), nil},
+		{`let fail = fn() { throw "cleanup failed" }; fn() { defer fail(); throw "first" }()`, errorMessage("first"), nil},
+		{`let f = fn(n) { defer record(n); if (n > 0) { f(n - 1) } }; f(2)`, nil, []string{"0", "1", "2"}},
+		{`defer record(1)`, errorMessage("defer outside of a function"), nil},
+		{`fn() { defer missing(); 1 }()`, errorMessage("identifier not found: missing"), nil},
+	}
+	for _, tt := range tests {
+		log = nil
+		testExpectedObject(t, tt.input, testEval(tt.input), tt.expected)
+		if len(log) != len(tt.expectedLog) {
+			t.Errorf("%s: wrong deferred calls. want=%v got=%v", tt.input, tt.expectedLog, log)
+			continue
+		}
+		for i := range log {
+			if log[i] != tt.expectedLog[i] {
+				t.Errorf("%s: wrong deferred calls. want=%v got=%v", tt.input, tt.expectedLog, log)
+				break
+			}
+		}
+	}
+}
+
+func TestMatchExpression(t *testing.T) {
+	route := `let route = fn(msg) {
+		match (msg) {
+			0 => "zero",
+			-1 => "minus one",
+			"ping" => "pong",
+			true => "yes",
+			[] => "empty",
+			[x] => "one: ${x}",
+			[first, ...rest] if (first > 10) => rest,
+			[a, b] => a + b,
+			{"type": "add", "a": a, "b": b} => a + b,
+			{"type": "neg", value} => -value,
+			{"type": t} => "unknown ${t}",
+			n if (str(n) == "500") => "big",
+			_ => "other",
+		}
+	};`
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"route(0)", "zero"},
+		{"route(-1)", "minus one"},
+		{`route("ping")`, "pong"},
+		{"route(true)", "yes"},
+		{"route([])", "empty"},
+		{"route([7])", "one: 7"},
+		{"route([11, 12, 13])", []int{12, 13}},
+		{"route([1, 2])", 3},
+		{"route([1, 2, 3])", "other"},
+		{`route({"type": "add", "a": 2, "b": 3, "extra": 0})`, 5},
+		{`route({"type": "neg", "value": 4})`, -4},
+		{`route({"type": "mul"})`, "unknown mul"},
+		{`route({"kind": "x"})`, "other"},
+		{"route(500)", "big"},
+		{"route(5)", "other"},
+		{"route(false)", "other"},
+	}
+	for _, tt := range tests {
+		input := route + tt.input
+		testExpectedObject(t, input, testEval(input), tt.expected)
+	}
+
+	more := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`match (3) { 1 => "one" }`, nil},
+		{`match (2) { n => { let m = n * 10; m + 1 } }`, 21},
+		{`match ({"a": 1}) { _ => { {"b": 2} } }["b"]`, 2},
+		{`match (1) { x => x }; x`, errorMessage("identifier not found: x")},
+		{`match (1) { x if (x + true) => x }`, errorMessage("type mismatch: INTEGER + BOOLEAN")},
+		{`let f = fn(x) { match (x) { 1 => { return "early" } }; "late" }; f(1)`, "early"},
+		{`match ([1, [2, 3]]) { [a, [b, c]] => a + b + c }`, 6},
+		{`match ([1, 1]) { [a, a] => a }`, 1},
+	}
+	for _, tt := range more {
+		testExpectedObject(t, tt.input, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestLetStatement(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let a = 5;a;", 5},
+		{"let a = 5 * 5; a;", 25},
+		{"let a = 6; let b = a; b;", 6},
+		{"let a=3; let b = 8; let c = a + b +8;c;", 19},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testIntegerObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`quote(5)`, `5`},
+		{`quote(5 + 8)`, `(5 + 8)`},
+		{`quote(foobar)`, `foobar`},
+		{`quote(foobar + barfoo)`, `(foobar + barfoo)`},
+	}
+
+	for _, tt := range tests {
+		testQuoteObject(t, tt.input, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestQuoteUnquote(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`quote(unquote(4))`, `4`},
+		{`quote(unquote(4 + 4))`, `8`},
+		{`quote(8 + unquote(4 + 4))`, `(8 + 8)`},
+		{`quote(unquote(4 + 4) + 8)`, `(8 + 8)`},
+		{`let foobar = 8; quote(foobar)`, `foobar`},
+		{`let foobar = 8; quote(unquote(foobar))`, `8`},
+		{`quote(unquote(true))`, `true`},
+		{`quote(unquote(true == false))`, `false`},
+		{`quote(unquote(quote(4 + 4)))`, `(4 + 4)`},
+		{`let quotedInfixExpression = quote(4 + 4);
+		  quote(unquote(4 + 4) + unquote(quotedInfixExpression))`, `(8 + (4 + 4))`},
+		{`quote(unquote("a" + "b"))`, `ab`},
+		{`quote(unquote([1, 2 * 2]))`, `[1, 4]`},
+		{`quote(unquote({"a": 1}))`, `{a:1}`},
+		{`quote(len(unquote(0 - 3)))`, `len(-3)`},
+	}
+
+	for _, tt := range tests {
+		testQuoteObject(t, tt.input, testEval(tt.input), tt.expected)
+	}
+
+	errors := []struct {
+		input    string
+		expected string
+	}{
+		{`quote(1, 2)`, "wrong number of arguments, want=1 got=2"},
+		{`quote(unquote(1, 2))`, "wrong number of arguments, want=1 got=2"},
+		{`quote(unquote(missing))`, "identifier not found: missing"},
+		{`quote(unquote(fn(x) { x }))`, "cannot unquote FUNCTION"},
+		{`macro(x) { x }`, "macros must be defined with a top-level let"},
+	}
+	for _, tt := range errors {
+		testExpectedObject(t, tt.input, testEval(tt.input), errorMessage(tt.expected))
+	}
+}
+
+func testQuoteObject(t *testing.T, input string, evaluated object.Object, expected string) {
+	t.Helper()
+	quote, ok := evaluated.(*object.Quote)
+	if !ok {
+		t.Fatalf("%s: expected *object.Quote. got=%T (%+v)", input, evaluated, evaluated)
+	}
+	if quote.Node == nil {
+		t.Fatalf("%s: quote.Node is nil", input)
+	}
+	if quote.Node.String() != expected {
+		t.Errorf("%s: not equal. got=%q, want=%q", input, quote.Node.String(), expected)
+	}
+}
+
+func TestDefineMacros(t *testing.T) {
+	input := `
+	let number = 1;
+	let function = fn(x, y) { x + y };
+	let mymacro = macro(x, y) { x + y; };
+	`
+
+	env := object.NewEnvironment()
+	program := testParseProgram(input)
+
+	DefineMacros(program, env)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("Wrong number of statements. got=%d",
+			len(program.Statements))
+	}
+
+	_, ok := env.Get("number")
+	if ok {
+		t.Fatalf("number should not be defined")
+	}
+	_, ok = env.Get("function")
+	if ok {
+		t.Fatalf("function should not be defined")
+	}
+
+	obj, ok := env.Get("mymacro")
+	if !ok {
+		t.Fatalf("macro not in environment.")
+	}
+
+	macro, ok := obj.(*object.Macro)
+	if !ok {
+		t.Fatalf("object is not Macro. got=%T (%+v)", obj, obj)
+	}
+
+	if len(macro.Parameters) != 2 {
+		t.Fatalf("Wrong number of macro parameters. got=%d",
+			len(macro.Parameters))
+	}
+
+	if macro.Parameters[0].String() != "x" {
+		t.Fatalf("parameter is not 'x'. got=%q", macro.Parameters[0])
+	}
+	if macro.Parameters[1].String() != "y" {
+		t.Fatalf("parameter is not 'y'. got=%q", macro.Parameters[1])
+	}
+
+	expectedBody := "(x + y)"
+
+	if macro.Body.String() != expectedBody {
+		t.Fatalf("body is not %q. got=%q", expectedBody, macro.Body.String())
+	}
+}
+
+func testParseProgram(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
+
+func TestExpandMacros(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			`
+			let infixExpression = macro() { quote(1 + 2); };
+
+			infixExpression();
+			`,
+			`(1 + 2)`,
+		},
+		{
+			`
+			let reverse = macro(a, b) { quote(unquote(b) - unquote(a)); };
+
+			reverse(2 + 2, 10 - 5);
+			`,
+			`(10 - 5) - (2 + 2)`,
+		},
+		{
+			`
+			let unless = macro(condition, consequence, alternative) {
+				quote(if (!(unquote(condition))) {
+					unquote(consequence);
+				} else {
+					unquote(alternative);
+				});
+			};
+
+			unless(10 > 5, puts("not greater"), puts("greater"));
+			`,
+			`if (!(10 > 5)) { puts("not greater") } else { puts("greater") }`,
+		},
+		{
+			`
+			let twice = macro(x) { return quote([unquote(x), unquote(x)]); };
+
+			let f = fn() { twice(g()) };
+			`,
+			`let f = fn() { [g(), g()] };`,
+		},
+	}
+
+	for _, tt := range tests {
+		expected := testParseProgram(tt.expected)
+		program := testParseProgram(tt.input)
+
+		env := object.NewEnvironment()
+		DefineMacros(program, env)
+		expanded, err := ExpandMacros(program, env)
+		if err != nil {
+			t.Fatalf("unexpected expansion error: %s", err.Message)
+		}
+
+		if expanded.String() != expected.String() {
+			t.Errorf("not equal. want=%q, got=%q",
+				expected.String(), expanded.String())
+		}
+	}
+
+	errors := []struct {
+		input    string
+		expected string
+	}{
+		{`let m = macro(x) { quote(x) }; m(1, 2)`, "wrong number of arguments, want=1 got=2"},
+		{`let m = macro(x) { 5 }; m(1)`, "macro m must return QUOTE, got INTEGER"},
+		{`let m = macro() { }; m()`, "macro m must return QUOTE, got NULL"},
+		{`let m = macro(x) { missing }; m(1)`, "identifier not found: missing"},
+	}
+	for _, tt := range errors {
+		program := testParseProgram(tt.input)
+		env := object.NewEnvironment()
+		DefineMacros(program, env)
+		_, err := ExpandMacros(program, env)
+		if err == nil || err.Message != tt.expected {
+			t.Errorf("%s: wrong expansion error. want=%q got=%v", tt.input, tt.expected, err)
+		}
+	}
+
+	// Expanded code runs like any other.
+	program := testParseProgram(`
+		let unless = macro(cond, then) { quote(if (!(unquote(cond))) { unquote(then) }) };
+		unless(1 > 2, "ran");
+	`)
+	env := object.NewEnvironment()
+	DefineMacros(program, env)
+	expanded, _ := ExpandMacros(program, env)
+	testStringObject(t, Eval(expanded, object.NewEnvironment()), "ran")
+}
+
+func TestASTJSONRoundTrip(t *testing.T) {
+	inputs := []string{
+		`let add = fn(a, b) { a + b }; add(2, -3) * 4`,
+		`let xs = [1, 2, 3, 4]; [xs[1:], xs[:-1], xs[-1], 1..3, 0..<2]`,
+		`let h = {"b": 1, "a": [true, false], 3: "three"}; [h["b"], h?.a, h?.[3], keys(h)]`,
+		`let n = 5; if (n > 10) { "big" } else if (n > 3) { "medium" } else { "small" }`,
+		`let name = "monkey"; "hello ${upper(name)}, ${1 + 2}!"`,
+		`let f = fn() { defer puts("done"); try { throw "boom"; } catch (e) { e["message"] } finally { 1 } }; f()`,
+		`match ([1, {"k": 2}]) { [a, {k}] if k > 1 => a + k, _ => 0 }`,
+		`const [first, second = 2, ...rest] = [1]; let {x: y = 9} = {}; [first, second, rest, y]`,
+		`let unless = macro(c, t) { quote(if (!(unquote(c))) { unquote(t) }) }; quote(unquote(1 + 1) * x)`,
+		`let z = if (false) { 1 }; z ?? "fallback"`,
+		`let add = fn(a: int, b: int) -> int { a + b }; let n: int = add(1, 2); n`,
+	}
+
+	for _, input := range inputs {
+		program := testParseProgram(input)
+		data, err := ast.MarshalJSON(program)
+		if err != nil {
+			t.Fatalf("%s: marshal failed: %s", input, err)
+		}
+		loaded, err := ast.UnmarshalProgram(data)
+		if err != nil {
+			t.Fatalf("%s: unmarshal failed: %s", input, err)
+		}
+
+		again, _ := ast.MarshalJSON(loaded)
+		if string(again) != string(data) {
+			t.Errorf("%s: JSON changed after a round trip.\nwant=%s\ngot=%s", input, data, again)
+		}
+		if loaded.String() != program.String() {
+			t.Errorf("%s: String() changed. want=%q got=%q", input, program.String(), loaded.String())
+		}
+
+		want := Eval(program, object.NewEnvironment())
+		got := Eval(loaded, object.NewEnvironment())
+		if want == nil || got == nil || want.Type() != got.Type() || want.Inspect() != got.Inspect() {
+			t.Errorf("%s: evaluation differs. want=%v got=%v", input, want, got)
+		}
+	}
+}
+
+// writeModules creates files in a temporary directory and points the
+// module loader at a fresh cache for the rest of the test.
+func writeModules(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, source := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(source), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	saved := Modules
+	Modules = NewModuleLoader(filepath.Join(dir, "shared"))
+	t.Cleanup(func() { Modules = saved })
+	return dir
+}
+
+func TestModules(t *testing.T) {
+	files := map[string]string{
+		"lib/math.mk": `
+			record("math");
+			export const square = fn(x) { x * x };
+			export let twice = fn(f, x) { f(f(x)) };
+			let hidden = 1;`,
+		"lib/geo.mk": `
+			import {square} from "./math.mk";
+			export let area = fn(r) { 3 * square(r) };`,
+		"shared/strs.mk": `export let shout = fn(s) { upper(s) + "!" };`,
+		"cycle/a.mk":     `import "b.mk" as b; export let a = 1;`,
+		"cycle/b.mk":     `import "a.mk" as a; export let b = 2;`,
+		"broken.mk":      "let x = ;",
+		"fails.mk":       `let f = fn() { 1 + "a" }; export let x = f();`,
+	}
+
+	tests := []struct {
+		main     string
+		expected interface{}
+	}{
+		{`import "lib/math.mk" as m; m["square"](4)`, 16},
+		{`import "lib/math.mk" as m; m["twice"](m["square"], 3)`, 81},
+		{`import {square, twice as t} from "lib/math.mk"; t(square, 2)`, 16},
+		{`import "lib/geo.mk" as g; import "lib/math.mk" as m; g["area"](2) + m["square"](1)`, 13},
+		{`import "lib/geo.mk" as g; import "lib/math.mk" as m; recorded()`, []string{"math"}},
+		{`import {shout} from "strs.mk"; shout("hi")`, "HI!"},
+		{`import "lib/math.mk" as m; m?.square(2)`, 4},
+		{`import "lib/math.mk" as m; str(m)`, "<module lib/math.mk>"},
+		{`import "lib/math.mk" as m; m["hidden"]`, errorMessage("module lib/math.mk has no export hidden")},
+		{`import {hidden} from "lib/math.mk";`, errorMessage("module lib/math.mk has no export hidden")},
+		{`import "lib/math.mk" as m; let m = 1;`, errorMessage("cannot reassign constant m")},
+		{`import "missing.mk" as m;`, errorMessage(`cannot find module "missing.mk"`)},
+		{`import "./strs.mk" as s;`, errorMessage(`cannot find module "./strs.mk"`)},
+		{`import "cycle/a.mk" as a;`, errorMessage("import cycle: cycle/a.mk -> cycle/b.mk -> cycle/a.mk")},
+		{`import "broken.mk" as b;`, errorMessage("parse errors in broken.mk: no prefix parse function for ; found")},
+		{`import "fails.mk" as f;`, errorMessage("type mismatch: INTEGER + STRING")},
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	for _, tt := range tests {
+		dir := writeModules(t, files)
+		if err := os.Chdir(dir); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile("main.mk", []byte(tt.main), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		var recorded []object.Object
+		builtins["record"] = &object.Builtin{Fn: func(args ...object.Object) object.Object {
+			recorded = append(recorded, args...)
+			return NULL
+		}}
+		builtins["recorded"] = &object.Builtin{Fn: func(args ...object.Object) object.Object {
+			return &object.Array{Elements: recorded}
+		}}
+
+		evaluated := RunFile("main.mk")
+		testExpectedObject(t, tt.main, evaluated, tt.expected)
+		if err, ok := evaluated.(*object.Error); ok && strings.Contains(tt.main, "missing") && err.Kind != "ImportError" {
+			t.Errorf("%s: wrong error kind. want=ImportError got=%s", tt.main, err.Kind)
+		}
+		if err, ok := evaluated.(*object.Error); ok && strings.Contains(tt.main, "broken") && err.Kind != "SyntaxError" {
+			t.Errorf("%s: wrong error kind. want=SyntaxError got=%s", tt.main, err.Kind)
+		}
+	}
+	delete(builtins, "record")
+	delete(builtins, "recorded")
+}
+
+func TestConstDeclarations(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"const x = 5; x * 2", 10},
+		{"const [a, b = 2] = [1]; a + b", 3},
+		{"const x = 1; let f = fn() { let x = 2; x }; f() + x", 3},
+		{"const x = 1; if (true) { const x = 2; x }", 2},
+		{"let x = 1; const x = 2; x", 2},
+	}
+	for _, tt := range tests {
+		testExpectedObject(t, tt.input, testEval(tt.input), tt.expected)
+	}
+
+	// Each REPL line is parsed on its own, so only the evaluator sees
+	// redeclarations across lines.
+	redeclarations := []struct {
+		lines    []string
+		expected string
+	}{
+		{[]string{"const x = 1;", "let x = 2;"}, "cannot reassign constant x"},
+		{[]string{"const x = 1;", "const x = 2;"}, "cannot reassign constant x"},
+		{[]string{"const {name} = {\"name\": 1};", "let [a, name] = [1, 2];"}, "cannot reassign constant name"},
+	}
+	for _, tt := range redeclarations {
+		env := object.NewEnvironment()
+		var evaluated object.Object
+		for _, line := range tt.lines {
+			program := parser.New(lexer.New(line)).ParseProgram()
+			evaluated = Eval(program, env)
+		}
+		testExpectedObject(t, strings.Join(tt.lines, " "), evaluated, errorMessage(tt.expected))
+	}
+
+	env := object.NewEnvironment()
+	Eval(parser.New(lexer.New("const x = 1;")).ParseProgram(), env)
+	Eval(parser.New(lexer.New("let x = 2;")).ParseProgram(), env)
+	testIntegerObject(t, Eval(parser.New(lexer.New("x")).ParseProgram(), env), 1)
+}
+
+func TestBlockScopes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let x = 1; if (true) { let x = 2; }; x", 1},
+		{"let x = 1; if (false) { 0 } else { let x = 2; x }", 2},
+		{"let x = 1; if (false) { 0 } else { let x = 2; }; x", 1},
+		{"if (true) { let inner = 1; }; inner", errorMessage("identifier not found: inner")},
+		{"let x = 1; try { let x = 2; } finally { let x = 3; }; x", 1},
+		{"let x = 5; if (true) { x + 1 }", 6},
+		{"let f = fn() { if (true) { let y = 2; return y * 10; } }; f()", 20},
+		{"let f = fn(n) { if (true) { let k = n; fn() { k } } }; f(7)()", 7},
+	}
+	for _, tt := range tests {
+		testExpectedObject(t, tt.input, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	input := `let x = 1;
+let f = fn(a) { let b = a; fn() { a + b + x + len([]) } };
+if (x) { let y = x; y }
+let x = 2;`
+	program := testParseProgram(input)
+	if undefined := Resolve(program, object.NewEnvironment()); len(undefined) != 0 {
+		t.Fatalf("unexpected undefined identifiers: %v", undefined)
+	}
+
+	var got []string
+	ast.Inspect(program, func(node ast.Node) bool {
+		if ident, ok := node.(*ast.Identifier); ok && ident.Resolved {
+			got = append(got, fmt.Sprintf("%s@%d:%d", ident.Value, ident.Depth, ident.Slot))
+		}
+		return true
+	})
+	want := []string{
+		"x@0:0", "f@0:1", "a@0:0", "b@0:1", "a@0:0",
+		"a@1:0", "b@1:1", "x@2:0", "len@-1:0",
+		"x@0:0", "y@0:0", "x@1:0", "y@0:0", "x@0:0",
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("wrong addresses.\nwant=%v\ngot =%v", want, got)
+	}
+}
+
+func TestResolveUndefined(t *testing.T) {
+	input := `let g = fn() { later() + missing };
+let later = fn() { 1 };
+nope`
+	program := testParseProgram(input)
+	env := object.NewEnvironment()
+
+	var names []string
+	for _, ident := range Resolve(program, env) {
+		names = append(names, ident.Value)
+	}
+	if strings.Join(names, " ") != "missing nope" {
+		t.Errorf("wrong undefined identifiers. got=%v", names)
+	}
+
+	err := Check(program, env)
+	if err == nil {
+		t.Fatalf("Check found no error")
+	}
+	if err.Message != "identifier not found: missing" {
+		t.Errorf("wrong message. got=%q", err.Message)
+	}
+	if len(err.Stack) != 1 || err.Stack[0] != "at line 1, column 26" {
+		t.Errorf("wrong stack. got=%q", err.Stack)
+	}
+
+	// Short-circuited names are only reported before running a program.
+	testExpectedObject(t, "1 ?? nope", testEval("1 ?? nope"), 1)
+}
+
+func TestCheckLine(t *testing.T) {
+	env := object.NewEnvironment()
+	lines := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let f = fn() { later() + 1 }; 0", 0},
+		{"f()", errorMessage("identifier not found: later")},
+		{"let later = fn() { 2 }; 0", 0},
+		{"f()", 3},
+		{"puts(1); nope", errorMessage("identifier not found: nope")},
+	}
+	for _, tt := range lines {
+		program := testParseProgram(tt.input)
+		var result object.Object
+		if err := CheckLine(program, env); err != nil {
+			result = err
+		} else {
+			result = Eval(program, env)
+		}
+		testExpectedObject(t, tt.input, result, tt.expected)
+	}
+}
+
+func TestDefinitions(t *testing.T) {
+	input := `let x = 1;
+let f = fn(x) { x + y };
+let y = x;
+let x = len([]);
+x`
+	program := testParseProgram(input)
+	definitions := Definitions(program)
+
+	var got []string
+	ast.Inspect(program, func(node ast.Node) bool {
+		if ident, ok := node.(*ast.Identifier); ok {
+			def := "-"
+			if decl, ok := definitions[ident]; ok {
+				def = fmt.Sprintf("%d:%d", decl.Token.Line, decl.Token.Column)
+			}
+			got = append(got, fmt.Sprintf("%s=%s", ident.Value, def))
+		}
+		return true
+	})
+	want := []string{
+		"x=1:5", "f=2:5", "x=2:12", "x=2:12", "y=3:5",
+		"y=3:5", "x=1:5", "x=4:5", "len=-", "x=4:5",
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("wrong definitions.\nwant=%v\ngot =%v", want, got)
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	input := `let x = 1;
+let f = fn(x) { x + z };
+let x = 2;
+if (x) { let x = 3; x }`
+	analysis := Analyze(testParseProgram(input))
+
+	at := func(ident *ast.Identifier) string {
+		if ident == nil {
+			return "-"
+		}
+		return fmt.Sprintf("%d:%d", ident.Token.Line, ident.Token.Column)
+	}
+	var got []string
+	for _, decl := range analysis.Declarations {
+		got = append(got, fmt.Sprintf("%s@%s hides=%s replaces=%s", decl.Name.Value, at(decl.Name), at(decl.Hides), at(decl.Replaces)))
+	}
+	want := []string{
+		"x@1:5 hides=- replaces=-",
+		"f@2:5 hides=- replaces=-",
+		"x@3:5 hides=- replaces=1:5",
+		"x@4:14 hides=3:5 replaces=-",
+		"x@2:12 hides=3:5 replaces=-",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("wrong declarations.\nwant=%q\ngot =%q", want, got)
+	}
+	if len(analysis.Undefined) != 1 || at(analysis.Undefined[0]) != "2:21" {
+		t.Errorf("wrong undefined identifiers. got=%v", analysis.Undefined)
+	}
+}
+
+func TestResolvedEvaluation(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let a = 1; let b = 2; let a = 3; [a, b]", []int{3, 2}},
+		{"let x = 1; let f = fn() { x }; let x = 2; f()", 2},
+		{`let x = "outer"; if (true) { let f = fn() { x }; let r = f(); let x = "inner"; [r, f()] }`,
+			[]string{"outer", "inner"}},
+		{"let count = fn(n) { if (n == 0) { 0 } else { 1 + count(n - 1) } }; count(5)", 5},
+		{"let [a, [b, ...c]] = [1, [2, 3, 4]]; a + b + len(c)", 5},
+		{"match ([1, 2]) { [x, y] if x < y => x + y, _ => 0 }", 3},
+	}
+	for _, tt := range tests {
+		testExpectedObject(t, tt.input, testEval(tt.input), tt.expected)
+	}
+
+	// A REPL keeps one environment; names from earlier lines keep their
+	// slots.
+	env := object.NewEnvironment()
+	for _, line := range []string{"let a = 1; let b = 2;", "let c = a + b;", "let a = 10;"} {
+		Eval(testParseProgram(line), env)
+	}
+	testExpectedObject(t, "a + c", Eval(testParseProgram("a + c"), env), 13)
+	if names := strings.Join(env.Names(), " "); names != "a b c" {
+		t.Errorf("wrong names. got=%q", names)
+	}
+}
+
+func TestRunFileChecks(t *testing.T) {
+	tests := []struct {
+		main     string
+		expected string
+		kind     string
+	}{
+		{"record(1);\nlet f = fn() { nope };", "identifier not found: nope", "RuntimeError"},
+		{"record(1);\nlet f = fn(n: int) { n };\nf(\"one\");", "cannot use STRING as int in argument n to f", "TypeError"},
+		{"record(1);\nlet x = ;", "parse errors in main.mk: no prefix parse function for ; found", "SyntaxError"},
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	ran := false
+	builtins["record"] = &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		ran = true
+		return NULL
+	}}
+	defer delete(builtins, "record")
+
+	for _, tt := range tests {
+		dir := writeModules(t, map[string]string{"main.mk": tt.main})
+		if err := os.Chdir(dir); err != nil {
+			t.Fatal(err)
 		}
-		if errObj.Message != tt.expected {
-			t.Errorf("wrong error message. expected=%q, got=%q",
-				tt.expected, errObj.Message)
+		ran = false
+		evaluated := RunFile("main.mk")
+		testExpectedObject(t, tt.main, evaluated, errorMessage(tt.expected))
+		if err, ok := evaluated.(*object.Error); ok && err.Kind != tt.kind {
+			t.Errorf("%s: wrong error kind. want=%s got=%s", tt.main, tt.kind, err.Kind)
+		}
+		if ran {
+			t.Errorf("%s: program ran despite the error", tt.main)
 		}
 	}
 }
 
-func TestLetStatement(t *testing.T) {
+func TestCheckFatalTypeErrors(t *testing.T) {
 	tests := []struct {
 		input    string
-		expected int64
+		expected string
 	}{
-		{"let a = 5;a;", 5},
-		{"let a = 5 * 5; a;", 25},
-		{"let a = 6; let b = a; b;", 6},
-		{"let a=3; let b = 8; let c = a + b +8;c;", 19},
+		{`1 + "a"`, "type mismatch: INTEGER + STRING"},
+		{`let f = fn() { let x: int = "s"; x };`, "cannot use STRING as int in let x"},
+		{`let f = fn(n: int) { n }; if (false) { f("s") }`, "cannot use STRING as int in argument n to f"},
+		{`let r = try { 1 + "a" } catch (e) { e["message"] }; puts(r);`, ""},
+		{`try { let x: int = "s"; } catch (e) { e["kind"] }`, ""},
+		{`let debug = false; if (debug) { puts(-"x") }`, ""},
+		{`let f = fn() { 1 + "a" };`, ""},
+		{`match (1) { 2 => 1 + "a", _ => 0 }`, ""},
+		{`1 ?? 1 + "a"`, ""},
+		{`let g = fn() { 1 }; try { let f = fn() { let x: int = "s"; x }; f() } catch (e) { 0 }`, ""},
+		{`return 1; 1 + "a"`, ""},
+		{`let u = puts(1); u?.a["b"]`, ""},
+		{`let u = puts(1); u?.f(1 + "x")[0:1]`, ""},
+		{`let u = {}["k"]; u?.a(1 + "x")`, ""},
+		{`let u = {"f": len}; u?.f(1 + "x")`, "type mismatch: INTEGER + STRING"},
+		{`let u = puts(1); u["a"]?.b`, "index operator not supported: NULL"},
+	}
+	for _, tt := range tests {
+		got := ""
+		if err := Check(testParseProgram(tt.input), object.NewEnvironment()); err != nil {
+			got = err.Message
+		}
+		if got != tt.expected {
+			t.Errorf("%s: wrong error. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestTypeCheck(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{`1 + "a"`, []string{"1:3: type mismatch: INTEGER + STRING"}},
+		{`"a" - "b"`, []string{"1:5: unknown operator: STRING - STRING"}},
+		{`[1] + [2]`, []string{"1:5: unknown operator: ARRAY + ARRAY"}},
+		{"-true", []string{"1:1: unknown operator: -BOOLEAN"}},
+		{"let x = 5; x()", []string{"1:12: not a function: INTEGER"}},
+		{"len(1)", []string{"1:5: argument to `len` must be ARRAY, STRING or HASH, got INTEGER"}},
+		{`repeat("a", "b")`, []string{"1:13: second argument to `repeat` must be INTEGER, got STRING"}},
+		{`len("abc") + upper("d")`, []string{"1:12: type mismatch: INTEGER + STRING"}},
+		{`5[0]; [1]["a"]; {}[fn() {}]`, []string{
+			"1:2: index operator not supported: INTEGER",
+			"1:10: index operator not supported: ARRAY",
+			"1:20: unusable as hash key: FUNCTION",
+		}},
+		{`1.."a"; "abc"[1:"x"]`, []string{
+			"1:2: range bounds must be INTEGER, got INTEGER..STRING",
+			"1:17: slice bound must be INTEGER, got STRING",
+		}},
+		{`let n: int = "s";`, []string{"1:5: cannot use STRING as int in let n"}},
+		{`let n: int = len("s"); n + "x"`, []string{"1:26: type mismatch: INTEGER + STRING"}},
+		{`let f = fn(a: int, b: string) { a }; f(1, 2)`, []string{"1:43: cannot use INTEGER as string in argument b to f"}},
+		{`let f = fn() -> int { "s" };`, []string{"1:23: cannot use STRING as int in return from f"}},
+		{`let f = fn(x) -> string { if (x) { return 1; } "s" };`, []string{
+			"1:43: cannot use INTEGER as string in return from f",
+		}},
+		{`let f = fn(s: string) -> int { s * 2 };`, []string{"1:34: type mismatch: STRING * INTEGER"}},
+		{`let g = fn() { 1 + "a" }; -true`, []string{
+			"1:18: type mismatch: INTEGER + STRING",
+			"1:27: unknown operator: -BOOLEAN",
+		}},
+		{`let x = 1; x + 1; let x = "s"; x + 1`, []string{"1:34: type mismatch: STRING + INTEGER"}},
+		{`quote(1 + "a"); quote(unquote(2 - "b"))`, []string{"1:33: type mismatch: INTEGER - STRING"}},
+
+		// Types only known at run time are left to the evaluator.
+		{`let f = fn(x, y) { x + y }; f(1, "a")`, nil},
+		{`let x = 1; let f = fn() { x + 1 }; let x = "s";`, nil},
+		{`let id = fn(v) { v }; let n: int = id("s");`, nil},
+		{`if (true) { 1 } else { "a" } + 1`, nil},
+		{`let [a, b] = [1, "s"]; a + b`, nil},
+		{`let f = fn(g: fn) { g(1) }; f(len); 1 == "a"`, nil},
+		{`let x = 1 ?? "a"; x - 1`, nil},
+		{`let f = fn(n: int) -> int { if (n < 2) { return n; } f(n - 1) + f(n - 2) }; f(10)`, nil},
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
-		testIntegerObject(t, evaluated, tt.expected)
+		var got []string
+		for _, err := range TypeCheck(testParseProgram(tt.input), object.NewEnvironment()) {
+			got = append(got, err.String())
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.expected, "\n") {
+			t.Errorf("%s: wrong type errors.\nwant=%q\ngot =%q", tt.input, tt.expected, got)
+		}
+	}
+
+	// Values already in the environment, as in the REPL, have known types.
+	env := object.NewEnvironment()
+	Eval(testParseProgram(`let s = "a"; let f = fn(n: int) { n };`), env)
+	var got []string
+	for _, err := range TypeCheck(testParseProgram(`s - 1; f(s)`), env) {
+		got = append(got, err.String())
+	}
+	want := []string{"1:3: type mismatch: STRING - INTEGER", "1:10: cannot use STRING as int in argument n to f"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("wrong type errors with env.\nwant=%q\ngot =%q", want, got)
+	}
+}
+
+func TestTypeAnnotations(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let add = fn(a: int, b: int) -> int { a + b }; add(1, 2)", 3},
+		{`let n: int = 4; let s: string = "x"; let ok: bool = n > 3; if (ok) { s }`, "x"},
+		{`let f = fn(x: any) { x }; f("s")`, "s"},
+		{"let f = fn(g: fn) { g(2) }; f(fn(x) { x * 2 })", 4},
+		{"let f = fn(g: fn) { g([2]) }; f(len)", 1},
+		{`let id = fn(v) { v }; let n: int = id("s"); n`, errorMessage("cannot use STRING as int in let n")},
+		{"let f = fn(s: string) { s }; f(1)", errorMessage("cannot use INTEGER as string in argument s to f")},
+		{"let f = fn(g: fn) { g(2) }; f(5)", errorMessage("cannot use INTEGER as fn in argument g to f")},
+		{`let f = fn(x) -> int { x }; f("a")`, errorMessage("cannot use STRING as int in return from f")},
+		{`let f = fn(x) -> int { return x; }; f(true)`, errorMessage("cannot use BOOLEAN as int in return from f")},
+		{`let f = fn(x: int) { x }; map([1, "a"], f)`, errorMessage("cannot use STRING as int in argument x to f")},
+		{`try { let x: int = "s"; } catch (e) { e["kind"] }`, "TypeError"},
+		{`try { throw error("e") } catch (e) { let err: error = e; err["message"] }`, "e"},
+	}
+	for _, tt := range tests {
+		testExpectedObject(t, tt.input, testEval(tt.input), tt.expected)
+	}
+
+	fn := testEval("fn(x: int, y) -> bool { x > y }")
+	if fn.Inspect() != "fn(x: int,y) -> bool {\n(x > y)\n}" {
+		t.Errorf("wrong function. got=%q", fn.Inspect())
+	}
+}
+
+
+func TestDestructuringLet(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let [a, b] = [1, 2]; a + b", 3},
+		{"let [a, ...rest] = [1, 2, 3]; rest", []int{2, 3}},
+		{"let [a, ...rest] = [1]; rest", []int{}},
+		{"let [_, b] = [1, 2]; b", 2},
+		{"let [a, b = 5] = [1]; b", 5},
+		{"let [a, b = a * 10] = [4]; b", 40},
+		{"let [a, [b, c]] = [1, [2, 3]]; a + b + c", 6},
+		{`let {name, age: years} = {"name": "Ann", "age": 30}; years`, 30},
+		{`let {name, age: years} = {"name": "Ann", "age": 30}; name`, "Ann"},
+		{`let {"first name": first} = {"first name": "Bo"}; first`, "Bo"},
+		{`let {role = "guest"} = {}; role`, "guest"},
+		{`let {tags: [first, ...others]} = {"tags": ["a", "b", "c"]}; others`, []string{"b", "c"}},
+		{`let [{x}, {x: y}] = [{"x": 1}, {"x": 2}]; x + y`, 3},
+		{"let pair = fn() { [1, 2] }; let [q, r] = pair(); q * 10 + r", 12},
+		{"let [a, b] = [1]", errorMessage("array pattern [a, b] expects 2 elements, got 1")},
+		{"let [a] = [1, 2]", errorMessage("array pattern [a] expects 1 elements, got 2")},
+		{"let [a, b] = 5", errorMessage("cannot destructure INTEGER with array pattern [a, b]")},
+		{`let {name} = [1]`, errorMessage(`cannot destructure ARRAY with hash pattern {"name": name}`)},
+		{`let {name} = {"nom": 1}`, errorMessage(`hash pattern {"name": name} is missing key "name"`)},
+		{`let [a, {b}] = [1, 2]`, errorMessage(`cannot destructure INTEGER with hash pattern {"b": b}`)},
+		{`let [1, b] = [2, 3]`, errorMessage("pattern 1 does not match 2")},
+		{`let [a = missing] = []`, errorMessage("identifier not found: missing")},
+	}
+	for _, tt := range tests {
+		testExpectedObject(t, tt.input, testEval(tt.input), tt.expected)
 	}
 }
 
@@ -293,6 +1313,135 @@ func TestStringConcatenation(t *testing.T) {
 	}
 }
 
+func TestStringInterpolation(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"no interpolation"`, "no interpolation"},
+		{`let name = "Monkey"; "Hello ${name}!"`, "Hello Monkey!"},
+		{`let items = [1, 2, 3]; "${len(items)} items"`, "3 items"},
+		{`let user = {"name": "Ann"}; "Hi ${user["name"]}"`, "Hi Ann"},
+		{`"${1 < 2} ${[1, 2]} ${if (false) { 1 }}"`, "true [1,2] null"},
+		{`"${"nested ${1 + 1}"}"`, "nested 2"},
+	}
+	for _, tt := range tests {
+		testStringObject(t, testEval(tt.input), tt.expected)
+	}
+
+	evaluated := testEval(`"${missing}"`)
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+	}
+	if errObj.Message != "identifier not found: missing" {
+		t.Errorf("wrong error message. got=%q", errObj.Message)
+	}
+}
+
+func TestStringComparison(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{`"a" == "a"`, true},
+		{`"a" == "b"`, false},
+		{`"a" != "b"`, true},
+		{`"abc" < "abd"`, true},
+		{`"b" > "abc"`, true},
+		{`"" < "a"`, true},
+		{`let s = "mon"; s + "key" == "monkey"`, true},
+	}
+	for _, tt := range tests {
+		testBooleanObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestStringIndexAndSlice(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`"monkey"[0]`, "m"},
+		{`"monkey"[5]`, "y"},
+		{`"monkey"[6]`, nil},
+		{`"monkey"[-7]`, nil},
+		{`"héllo"[1]`, "é"},
+		{`"monkey"[1:3]`, "on"},
+		{`"monkey"[:3]`, "mon"},
+		{`"monkey"[3:]`, "key"},
+		{`"monkey"[:]`, "monkey"},
+		{`"monkey"[4:2]`, ""},
+		{`"monkey"[2:100]`, "nkey"},
+		{`"monkey"["a":]`, "slice bound must be INTEGER, got STRING"},
+		{`5[1:]`, "slice operator not supported: INTEGER"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		expected, ok := tt.expected.(string)
+		if !ok {
+			testNullObject(t, evaluated)
+			continue
+		}
+		if errObj, ok := evaluated.(*object.Error); ok {
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q",
+					expected, errObj.Message)
+			}
+			continue
+		}
+		testStringObject(t, evaluated, expected)
+	}
+}
+
+func TestStringBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`len("héllo")`, 5},
+		{`split("a,b,c", ",")`, []string{"a", "b", "c"}},
+		{`split("abc", "")`, []string{"a", "b", "c"}},
+		{`join(["a", "b", "c"], "-")`, "a-b-c"},
+		{`join([1, true, "x"], ", ")`, "1, true, x"},
+		{`join("abc", "-")`, errorMessage("argument to `join` must be ARRAY, got STRING")},
+		{`trim("  padded  ")`, "padded"},
+		{`upper("Monkey")`, "MONKEY"},
+		{`lower("Monkey")`, "monkey"},
+		{`upper(1)`, errorMessage("argument to `upper` must be STRING, got INTEGER")},
+		{`contains("monkey", "key")`, true},
+		{`contains("monkey", "ape")`, false},
+		{`startsWith("monkey", "mon")`, true},
+		{`endsWith("monkey", "mon")`, false},
+		{`replace("a-b-c", "-", "+")`, "a+b+c"},
+		{`indexOf("monkey", "key")`, 3},
+		{`indexOf("héllo", "l")`, 2},
+		{`indexOf("monkey", "ape")`, -1},
+		{`repeat("ab", 3)`, "ababab"},
+		{`repeat("ab", -1)`, errorMessage("negative count to `repeat`: -1")},
+		{`repeat("ab", 9223372036854775807)`, errorMessage("`repeat` result too large: 9223372036854775807 copies of 2 bytes")},
+		{`repeat("ab", 134217729)`, errorMessage("`repeat` result too large: 134217729 copies of 2 bytes")},
+		{`len(repeat("", 9223372036854775807))`, 0},
+		{`chars("héy")`, []string{"h", "é", "y"}},
+		{`str(12)`, "12"},
+		{`str([1, 2])`, "[1,2]"},
+		{`str("s")`, "s"},
+		{`int("42")`, 42},
+		{`int(" -7 ")`, -7},
+		{`int(true)`, 1},
+		{`int("4x")`, errorMessage("could not convert \"4x\" to INTEGER")},
+		{`int([])`, errorMessage("argument to `int` not supported, got=ARRAY")},
+		{`parseInt("ff", 16)`, 255},
+		{`parseInt("101", 2)`, 5},
+		{`parseInt("12")`, 12},
+		{`parseInt("nope")`, nil},
+		{`parseInt("1", 99)`, errorMessage("invalid base to `parseInt`: 99")},
+	}
+	for _, tt := range tests {
+		testExpectedObject(t, tt.input, testEval(tt.input), tt.expected)
+	}
+}
+
 func TestBuiltinFunctions(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -337,6 +1486,115 @@ func TestBuiltinFunctions(t *testing.T) {
 	}
 }
 
+func TestFormatBuiltin(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`format("plain")`, "plain"},
+		{`format("%d items", 3)`, "3 items"},
+		{`format("%s and %s", "this", "that")`, "this and that"},
+		{`format("%t", 1 < 2)`, "true"},
+		{`format("%v", [1, 2 * 2])`, "[1,4]"},
+		{`format("%s", {"a": 1})`, "{a: 1}"},
+		{`format("%5d|%-5d|%05d", 42, 42, 42)`, "   42|42   |00042"},
+		{`format("%-6s|%6s", "ab", "cd")`, "ab    |    cd"},
+		{`format("%.3s", "monkey")`, "mon"},
+		{`format("%q", "hi")`, `"hi"`},
+		{`format("%x", 255)`, "ff"},
+		{`format("100%%")`, "100%"},
+		{`printf("%d", 1)`, ""},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if tt.expected == "" {
+			testNullObject(t, evaluated)
+			continue
+		}
+		testStringObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestFormatBuiltinErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`format()`, "wrong number of arguments, want>=1 got=0"},
+		{`format(1)`, "argument to `format` must be STRING, got INTEGER"},
+		{`format("%d")`, "missing argument for %d in `format`"},
+		{`format("%d", "x")`, "wrong argument for %d in `format`, want=INTEGER got=STRING"},
+		{`format("%t", 1)`, "wrong argument for %t in `format`, want=BOOLEAN got=INTEGER"},
+		{`format("%z", 1)`, "unknown format verb %z in `format`"},
+		{`format("%", 1)`, "incomplete format verb in `format`: \"%\""},
+		{`format("%d", 1, 2)`, "too many arguments to `format`, want=1 got=2"},
+		{`printf("%q", 1)`, "wrong argument for %q in `printf`, want=STRING got=INTEGER"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != tt.expected {
+			t.Errorf("wrong error message. expected=%q, got=%q",
+				tt.expected, errObj.Message)
+		}
+	}
+}
+
+func TestCollectionBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`map([1, 2, 3], fn(x) { x * 2 })`, []int{2, 4, 6}},
+		{`map([], fn(x) { x })`, []int{}},
+		{`map([1], len)`, errorMessage("argument to `len` not supported, got=INTEGER")},
+		{`map([1], 1)`, errorMessage("second argument to `map` must be FUNCTION, got INTEGER")},
+		{`map(1, fn(x) { x })`, errorMessage("argument to `map` must be ARRAY, got INTEGER")},
+		{`map([1], fn(x, y) { x })`, errorMessage("wrong number of arguments, want=2 got=1")},
+		{`filter([1, 2, 3, 4], fn(x) { x > 2 })`, []int{3, 4}},
+		{`reduce([1, 2, 3], fn(acc, x) { acc + x }, 10)`, 16},
+		{`reduce([1, 2, 3], fn(acc, x) { acc * x })`, 6},
+		{`reduce([], fn(acc, x) { acc + x })`, nil},
+		{`reduce([1, 2], fn(acc, x) { acc + y })`, errorMessage("identifier not found: y")},
+		{`each([1, 2], fn(x) { x })`, nil},
+		{`find([1, 2, 3], fn(x) { x > 1 })`, 2},
+		{`find([1, 2, 3], fn(x) { x > 5 })`, nil},
+		{`any([1, 2, 3], fn(x) { x == 2 })`, true},
+		{`any([], fn(x) { true })`, false},
+		{`all([1, 2, 3], fn(x) { x > 0 })`, true},
+		{`all([1, 2, 3], fn(x) { x > 1 })`, false},
+		{`sort([3, 1, 2])`, []int{1, 2, 3}},
+		{`sort(["b", "c", "a"])`, []string{"a", "b", "c"}},
+		{`sort([1, 3, 2], fn(a, b) { a > b })`, []int{3, 2, 1}},
+		{`sort([1, "a"])`, errorMessage("`sort` cannot order mixed INTEGER and STRING values")},
+		{`sort([true])`, errorMessage("`sort` cannot order BOOLEAN values")},
+		{`sort([1, 2], fn(a, b) { a + z })`, errorMessage("identifier not found: z")},
+		{`let a = [2, 1]; sort(a); a`, []int{2, 1}},
+		{`reverse([1, 2, 3])`, []int{3, 2, 1}},
+		{`reverse("abc")`, "cba"},
+		{`zip([1, 2, 3], [4, 5])[1]`, []int{2, 5}},
+		{`len(zip([1, 2, 3], [4, 5]))`, 2},
+		{`flatten([1, [2, [3, [4]]]])`, []int{1, 2, 3, 4}},
+		{`len(flatten([1, [2, [3]]], 1))`, 3},
+		{`uniq([1, 2, 1, 3, 2])`, []int{1, 2, 3}},
+		{`uniq(["a", "b", "a"])`, []string{"a", "b"}},
+		{`sum([1, 2, 3])`, 6},
+		{`sum([])`, 0},
+		{`sum([1, "a"])`, errorMessage("`sum` expects an array of INTEGER, found STRING")},
+		{`min([3, 1, 2])`, 1},
+		{`max([3, 1, 2])`, 3},
+		{`max(["pear", "apple"])`, "pear"},
+		{`min([])`, nil},
+	}
+	for _, tt := range tests {
+		testExpectedObject(t, tt.input, testEval(tt.input), tt.expected)
+	}
+}
+
 func TestArrayLiterals(t *testing.T) {
 	input := "[1, 2 * 2, 3 + 3]"
 	evaluated := testEval(input)
@@ -396,6 +1654,14 @@ func TestArrayIndexExpressions(t *testing.T) {
 		},
 		{
 			"[1, 2, 3][-1]",
+			3,
+		},
+		{
+			"[1, 2, 3][-3]",
+			1,
+		},
+		{
+			"[1, 2, 3][-4]",
 			nil,
 		},
 	}
@@ -410,6 +1676,54 @@ func TestArrayIndexExpressions(t *testing.T) {
 	}
 }
 
+func TestArraySliceExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"[1, 2, 3, 4][1:3]", []int{2, 3}},
+		{"[1, 2, 3, 4][:2]", []int{1, 2}},
+		{"[1, 2, 3, 4][2:]", []int{3, 4}},
+		{"[1, 2, 3, 4][:-1]", []int{1, 2, 3}},
+		{"[1, 2, 3, 4][-2:]", []int{3, 4}},
+		{"[1, 2, 3, 4][-10:10]", []int{1, 2, 3, 4}},
+		{"[1, 2, 3, 4][3:1]", []int{}},
+		{"[][:]", []int{}},
+		{`"monkey"[-3:]`, "key"},
+		{`"monkey"[-1]`, "y"},
+		{"let a = [1, 2, 3]; let b = a[:]; push(b, 4); len(a)", 3},
+	}
+	for _, tt := range tests {
+		testExpectedObject(t, tt.input, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestRangeExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"1..5", []int{1, 2, 3, 4, 5}},
+		{"1..<5", []int{1, 2, 3, 4}},
+		{"0..<0", []int{}},
+		{"5..1", []int{}},
+		{"-2..0", []int{-2, -1, 0}},
+		{"let n = 3; 1..n + 1", []int{1, 2, 3, 4}},
+		{"len(0..<10)", 10},
+		{"(1..10)[2:4]", []int{3, 4}},
+		{`1.."a"`, errorMessage("range bounds must be INTEGER, got INTEGER..STRING")},
+		{"0..100000000", errorMessage("range too large: 100000001 elements")},
+		{"(-9223372036854775807 - 1)..0", errorMessage("range too large: 9223372036854775809 elements")},
+		{"0..9223372036854775807", errorMessage("range too large: 9223372036854775808 elements")},
+		{"(-9223372036854775807 - 1)..9223372036854775807", errorMessage("range too large: 18446744073709551616 elements")},
+		{"9223372036854775806..9223372036854775807", []int{9223372036854775806, 9223372036854775807}},
+		{"9223372036854775807..<9223372036854775807", []int{}},
+	}
+	for _, tt := range tests {
+		testExpectedObject(t, tt.input, testEval(tt.input), tt.expected)
+	}
+}
+
 func TestHashLiterals(t *testing.T) {
 	input := `let two = "two";
             {
@@ -425,23 +1739,104 @@ func TestHashLiterals(t *testing.T) {
 	if !ok {
 		t.Fatalf("Eval didn't return Hash. got=%T (%+v)", evaluated, evaluated)
 	}
-	expected := map[object.HashKey]int64{
-		(&object.String{Value: "one"}).HashKey():   1,
-		(&object.String{Value: "two"}).HashKey():   2,
-		(&object.String{Value: "three"}).HashKey(): 3,
-		(&object.Integer{Value: 4}).HashKey():      4,
-		TRUE.HashKey():                             5,
-		FALSE.HashKey():                            6,
+	expected := []struct {
+		key   object.Hashable
+		value int64
+	}{
+		{&object.String{Value: "one"}, 1},
+		{&object.String{Value: "two"}, 2},
+		{&object.String{Value: "three"}, 3},
+		{&object.Integer{Value: 4}, 4},
+		{TRUE, 5},
+		{FALSE, 6},
 	}
-	if len(result.Pairs) != len(expected) {
-		t.Fatalf("Hash has wrong num of pairs. got=%d", len(result.Pairs))
+	if result.Len() != len(expected) {
+		t.Fatalf("Hash has wrong num of pairs. got=%d", result.Len())
 	}
-	for expectedKey, expectedValue := range expected {
-		pair, ok := result.Pairs[expectedKey]
+	for _, ex := range expected {
+		pair, ok := result.Get(ex.key)
 		if !ok {
 			t.Errorf("no pair for given key in Pairs")
 		}
-		testIntegerObject(t, pair.Value, expectedValue)
+		testIntegerObject(t, pair.Value, ex.value)
+	}
+}
+
+func TestHashInspectOrder(t *testing.T) {
+	input := `{"b": 2, "a": 1, 3: "c", true: [1]}`
+	expected := "{b: 2,a: 1,3: c,true: [1]}"
+	for i := 0; i < 20; i++ {
+		evaluated := testEval(input)
+		if evaluated.Inspect() != expected {
+			t.Fatalf("wrong Inspect output. want=%q got=%q", expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestHashBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`len({"a": 1, "b": 2})`, 2},
+		{`len({})`, 0},
+		{`keys({"b": 1, "a": 2})`, []string{"b", "a"}},
+		{`values({"b": 1, "a": 2})`, []int{1, 2}},
+		{`str(entries({"b": 1, "a": 2}))`, "[[b,1],[a,2]]"},
+		{`has({"a": 1}, "a")`, true},
+		{`has({"a": 1}, "b")`, false},
+		{`has({"a": 1}, [len])`, errorMessage("unusable as hash key: ARRAY")},
+		{`keys(delete({"a": 1, "b": 2, "c": 3}, "b"))`, []string{"a", "c"}},
+		{`let h = {"a": 1}; delete(h, "a"); len(h)`, 1},
+		{`keys(delete({"a": 1}, "zzz"))`, []string{"a"}},
+		{`values(merge({"a": 1, "b": 2}, {"b": 3, "c": 4}))`, []int{1, 3, 4}},
+		{`keys(merge({"a": 1}, {"c": 4}, {"b": 2}))`, []string{"a", "c", "b"}},
+		{`merge({}, 1)`, errorMessage("argument to `merge` must be HASH, got INTEGER")},
+		{`keys([1])`, errorMessage("argument to `keys` must be HASH, got ARRAY")},
+		{`has({})`, errorMessage("wrong number of arguments, want=2 got=1")},
+	}
+	for _, tt := range tests {
+		testExpectedObject(t, tt.input, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestStructuralEquality(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"[1, 2] == [1, 2]", true},
+		{"[1, 2] != [1, 2]", false},
+		{"[1, 2] == [2, 1]", false},
+		{`[1, [2, "x"]] == [1, [2, "x"]]`, true},
+		{`{"a": 1, "b": [2]} == {"b": [2], "a": 1}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{"[] == {}", false},
+		{"let n = if (false) { 1 }; n == if (false) { 2 }", true},
+		{"let f = fn() { 1 }; f == f", true},
+		{"fn() { 1 } == fn() { 1 }", false},
+	}
+	for _, tt := range tests {
+		testBooleanObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestCompositeHashKeys(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`let h = {[1, "a"]: "tuple"}; h[[1, "a"]]`, "tuple"},
+		{`let h = {[1, "a"]: "tuple"}; h[["a", 1]]`, nil},
+		{`{{"k": [1]}: 5}[{"k": [1]}]`, 5},
+		{`len({[1, 2]: 1, [1, 2]: 2})`, 1},
+		{`has({[1]: 1}, [1])`, true},
+		{`{[fn() { 1 }]: 1}`, errorMessage("unusable as hash key: ARRAY")},
+		{`{"a": 1}[[len]]`, errorMessage("unusable as hash key: ARRAY")},
+		{`str(uniq([[1, 2], [1, 2], [2, 1]]))`, "[[1,2],[2,1]]"},
+	}
+	for _, tt := range tests {
+		testExpectedObject(t, tt.input, testEval(tt.input), tt.expected)
 	}
 }
 
@@ -490,6 +1885,100 @@ func TestHashIndexExpressions(t *testing.T) {
 	}
 }
 
+func TestBuiltinSignatures(t *testing.T) {
+	for _, name := range BuiltinNames() {
+		if !strings.HasPrefix(BuiltinSignature(name), name+"(") {
+			t.Errorf("builtin %s has signature %q", name, BuiltinSignature(name))
+		}
+	}
+}
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"60 * 60 * 24", "86400"},
+		{`"a" + "b" == "ab"`, "true"},
+		{"-(2 - 5)", "3"},
+		{"1 / 0", "(1 / 0)"},
+		{"1 + true", "(1 + true)"},
+		{"3 ?? f()", "3"},
+		{"const day = 60 * 60 * 24; day * 7", "const day = 86400;604800"},
+		{"let day = 86400; day * 7", "let day = 86400;(day * 7)"},
+		{"f(x); const x = 2; x", "f(x)const x = 2;2"},
+		{"const x = 2; fn(x) { x }; fn() { x }", "const x = 2;fn(x)xfn()2"},
+		{"const x = 2; if (true) { let x = 3; x }; x", "const x = 2;if true { let x = 3;x }2"},
+		{"if (1 > 2) { a } else { b }", "b"},
+		{"if (false) { a }", "if false {  }"},
+		{"const debug = false; if (!debug) { 1 } else { 2 }", "const debug = false;1"},
+		{"quote(1 + 2)", "quote((1 + 2))"},
+	}
+	for _, tt := range tests {
+		program := Optimize(testParseProgram(tt.input))
+		if got := program.String(); got != tt.expected {
+			t.Errorf("%s: got=%q, want=%q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+// TestOptimizeEquivalence runs each program with and without Optimize and
+// expects the same result, errors included.
+func TestOptimizeEquivalence(t *testing.T) {
+	inputs := []string{
+		"60 * 60 * 24",
+		"1 / 0",
+		"let f = fn(n) { n / (2 - 2) }; f(4)",
+		`try { 10 / (5 - 5) } catch (e) { e["message"] }`,
+		`"a" - "b"`,
+		"1 + true",
+		"-true",
+		"!(1 < 2)",
+		"const x = 5; let f = fn() { x * 2 }; f()",
+		"let f = fn() { y }; const y = 3; f()",
+		"const x = 1; let g = fn(x) { x + 1 }; g(10)",
+		"const x = 1; let f = fn() { let x = 2; x }; f() + x",
+		"const s: string = 1; s",
+		"const n: int = 4; n * n",
+		"const x = 1; if (true) { const x = 2; x } else { 3 } + x",
+		"if (1 < 2) { let a = 1; a + 1 } else { 0 }",
+		"if (0) { 1 }",
+		`if ({}["k"]) { 1 } else { 2 }`,
+		"const on = true; match (on) { true => 1 + 1, _ => 0 }",
+		"const k = \"key\"; {k: 1 + 1, \"b\": 2 * 3}[k]",
+		"const two = 2; [1, two, two * 2][1:3]",
+		"const w = \"world\"; \"hello ${w}\"",
+		"const n = 3; 1..n",
+		`{}["k"] ?? 1 + 2`,
+	}
+	for _, input := range inputs {
+		p := parser.New(lexer.New(input))
+		program := p.ParseProgram()
+		if len(p.Errors()) > 0 {
+			t.Fatalf("%s: parse errors: %v", input, p.Errors())
+		}
+		want := testEval(input)
+		program = Optimize(program)
+		got := Eval(program, object.NewEnvironment())
+		if got == nil || want == nil {
+			if got != want {
+				t.Errorf("%s: got=%v, want=%v", input, got, want)
+			}
+			continue
+		}
+		if got.Inspect() != want.Inspect() {
+			t.Errorf("%s: got=%q, want=%q", input, got.Inspect(), want.Inspect())
+		}
+		if gotErr, ok := got.(*object.Error); ok {
+			wantErr := want.(*object.Error)
+			if gotErr.Kind != wantErr.Kind || gotErr.Message != wantErr.Message {
+				t.Errorf("%s: got=%s: %s, want=%s: %s", input,
+					gotErr.Kind, gotErr.Message, wantErr.Kind, wantErr.Message)
+			}
+		}
+	}
+}
+
 func testEval(input string) object.Object {
 	l := lexer.New(input)
 	p := parser.New(l)
@@ -499,6 +1988,56 @@ func testEval(input string) object.Object {
 	return Eval(program, env)
 }
 
+type errorMessage string
+
+// testExpectedObject checks evaluated against a Go value: int, bool,
+// string, []string, []int, errorMessage, or nil for NULL.
+func testExpectedObject(t *testing.T, input string, evaluated object.Object, expected interface{}) bool {
+	switch expected := expected.(type) {
+	case int:
+		return testIntegerObject(t, evaluated, int64(expected))
+	case bool:
+		return testBooleanObject(t, evaluated, expected)
+	case string:
+		return testStringObject(t, evaluated, expected)
+	case errorMessage:
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("%s: object is not Error. got=%T (%+v)", input, evaluated, evaluated)
+			return false
+		}
+		if errObj.Message != string(expected) {
+			t.Errorf("%s: wrong error message. expected=%q, got=%q",
+				input, expected, errObj.Message)
+			return false
+		}
+	case []string:
+		arr, ok := evaluated.(*object.Array)
+		if !ok || len(arr.Elements) != len(expected) {
+			t.Errorf("%s: wrong array. got=%T (%+v)", input, evaluated, evaluated)
+			return false
+		}
+		for i, el := range expected {
+			testStringObject(t, arr.Elements[i], el)
+		}
+	case []int:
+		arr, ok := evaluated.(*object.Array)
+		if !ok || len(arr.Elements) != len(expected) {
+			t.Errorf("%s: wrong array. got=%T (%+v)", input, evaluated, evaluated)
+			return false
+		}
+		for i, el := range expected {
+			testIntegerObject(t, arr.Elements[i], int64(el))
+		}
+	case nil:
+		return testNullObject(t, evaluated)
+	default:
+		t.Errorf("%s: unhandled expected type %T", input, expected)
+		return false
+	}
+	return true
+}
+
 func testIntegerObject(t *testing.T, re object.Object, ex int64) bool {
 	res, ok := re.(*object.Integer)
 	if !ok {
@@ -512,6 +2051,19 @@ func testIntegerObject(t *testing.T, re object.Object, ex int64) bool {
 	return true
 }
 
+func testStringObject(t *testing.T, re object.Object, ex string) bool {
+	res, ok := re.(*object.String)
+	if !ok {
+		t.Errorf("object is not String, got=%T (%+v)", re, re)
+		return false
+	}
+	if res.Value != ex {
+		t.Errorf("object has wrong value, got=%q, want=%q", res.Value, ex)
+		return false
+	}
+	return true
+}
+
 func testBooleanObject(t *testing.T, evaluated object.Object, ex bool) bool {
 	res, ok := evaluated.(*object.Boolean)
 	if !ok {
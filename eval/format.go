@@ -0,0 +1,106 @@
+package eval
+
+import (
+	"bytes"
+	"fmt"
+	"monkey/object"
+)
+
+// formatObjects renders template the way Go's fmt would, but with verbs
+// checked against monkey object types. Supported verbs are:
+//
+//	%d %x  INTEGER
+//	%s     any object, strings are written raw and everything else via Inspect
+//	%q     STRING, quoted
+//	%t     BOOLEAN
+//	%v     any object via Inspect
+//	%%     a literal percent sign
+//
+// Each verb may carry the flags '-', '+', '0' and ' ', a width and a
+// precision, e.g. "%-8s" or "%05d".
+func formatObjects(name string, template string, args []object.Object) (string, *object.Error) {
+	var out bytes.Buffer
+	argIdx := 0
+
+	for i := 0; i < len(template); i++ {
+		ch := template[i]
+		if ch != '%' {
+			out.WriteByte(ch)
+			continue
+		}
+
+		start := i
+		i++
+		for i < len(template) && isFormatFlag(template[i]) {
+			i++
+		}
+		for i < len(template) && isDigit(template[i]) {
+			i++
+		}
+		if i < len(template) && template[i] == '.' {
+			i++
+			for i < len(template) && isDigit(template[i]) {
+				i++
+			}
+		}
+		if i >= len(template) {
+			return "", newError("incomplete format verb in `%s`: %q", name, template[start:])
+		}
+
+		verb := template[i]
+		spec := template[start:i]
+		if verb == '%' {
+			out.WriteByte('%')
+			continue
+		}
+
+		if argIdx >= len(args) {
+			return "", newError("missing argument for %%%c in `%s`", verb, name)
+		}
+		arg := args[argIdx]
+		argIdx++
+
+		switch verb {
+		case 'd', 'x':
+			integer, ok := arg.(*object.Integer)
+			if !ok {
+				return "", newError("wrong argument for %%%c in `%s`, want=INTEGER got=%s",
+					verb, name, arg.Type())
+			}
+			out.WriteString(fmt.Sprintf(spec+string(verb), integer.Value))
+		case 't':
+			boolean, ok := arg.(*object.Boolean)
+			if !ok {
+				return "", newError("wrong argument for %%%c in `%s`, want=BOOLEAN got=%s",
+					verb, name, arg.Type())
+			}
+			out.WriteString(fmt.Sprintf(spec+"t", boolean.Value))
+		case 'q':
+			str, ok := arg.(*object.String)
+			if !ok {
+				return "", newError("wrong argument for %%%c in `%s`, want=STRING got=%s",
+					verb, name, arg.Type())
+			}
+			out.WriteString(fmt.Sprintf(spec+"q", str.Value))
+		case 's', 'v':
+			out.WriteString(fmt.Sprintf(spec+"s", arg.Inspect()))
+		default:
+			return "", newError("unknown format verb %%%c in `%s`", verb, name)
+		}
+	}
+
+	if argIdx < len(args) {
+		return "", newError("too many arguments to `%s`, want=%d got=%d",
+			name, argIdx, len(args))
+	}
+
+	return out.String(), nil
+}
+
+func isFormatFlag(ch byte) bool {
+	return ch == '-' || ch == '+' || ch == '0' || ch == ' '
+}
+
+func isDigit(ch byte) bool {
+	return '0' <= ch && ch <= '9'
+}
@@ -0,0 +1,141 @@
+package eval
+
+import (
+	"monkey/ast"
+	"monkey/object"
+)
+
+// DefineMacros binds the macros of top-level `let name = macro(...) {...}`
+// statements in env and removes those statements from program.
+func DefineMacros(program *ast.Program, env *object.Environment) {
+	definitions := []int{}
+
+	for i, statement := range program.Statements {
+		if isMacroDefinition(statement) {
+			addMacro(statement, env)
+			definitions = append(definitions, i)
+		}
+	}
+
+	for i := len(definitions) - 1; i >= 0; i = i - 1 {
+		definitionIndex := definitions[i]
+		program.Statements = append(
+			program.Statements[:definitionIndex],
+			program.Statements[definitionIndex+1:]...,
+		)
+	}
+}
+
+func isMacroDefinition(node ast.Statement) bool {
+	letStatement, ok := node.(*ast.LetStatement)
+	if !ok || letStatement == nil || letStatement.Bind == nil {
+		return false
+	}
+
+	_, ok = letStatement.Value.(*ast.MacroLiteral)
+	return ok
+}
+
+func addMacro(stmt ast.Statement, env *object.Environment) {
+	letStatement, _ := stmt.(*ast.LetStatement)
+	macroLiteral, _ := letStatement.Value.(*ast.MacroLiteral)
+
+	macro := &object.Macro{
+		Parameters: macroLiteral.Parameters,
+		Env:        env,
+		Body:       macroLiteral.Body,
+	}
+
+	env.Set(letStatement.Bind.Value, macro)
+}
+
+// ExpandMacros replaces every call to a macro defined in env with the
+// code the macro returns. The macro receives its arguments unevaluated,
+// as quotes, and must return a quote.
+func ExpandMacros(program ast.Node, env *object.Environment) (ast.Node, *object.Error) {
+	var failure *object.Error
+	expanded := ast.Modify(program, func(node ast.Node) ast.Node {
+		if failure != nil {
+			return node
+		}
+		callExpression, ok := node.(*ast.CallExpression)
+		if !ok {
+			return node
+		}
+
+		macro, ok := isMacroCall(callExpression, env)
+		if !ok {
+			return node
+		}
+		if len(callExpression.Arguments) != len(macro.Parameters) {
+			failure = newError("wrong number of arguments, want=%d got=%d",
+				len(macro.Parameters), len(callExpression.Arguments))
+			return node
+		}
+
+		args := quoteArgs(callExpression)
+		evalEnv := extendMacroEnv(macro, args)
+
+		evaluated := unwrapReturnValue(Eval(macro.Body, evalEnv))
+		if isError(evaluated) {
+			failure = evaluated.(*object.Error)
+			return node
+		}
+		quote, ok := evaluated.(*object.Quote)
+		if !ok {
+			failure = newError("macro %s must return QUOTE, got %s",
+				callExpression.Function.String(), typeOf(evaluated))
+			return node
+		}
+
+		return quote.Node
+	})
+	return expanded, failure
+}
+
+func isMacroCall(exp *ast.CallExpression, env *object.Environment) (*object.Macro, bool) {
+	identifier, ok := exp.Function.(*ast.Identifier)
+	if !ok {
+		return nil, false
+	}
+
+	obj, ok := env.Get(identifier.Value)
+	if !ok {
+		return nil, false
+	}
+
+	macro, ok := obj.(*object.Macro)
+	if !ok {
+		return nil, false
+	}
+
+	return macro, true
+}
+
+func quoteArgs(exp *ast.CallExpression) []*object.Quote {
+	args := []*object.Quote{}
+
+	for _, a := range exp.Arguments {
+		args = append(args, &object.Quote{Node: a})
+	}
+
+	return args
+}
+
+func extendMacroEnv(macro *object.Macro, args []*object.Quote) *object.Environment {
+	extended := object.NewEnclosedEnvironment(macro.Env)
+
+	for paramIdx, param := range macro.Parameters {
+		extended.Set(param.Value, args[paramIdx])
+	}
+
+	return extended
+}
+
+// typeOf is obj's type, or NULL for the nil an empty macro body yields.
+func typeOf(obj object.Object) object.ObjectType {
+	if obj == nil {
+		return object.NULL_OBJ
+	}
+	return obj.Type()
+}
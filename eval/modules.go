@@ -0,0 +1,255 @@
+package eval
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ModuleLoader finds, evaluates and caches the files a program imports.
+// Each module is evaluated once, in its own environment, however many
+// times it is imported.
+type ModuleLoader struct {
+	// SearchPaths are tried in order for import paths that are neither
+	// absolute nor start with ./ or ../, after the importing file's
+	// directory.
+	SearchPaths []string
+	// Optimize runs Optimize on each program before it is evaluated.
+	Optimize bool
+
+	modules map[string]*object.Module
+	// loading holds the absolute paths of the files being evaluated,
+	// innermost last, to resolve relative imports and detect cycles.
+	loading []string
+}
+
+func NewModuleLoader(searchPaths ...string) *ModuleLoader {
+	return &ModuleLoader{
+		SearchPaths: searchPaths,
+		Optimize:    true,
+		modules:     map[string]*object.Module{},
+	}
+}
+
+// Modules is the loader used by import statements.
+var Modules = NewModuleLoader()
+
+// RunFile evaluates the program in path. Its imports are resolved
+// relative to its directory.
+func RunFile(path string) object.Object {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return newImportError("cannot read %s: %s", path, err)
+	}
+	program, errObj := parseModule(abs)
+	if errObj != nil {
+		return errObj
+	}
+
+	env := object.NewEnvironment()
+	if err := Check(program, env); err != nil {
+		return err
+	}
+	if Modules.Optimize {
+		Optimize(program)
+	}
+	Modules.loading = append(Modules.loading, abs)
+	defer Modules.pop()
+	return Eval(program, env)
+}
+
+// Import returns the module path refers to, evaluating it on first use.
+func (ml *ModuleLoader) Import(path string) object.Object {
+	resolved, ok := ml.resolve(path)
+	if !ok {
+		return newImportError("cannot find module %q", path)
+	}
+	if module, ok := ml.modules[resolved]; ok {
+		return module
+	}
+	for i, loading := range ml.loading {
+		if loading == resolved {
+			cycle := []string{}
+			for _, p := range append(ml.loading[i:], resolved) {
+				cycle = append(cycle, displayPath(p))
+			}
+			return newImportError("import cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+
+	program, errObj := parseModule(resolved)
+	if errObj != nil {
+		return errObj
+	}
+
+	ml.loading = append(ml.loading, resolved)
+	defer ml.pop()
+
+	env := object.NewEnvironment()
+	var result object.Object
+	if err := Check(program, env); err != nil {
+		result = err
+	} else {
+		if ml.Optimize {
+			Optimize(program)
+		}
+		result = Eval(program, env)
+	}
+	if isError(result) {
+		return withFrame(result.(*object.Error), "in module "+displayPath(resolved))
+	}
+
+	module := &object.Module{Path: displayPath(resolved), Exports: map[string]object.Object{}}
+	for _, stmt := range program.Statements {
+		export, ok := stmt.(*ast.ExportStatement)
+		if !ok {
+			continue
+		}
+		for _, name := range export.Statement.Names() {
+			module.Exports[name.Value], _ = env.Get(name.Value)
+		}
+	}
+	ml.modules[resolved] = module
+	return module
+}
+
+func (ml *ModuleLoader) pop() {
+	ml.loading = ml.loading[:len(ml.loading)-1]
+}
+
+// resolve turns an import path into the absolute path of an existing
+// file. Paths starting with ./ or ../ are relative to the importing file
+// only; other relative paths fall back to the search paths.
+func (ml *ModuleLoader) resolve(path string) (string, bool) {
+	dir := "."
+	if len(ml.loading) > 0 {
+		dir = filepath.Dir(ml.loading[len(ml.loading)-1])
+	}
+
+	var candidates []string
+	switch {
+	case filepath.IsAbs(path):
+		candidates = []string{path}
+	case strings.HasPrefix(path, "./") || strings.HasPrefix(path, "../"):
+		candidates = []string{filepath.Join(dir, path)}
+	default:
+		candidates = []string{filepath.Join(dir, path)}
+		for _, searchPath := range ml.SearchPaths {
+			candidates = append(candidates, filepath.Join(searchPath, path))
+		}
+	}
+
+	for _, candidate := range candidates {
+		info, err := os.Stat(candidate)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		abs, err := filepath.Abs(candidate)
+		if err != nil {
+			continue
+		}
+		return abs, true
+	}
+	return "", false
+}
+
+// parseModule parses the source in path, or loads the tree saved by
+// `monkey ast --json` when path ends in .json, and expands its macros.
+func parseModule(path string) (*ast.Program, *object.Error) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, newImportError("cannot read %s: %s", displayPath(path), err)
+	}
+
+	if filepath.Ext(path) == ".json" {
+		program, err := ast.UnmarshalProgram(source)
+		if err != nil {
+			return nil, newImportError("cannot load %s: %s", displayPath(path), err)
+		}
+		return expandModule(program)
+	}
+
+	p := parser.New(lexer.New(string(source)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, newSyntaxError("parse errors in %s: %s",
+			displayPath(path), strings.Join(p.Errors(), "; "))
+	}
+	return expandModule(program)
+}
+
+func expandModule(program *ast.Program) (*ast.Program, *object.Error) {
+	macroEnv := object.NewEnvironment()
+	DefineMacros(program, macroEnv)
+	expanded, errObj := ExpandMacros(program, macroEnv)
+	if errObj != nil {
+		return nil, errObj
+	}
+	return expanded.(*ast.Program), nil
+}
+
+// displayPath shortens path relative to the working directory for error
+// messages when it lives below it.
+func displayPath(path string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+	rel, err := filepath.Rel(wd, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return path
+	}
+	return rel
+}
+
+func newImportError(f string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(f, a...), Kind: "ImportError"}
+}
+
+func newSyntaxError(f string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(f, a...), Kind: "SyntaxError"}
+}
+
+func evalImportStatement(node *ast.ImportStatement, env *object.Environment) object.Object {
+	imported := Modules.Import(node.Path.Value)
+	if isError(imported) {
+		return imported
+	}
+	module := imported.(*object.Module)
+
+	if node.Alias != nil {
+		if isConstant(env, node.Alias) {
+			return newError("cannot reassign constant %s", node.Alias.Value)
+		}
+		declare(env, node.Alias, module, true)
+	}
+
+	for i, name := range node.Names {
+		val, ok := module.Exports[name.Value]
+		if !ok {
+			return newImportError("module %s has no export %s", module.Path, name.Value)
+		}
+		binding := node.Bindings[i]
+		if isConstant(env, binding) {
+			return newError("cannot reassign constant %s", binding.Value)
+		}
+		declare(env, binding, val, true)
+	}
+	return nil
+}
+
+func evalModuleIndexExpression(module, index object.Object) object.Object {
+	moduleObj := module.(*object.Module)
+	name := index.(*object.String).Value
+
+	val, ok := moduleObj.Exports[name]
+	if !ok {
+		return newError("module %s has no export %s", moduleObj.Path, name)
+	}
+	return val
+}
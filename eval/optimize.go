@@ -0,0 +1,318 @@
+package eval
+
+import (
+	"monkey/ast"
+	"monkey/object"
+	"monkey/token"
+	"strconv"
+)
+
+// Optimize simplifies program in place before it runs, without changing
+// what it does: operators on constants are folded, so 60 * 60 * 24
+// becomes 86400; an if whose condition is a constant loses the branch
+// that cannot run; and names bound to a literal with const are replaced
+// by the literal wherever that binding is visible and already made.
+// Operations that fail, such as 1 / 0, are left for the evaluator to
+// report when they run. Quoted code and macros are left alone.
+func Optimize(program *ast.Program) *ast.Program {
+	o := &optimizer{}
+	o.openScope(program.Statements)
+	o.statements(program.Statements)
+	o.closeScope()
+	return program
+}
+
+type optScope struct {
+	parent *optScope
+	// declared holds every name declared in the scope, wherever it is;
+	// consts holds the literals of those bound by a const seen so far.
+	declared map[string]bool
+	consts   map[string]ast.Expression
+}
+
+type optimizer struct {
+	scope *optScope
+}
+
+// openScope starts a scope declaring params and the names stmts bind.
+func (o *optimizer) openScope(stmts []ast.Statement, params ...*ast.Identifier) {
+	s := &optScope{parent: o.scope, declared: map[string]bool{}, consts: map[string]ast.Expression{}}
+	for _, param := range params {
+		s.declared[param.Value] = true
+	}
+	for _, stmt := range stmts {
+		for _, name := range declaredNames(stmt) {
+			s.declared[name.Value] = true
+		}
+	}
+	o.scope = s
+}
+
+func (o *optimizer) closeScope() {
+	o.scope = o.scope.parent
+}
+
+func declaredNames(stmt ast.Statement) []*ast.Identifier {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		return stmt.Names()
+	case *ast.ExportStatement:
+		if stmt.Statement != nil {
+			return stmt.Statement.Names()
+		}
+	case *ast.ImportStatement:
+		names := append([]*ast.Identifier{}, stmt.Bindings...)
+		if stmt.Alias != nil {
+			names = append(names, stmt.Alias)
+		}
+		return names
+	}
+	return nil
+}
+
+// constant returns the literal name stands for at this point, if the
+// nearest scope declaring it bound it with const.
+func (o *optimizer) constant(name *ast.Identifier) (ast.Expression, bool) {
+	for s := o.scope; s != nil; s = s.parent {
+		if s.declared[name.Value] {
+			literal, ok := s.consts[name.Value]
+			return literal, ok
+		}
+	}
+	return nil, false
+}
+
+func (o *optimizer) statements(stmts []ast.Statement) {
+	for _, stmt := range stmts {
+		o.statement(stmt)
+	}
+}
+
+func (o *optimizer) statement(stmt ast.Statement) {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		o.let(stmt)
+	case *ast.ExportStatement:
+		o.let(stmt.Statement)
+	case *ast.ReturnStatement:
+		stmt.Value = o.expression(stmt.Value)
+	case *ast.ThrowStatement:
+		stmt.Value = o.expression(stmt.Value)
+	case *ast.DeferStatement:
+		stmt.Call = o.expression(stmt.Call)
+	case *ast.ExpressionStatement:
+		stmt.Expression = o.expression(stmt.Expression)
+	case *ast.BlockStatement:
+		o.statements(stmt.Statements)
+	}
+}
+
+func (o *optimizer) let(stmt *ast.LetStatement) {
+	stmt.Value = o.expression(stmt.Value)
+	if stmt.Pattern != nil {
+		o.pattern(stmt.Pattern)
+		return
+	}
+	if val, ok := constantValue(stmt.Value); ok && stmt.IsConst() && allows(stmt.Bind.Type, val.Type()) {
+		o.scope.consts[stmt.Bind.Value] = stmt.Value
+	}
+}
+
+func (o *optimizer) pattern(pattern ast.Pattern) {
+	switch pattern := pattern.(type) {
+	case *ast.BindingPattern:
+		pattern.Default = o.expression(pattern.Default)
+	case *ast.ArrayPattern:
+		for _, el := range pattern.Elements {
+			o.pattern(el)
+		}
+	case *ast.HashPattern:
+		for _, value := range pattern.Values {
+			o.pattern(value)
+		}
+	}
+}
+
+// block optimizes a block that runs in a scope of its own.
+func (o *optimizer) block(block *ast.BlockStatement, params ...*ast.Identifier) {
+	if block == nil {
+		return
+	}
+	o.openScope(block.Statements, params...)
+	o.statements(block.Statements)
+	o.closeScope()
+}
+
+func (o *optimizer) expression(exp ast.Expression) ast.Expression {
+	switch exp := exp.(type) {
+	case *ast.Identifier:
+		if literal, ok := o.constant(exp); ok {
+			val, _ := constantValue(literal)
+			return literalNode(val, exp.Token)
+		}
+	case *ast.PrefixExpression:
+		exp.On = o.expression(exp.On)
+		if on, ok := constantValue(exp.On); ok {
+			return fold(exp, evalPrefixExpression(exp.Operator, on))
+		}
+	case *ast.InfixExpression:
+		exp.Left = o.expression(exp.Left)
+		exp.Right = o.expression(exp.Right)
+		left, ok := constantValue(exp.Left)
+		if ok && exp.Operator == "??" {
+			// Literals are never null.
+			return exp.Left
+		}
+		if right, rok := constantValue(exp.Right); ok && rok {
+			return fold(exp, evalInfixExpression(exp.Operator, left, right))
+		}
+	case *ast.IfExpression:
+		return o.ifExpression(exp)
+	case *ast.TryExpression:
+		o.block(exp.Block)
+		if exp.Catch != nil {
+			var params []*ast.Identifier
+			if exp.Param != nil {
+				params = append(params, exp.Param)
+			}
+			o.block(exp.Catch, params...)
+		}
+		o.block(exp.Finally)
+	case *ast.MatchExpression:
+		exp.Subject = o.expression(exp.Subject)
+		for _, arm := range exp.Arms {
+			o.arm(arm)
+		}
+	case *ast.FunctionLiteral:
+		o.block(exp.Body, exp.Parameters...)
+	case *ast.CallExpression:
+		if isQuoteCall(exp) {
+			return exp
+		}
+		exp.Function = o.expression(exp.Function)
+		o.expressions(exp.Arguments)
+	case *ast.InterpolatedString:
+		o.expressions(exp.Parts)
+	case *ast.ArrayLiteral:
+		o.expressions(exp.Elements)
+	case *ast.HashLiteral:
+		pairs := map[ast.Expression]ast.Expression{}
+		order := []ast.Expression{}
+		for _, key := range exp.Keys() {
+			value := o.expression(exp.Pairs[key])
+			key = o.expression(key)
+			pairs[key] = value
+			order = append(order, key)
+		}
+		exp.Pairs, exp.Order = pairs, order
+	case *ast.IndexExpression:
+		exp.Left = o.expression(exp.Left)
+		exp.Index = o.expression(exp.Index)
+	case *ast.SliceExpression:
+		exp.Left = o.expression(exp.Left)
+		exp.Start = o.expression(exp.Start)
+		exp.End = o.expression(exp.End)
+	case *ast.RangeExpression:
+		exp.Start = o.expression(exp.Start)
+		exp.End = o.expression(exp.End)
+	}
+	return exp
+}
+
+func (o *optimizer) expressions(exps []ast.Expression) {
+	for i, exp := range exps {
+		exps[i] = o.expression(exp)
+	}
+}
+
+// arm optimizes a match arm, which runs in a scope holding the names its
+// pattern binds.
+func (o *optimizer) arm(arm *ast.MatchArm) {
+	var stmts []ast.Statement
+	block, isBlock := arm.Body.(*ast.BlockStatement)
+	if isBlock {
+		stmts = block.Statements
+	}
+	o.openScope(stmts, ast.PatternNames(arm.Pattern)...)
+	o.pattern(arm.Pattern)
+	arm.Guard = o.expression(arm.Guard)
+	if isBlock {
+		o.statements(block.Statements)
+	} else if body, ok := arm.Body.(ast.Expression); ok {
+		arm.Body = o.expression(body)
+	}
+	o.closeScope()
+}
+
+// ifExpression drops the branch a constant condition rules out. A branch
+// holding a single expression takes the place of the if; others keep
+// their own scope in an if that always runs them.
+func (o *optimizer) ifExpression(exp *ast.IfExpression) ast.Expression {
+	exp.Condition = o.expression(exp.Condition)
+	o.block(exp.Consequence)
+	o.block(exp.Alternative)
+
+	cond, ok := constantValue(exp.Condition)
+	if !ok {
+		return exp
+	}
+	taken := exp.Consequence
+	if !isTruthy(cond) {
+		taken = exp.Alternative
+	}
+	if taken == nil {
+		exp.Consequence = &ast.BlockStatement{Token: exp.Consequence.Token, Rbrace: exp.Consequence.Rbrace}
+		return exp
+	}
+	if len(taken.Statements) == 1 {
+		if stmt, ok := taken.Statements[0].(*ast.ExpressionStatement); ok {
+			return stmt.Expression
+		}
+	}
+	always := &ast.Boolean{Token: token.Token{Type: token.TRUE, Literal: "true"}, Value: true}
+	return &ast.IfExpression{Token: exp.Token, Condition: always, Consequence: taken}
+}
+
+// constantValue returns the value of a literal the optimizer can fold.
+func constantValue(exp ast.Expression) (object.Object, bool) {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		return &object.Integer{Value: exp.Value}, true
+	case *ast.StringLiteral:
+		return &object.String{Value: exp.Value}, true
+	case *ast.Boolean:
+		return nativeBoolToBooleanObject(exp.Value), true
+	default:
+		return nil, false
+	}
+}
+
+// fold replaces exp with the literal for val, its value, unless
+// evaluating it failed.
+func fold(exp ast.Expression, val object.Object) ast.Expression {
+	if literal := literalNode(val, nodeToken(exp)); literal != nil {
+		return literal
+	}
+	return exp
+}
+
+// literalNode makes a literal for val placed at pos, or returns nil if val
+// has no literal form.
+func literalNode(val object.Object, pos token.Token) ast.Expression {
+	at := func(typ token.TokenType, literal string) token.Token {
+		return token.Token{Type: typ, Literal: literal, Line: pos.Line, Column: pos.Column}
+	}
+	switch val := val.(type) {
+	case *object.Integer:
+		return &ast.IntegerLiteral{Token: at(token.INT, strconv.FormatInt(val.Value, 10)), Value: val.Value}
+	case *object.String:
+		return &ast.StringLiteral{Token: at(token.STRING, val.Value), Value: val.Value}
+	case *object.Boolean:
+		if val.Value {
+			return &ast.Boolean{Token: at(token.TRUE, "true"), Value: true}
+		}
+		return &ast.Boolean{Token: at(token.FALSE, "false"), Value: false}
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,224 @@
+package eval
+
+import (
+	"monkey/ast"
+	"monkey/object"
+	"strconv"
+)
+
+func evalMatchExpression(node *ast.MatchExpression, env *object.Environment) object.Object {
+	subject := Eval(node.Subject, env)
+	if isError(subject) {
+		return subject
+	}
+
+	for _, arm := range node.Arms {
+		var bindings []patternBinding
+		if !matchPattern(arm.Pattern, subject, &bindings) {
+			continue
+		}
+
+		armEnv := object.NewEnclosedEnvironment(env)
+		for _, b := range bindings {
+			declare(armEnv, b.name, b.value, false)
+		}
+
+		if arm.Guard != nil {
+			guard := Eval(arm.Guard, armEnv)
+			if isError(guard) {
+				return guard
+			}
+			if !isTruthy(guard) {
+				continue
+			}
+		}
+
+		result := Eval(arm.Body, armEnv)
+		if result == nil {
+			return NULL
+		}
+		return result
+	}
+
+	return NULL
+}
+
+// patternBinding is a name bound by a successful match.
+type patternBinding struct {
+	name  *ast.Identifier
+	value object.Object
+}
+
+// matchPattern reports whether val has the shape described by pattern,
+// recording the names it binds in bindings in order. bindings may be
+// partly filled when the match fails.
+func matchPattern(pattern ast.Pattern, val object.Object, bindings *[]patternBinding) bool {
+	switch pattern := pattern.(type) {
+	case *ast.WildcardPattern:
+		return true
+	case *ast.BindingPattern:
+		*bindings = append(*bindings, patternBinding{pattern.Name, val})
+		return true
+	case *ast.LiteralPattern:
+		return object.Equal(literalValue(pattern.Value), val)
+	case *ast.ArrayPattern:
+		arr, ok := val.(*object.Array)
+		if !ok {
+			return false
+		}
+		if len(arr.Elements) < len(pattern.Elements) {
+			return false
+		}
+		if pattern.Rest == nil && len(arr.Elements) != len(pattern.Elements) {
+			return false
+		}
+		for i, el := range pattern.Elements {
+			if !matchPattern(el, arr.Elements[i], bindings) {
+				return false
+			}
+		}
+		if pattern.Rest != nil {
+			rest := make([]object.Object, len(arr.Elements)-len(pattern.Elements))
+			copy(rest, arr.Elements[len(pattern.Elements):])
+			*bindings = append(*bindings, patternBinding{pattern.Rest, &object.Array{Elements: rest}})
+		}
+		return true
+	case *ast.HashPattern:
+		hash, ok := val.(*object.Hash)
+		if !ok {
+			return false
+		}
+		for i, keyNode := range pattern.Keys {
+			key, _ := object.AsHashable(literalValue(keyNode))
+			pair, ok := hash.Get(key)
+			if !ok || !matchPattern(pattern.Values[i], pair.Value, bindings) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// literalValue converts the literal expressions allowed in patterns to
+// objects without needing an environment.
+func literalValue(node ast.Expression) object.Object {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		return &object.Integer{Value: node.Value}
+	case *ast.StringLiteral:
+		return &object.String{Value: node.Value}
+	case *ast.Boolean:
+		return nativeBoolToBooleanObject(node.Value)
+	case *ast.PrefixExpression:
+		return evalPrefixExpression(node.Operator, literalValue(node.On))
+	default:
+		return NULL
+	}
+}
+
+// bindPattern destructures val into env following pattern, as in
+// `let [a, {b}] = xs;`. Unlike matchPattern it reports a shape mismatch
+// as an error, and bindings with defaults may be missing from val. With
+// constant set the names are bound as by `const`.
+func bindPattern(pattern ast.Pattern, val object.Object, env *object.Environment, constant bool) object.Object {
+	switch pattern := pattern.(type) {
+	case *ast.WildcardPattern:
+		return nil
+	case *ast.BindingPattern:
+		declare(env, pattern.Name, val, constant)
+		return nil
+	case *ast.LiteralPattern:
+		if !object.Equal(literalValue(pattern.Value), val) {
+			return newError("pattern %s does not match %s", pattern.String(), val.Inspect())
+		}
+		return nil
+	case *ast.ArrayPattern:
+		arr, ok := val.(*object.Array)
+		if !ok {
+			return newError("cannot destructure %s with array pattern %s",
+				val.Type(), pattern.String())
+		}
+		if pattern.Rest == nil && len(arr.Elements) > len(pattern.Elements) {
+			return newError("array pattern %s expects %d elements, got %d",
+				pattern.String(), len(pattern.Elements), len(arr.Elements))
+		}
+		for i, el := range pattern.Elements {
+			var item object.Object
+			if i < len(arr.Elements) {
+				item = arr.Elements[i]
+			}
+			missing := func() object.Object {
+				return newError("array pattern %s expects %d elements, got %d",
+					pattern.String(), len(pattern.Elements), len(arr.Elements))
+			}
+			if err := bindElement(el, item, env, constant, missing); err != nil {
+				return err
+			}
+		}
+		if pattern.Rest != nil {
+			rest := []object.Object{}
+			if len(arr.Elements) > len(pattern.Elements) {
+				rest = append(rest, arr.Elements[len(pattern.Elements):]...)
+			}
+			declare(env, pattern.Rest, &object.Array{Elements: rest}, constant)
+		}
+		return nil
+	case *ast.HashPattern:
+		hash, ok := val.(*object.Hash)
+		if !ok {
+			return newError("cannot destructure %s with hash pattern %s",
+				val.Type(), pattern.String())
+		}
+		for i, keyNode := range pattern.Keys {
+			key, _ := object.AsHashable(literalValue(keyNode))
+			var item object.Object
+			if pair, ok := hash.Get(key); ok {
+				item = pair.Value
+			}
+			missing := func() object.Object {
+				return newError("hash pattern %s is missing key %s",
+					pattern.String(), quotedKey(key))
+			}
+			if err := bindElement(pattern.Values[i], item, env, constant, missing); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return newError("unsupported pattern %s", pattern.String())
+	}
+}
+
+// bindElement binds one element of an array or hash pattern. item is nil
+// when the element or key is missing from the destructured value, which is
+// reported with missing unless the pattern supplies a default.
+func bindElement(
+	pattern ast.Pattern,
+	item object.Object,
+	env *object.Environment,
+	constant bool,
+	missing func() object.Object,
+) object.Object {
+	if item == nil {
+		binding, ok := pattern.(*ast.BindingPattern)
+		if !ok || binding.Default == nil {
+			return missing()
+		}
+		item = Eval(binding.Default, env)
+		if isError(item) {
+			return item
+		}
+	}
+	return bindPattern(pattern, item, env, constant)
+}
+
+// quotedKey shows a hash key the way it is written in patterns, so string
+// keys are quoted.
+func quotedKey(key object.Object) string {
+	if s, ok := key.(*object.String); ok {
+		return strconv.Quote(s.Value)
+	}
+	return key.Inspect()
+}
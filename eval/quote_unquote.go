@@ -0,0 +1,115 @@
+package eval
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/object"
+	"monkey/token"
+)
+
+// quote returns node unevaluated, except that each `unquote(expr)` inside
+// it is replaced by the AST of expr's value.
+func quote(node ast.Node, env *object.Environment) object.Object {
+	node, err := evalUnquoteCalls(node, env)
+	if err != nil {
+		return err
+	}
+	return &object.Quote{Node: node}
+}
+
+func evalUnquoteCalls(quoted ast.Node, env *object.Environment) (ast.Node, *object.Error) {
+	var failure *object.Error
+	node := ast.Modify(quoted, func(node ast.Node) ast.Node {
+		if failure != nil || !isUnquoteCall(node) {
+			return node
+		}
+
+		call := node.(*ast.CallExpression)
+		if len(call.Arguments) != 1 {
+			failure = newError("wrong number of arguments, want=1 got=%d", len(call.Arguments))
+			return node
+		}
+
+		unquoted := Eval(call.Arguments[0], env)
+		if isError(unquoted) {
+			failure = unquoted.(*object.Error)
+			return node
+		}
+		converted, ok := convertObjectToASTNode(unquoted)
+		if !ok {
+			failure = newError("cannot unquote %s", unquoted.Type())
+			return node
+		}
+		return converted
+	})
+	return node, failure
+}
+
+func isQuoteCall(node ast.Node) bool {
+	call, ok := node.(*ast.CallExpression)
+	if !ok {
+		return false
+	}
+	return call.Function.TokenLiteral() == "quote"
+}
+
+func isUnquoteCall(node ast.Node) bool {
+	call, ok := node.(*ast.CallExpression)
+	if !ok {
+		return false
+	}
+	return call.Function.TokenLiteral() == "unquote"
+}
+
+// convertObjectToASTNode builds a literal that evaluates to obj. Quotes
+// are spliced in as the code they hold.
+func convertObjectToASTNode(obj object.Object) (ast.Node, bool) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		t := token.Token{Type: token.INT, Literal: fmt.Sprintf("%d", obj.Value)}
+		return &ast.IntegerLiteral{Token: t, Value: obj.Value}, true
+	case *object.Boolean:
+		var t token.Token
+		if obj.Value {
+			t = token.Token{Type: token.TRUE, Literal: "true"}
+		} else {
+			t = token.Token{Type: token.FALSE, Literal: "false"}
+		}
+		return &ast.Boolean{Token: t, Value: obj.Value}, true
+	case *object.String:
+		t := token.Token{Type: token.STRING, Literal: obj.Value}
+		return &ast.StringLiteral{Token: t, Value: obj.Value}, true
+	case *object.Array:
+		array := &ast.ArrayLiteral{Token: token.Token{Type: token.LBRACKET, Literal: "["}}
+		for _, el := range obj.Elements {
+			node, ok := convertObjectToASTNode(el)
+			if !ok {
+				return nil, false
+			}
+			array.Elements = append(array.Elements, node.(ast.Expression))
+		}
+		return array, true
+	case *object.Hash:
+		hash := &ast.HashLiteral{
+			Token: token.Token{Type: token.LCURLY, Literal: "{"},
+			Pairs: make(map[ast.Expression]ast.Expression),
+		}
+		for _, pair := range obj.Entries() {
+			key, ok := convertObjectToASTNode(pair.Key)
+			if !ok {
+				return nil, false
+			}
+			value, ok := convertObjectToASTNode(pair.Value)
+			if !ok {
+				return nil, false
+			}
+			hash.Pairs[key.(ast.Expression)] = value.(ast.Expression)
+			hash.Order = append(hash.Order, key.(ast.Expression))
+		}
+		return hash, true
+	case *object.Quote:
+		return obj.Node, true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,422 @@
+package eval
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/object"
+	"sort"
+)
+
+// Resolve binds each variable reference in program to the slot that holds
+// the variable, counting scopes the way the evaluator creates
+// environments, so lookups at run time index slices instead of searching
+// by name. env is the environment program will run in; the names it
+// already holds, as in the REPL, keep their slots.
+//
+// Function bodies are resolved when the scope enclosing the function ends,
+// so they may refer to names declared after them. Macro bodies and quoted
+// code are left unresolved and looked up by name when they run, and so
+// are the identifiers bound nowhere, which Resolve returns in source
+// order.
+func Resolve(program *ast.Program, env *object.Environment) []*ast.Identifier {
+	r := &resolver{seen: map[*ast.Identifier]bool{}}
+	r.scope = newResolverScope(nil)
+	for slot, name := range env.Names() {
+		if name != "" {
+			r.scope.slots[name] = slot
+		}
+	}
+	r.scope.size = len(env.Names())
+
+	r.statements(program.Statements)
+	r.closeScope()
+	return r.sortedUndefined()
+}
+
+// Definitions resolves program on its own and returns, for each
+// identifier that refers to a variable, the identifier that declared it;
+// declarations map to themselves. Builtins and names bound nowhere are
+// left out. It is for tools that need to know where a name comes from
+// rather than its slot.
+func Definitions(program *ast.Program) map[*ast.Identifier]*ast.Identifier {
+	return Analyze(program).Definitions
+}
+
+// Analysis is what resolving a program on its own finds out about the
+// names in it.
+type Analysis struct {
+	// Definitions is what Definitions returns.
+	Definitions map[*ast.Identifier]*ast.Identifier
+	// Declarations lists the declarations in the order they were resolved.
+	Declarations []Declaration
+	// Undefined holds the identifiers bound nowhere, in source order.
+	Undefined []*ast.Identifier
+}
+
+// Declaration is a name being declared. Hides is the declaration of the
+// same name in an enclosing scope that it hides, and Replaces the one in
+// its own scope that it rebinds; at most one of them is set.
+type Declaration struct {
+	Name     *ast.Identifier
+	Hides    *ast.Identifier
+	Replaces *ast.Identifier
+}
+
+// Analyze resolves program on its own, as Definitions does, and reports
+// its declarations and the names bound nowhere along with the
+// definitions.
+func Analyze(program *ast.Program) *Analysis {
+	r := &resolver{seen: map[*ast.Identifier]bool{}}
+	r.analysis = &Analysis{Definitions: map[*ast.Identifier]*ast.Identifier{}}
+	r.scope = newResolverScope(nil)
+	r.statements(program.Statements)
+	r.closeScope()
+	r.analysis.Undefined = r.sortedUndefined()
+	return r.analysis
+}
+
+// Check resolves and type checks program for env before it runs. It
+// reports the first identifier that is bound nowhere or, failing that, the
+// first fatal type error; the others are left for lint to report and for
+// the evaluator to raise if they run.
+func Check(program *ast.Program, env *object.Environment) *object.Error {
+	return check(program, env, nil)
+}
+
+// CheckLine is Check for a line typed at the REPL, where a function may
+// call one that a later line defines. The names bound nowhere inside
+// function bodies are not reported; they are looked up by name when the
+// function runs.
+func CheckLine(program *ast.Program, env *object.Environment) *object.Error {
+	inFunctions := map[*ast.Identifier]bool{}
+	ast.Inspect(program, func(node ast.Node) bool {
+		fn, ok := node.(*ast.FunctionLiteral)
+		if !ok {
+			return true
+		}
+		ast.Inspect(fn.Body, func(node ast.Node) bool {
+			if ident, ok := node.(*ast.Identifier); ok {
+				inFunctions[ident] = true
+			}
+			return true
+		})
+		return false
+	})
+	return check(program, env, inFunctions)
+}
+
+// check is Check, leaving out the undefined identifiers in skip.
+func check(program *ast.Program, env *object.Environment, skip map[*ast.Identifier]bool) *object.Error {
+	for _, ident := range Resolve(program, env) {
+		if skip[ident] {
+			continue
+		}
+		err := newError("identifier not found: %s", ident.Value)
+		err.Stack = append(err.Stack, fmt.Sprintf("at line %d, column %d", ident.Token.Line, ident.Token.Column))
+		return err
+	}
+	for _, typeErr := range TypeCheck(program, env) {
+		if !typeErr.Fatal {
+			continue
+		}
+		err := newTypeError("%s", typeErr.Message)
+		err.Stack = append(err.Stack, fmt.Sprintf("at line %d, column %d", typeErr.Line, typeErr.Column))
+		return err
+	}
+	return nil
+}
+
+type resolverScope struct {
+	parent *resolverScope
+	slots  map[string]int
+	size   int
+	// decls holds the identifier that last declared each name.
+	decls map[string]*ast.Identifier
+	// functions are the bodies of function literals created in this scope,
+	// resolved when it closes.
+	functions []func()
+}
+
+func newResolverScope(parent *resolverScope) *resolverScope {
+	return &resolverScope{parent: parent, slots: map[string]int{}, decls: map[string]*ast.Identifier{}}
+}
+
+type resolver struct {
+	scope     *resolverScope
+	undefined []*ast.Identifier
+	// seen holds the identifiers resolved so far. Macro expansion can put
+	// one node in several places; if they disagree it is left to be looked
+	// up by name.
+	seen map[*ast.Identifier]bool
+	// analysis, when not nil, collects what Analyze returns.
+	analysis *Analysis
+}
+
+func (r *resolver) sortedUndefined() []*ast.Identifier {
+	sort.SliceStable(r.undefined, func(i, j int) bool {
+		a, b := r.undefined[i].Token, r.undefined[j].Token
+		return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+	})
+	return r.undefined
+}
+
+func (r *resolver) openScope() {
+	r.scope = newResolverScope(r.scope)
+}
+
+func (r *resolver) closeScope() {
+	s := r.scope
+	for len(s.functions) > 0 {
+		fn := s.functions[0]
+		s.functions = s.functions[1:]
+		fn()
+	}
+	r.scope = s.parent
+}
+
+func (r *resolver) bind(ident *ast.Identifier, depth, slot int) {
+	if r.seen[ident] && (!ident.Resolved || ident.Depth != depth || ident.Slot != slot) {
+		ident.Resolved = false
+		return
+	}
+	r.seen[ident] = true
+	ident.Resolved, ident.Depth, ident.Slot = true, depth, slot
+}
+
+// declare gives name a slot in the current scope; declaring it again in
+// the same scope reuses the slot, as rebinding does at run time.
+func (r *resolver) declare(name *ast.Identifier) {
+	slot, ok := r.scope.slots[name.Value]
+	r.record(name, ok)
+	if !ok {
+		slot = r.scope.size
+		r.scope.slots[name.Value] = slot
+		r.scope.size++
+	}
+	r.scope.decls[name.Value] = name
+	r.bind(name, 0, slot)
+	r.define(name, name)
+}
+
+func (r *resolver) define(ident, decl *ast.Identifier) {
+	if r.analysis != nil && decl != nil {
+		r.analysis.Definitions[ident] = decl
+	}
+}
+
+// record adds name to the analysis, with the declaration it replaces if
+// rebinding is set, or else the one in an enclosing scope it hides.
+func (r *resolver) record(name *ast.Identifier, rebinding bool) {
+	if r.analysis == nil {
+		return
+	}
+	decl := Declaration{Name: name}
+	if rebinding {
+		decl.Replaces = r.scope.decls[name.Value]
+	} else {
+		for s := r.scope.parent; s != nil; s = s.parent {
+			if _, ok := s.slots[name.Value]; ok {
+				decl.Hides = s.decls[name.Value]
+				break
+			}
+		}
+	}
+	r.analysis.Declarations = append(r.analysis.Declarations, decl)
+}
+
+func (r *resolver) use(ident *ast.Identifier) {
+	depth := 0
+	for s := r.scope; s != nil; s = s.parent {
+		if slot, ok := s.slots[ident.Value]; ok {
+			r.bind(ident, depth, slot)
+			r.define(ident, s.decls[ident.Value])
+			return
+		}
+		depth++
+	}
+	if IsBuiltin(ident.Value) {
+		r.bind(ident, -1, 0)
+		return
+	}
+	ident.Resolved = false
+	r.undefined = append(r.undefined, ident)
+}
+
+func (r *resolver) statements(stmts []ast.Statement) {
+	for _, stmt := range stmts {
+		r.statement(stmt)
+	}
+}
+
+func (r *resolver) statement(stmt ast.Statement) {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		r.let(stmt)
+	case *ast.ExportStatement:
+		r.let(stmt.Statement)
+	case *ast.ImportStatement:
+		if stmt.Alias != nil {
+			r.declare(stmt.Alias)
+		}
+		for _, name := range stmt.Bindings {
+			r.declare(name)
+		}
+	case *ast.ReturnStatement:
+		r.expression(stmt.Value)
+	case *ast.ThrowStatement:
+		r.expression(stmt.Value)
+	case *ast.DeferStatement:
+		r.expression(stmt.Call)
+	case *ast.ExpressionStatement:
+		r.expression(stmt.Expression)
+	case *ast.BlockStatement:
+		r.statements(stmt.Statements)
+	}
+}
+
+func (r *resolver) let(stmt *ast.LetStatement) {
+	r.expression(stmt.Value)
+	if stmt.Pattern != nil {
+		r.pattern(stmt.Pattern)
+	} else {
+		r.declare(stmt.Bind)
+	}
+}
+
+// pattern declares the names pattern binds in order, resolving each
+// default before the name it belongs to.
+func (r *resolver) pattern(pattern ast.Pattern) {
+	switch pattern := pattern.(type) {
+	case *ast.BindingPattern:
+		r.expression(pattern.Default)
+		r.declare(pattern.Name)
+	case *ast.ArrayPattern:
+		for _, el := range pattern.Elements {
+			r.pattern(el)
+		}
+		if pattern.Rest != nil {
+			r.declare(pattern.Rest)
+		}
+	case *ast.HashPattern:
+		for _, value := range pattern.Values {
+			r.pattern(value)
+		}
+	}
+}
+
+// block resolves a block that runs in an environment of its own.
+func (r *resolver) block(block *ast.BlockStatement) {
+	if block == nil {
+		return
+	}
+	r.openScope()
+	r.statements(block.Statements)
+	r.closeScope()
+}
+
+func (r *resolver) expression(exp ast.Expression) {
+	switch exp := exp.(type) {
+	case *ast.Identifier:
+		r.use(exp)
+	case *ast.InterpolatedString:
+		r.expressions(exp.Parts)
+	case *ast.PrefixExpression:
+		r.expression(exp.On)
+	case *ast.InfixExpression:
+		r.expression(exp.Left)
+		r.expression(exp.Right)
+	case *ast.RangeExpression:
+		r.expression(exp.Start)
+		r.expression(exp.End)
+	case *ast.IfExpression:
+		r.expression(exp.Condition)
+		r.block(exp.Consequence)
+		r.block(exp.Alternative)
+	case *ast.TryExpression:
+		r.block(exp.Block)
+		if exp.Catch != nil {
+			r.openScope()
+			if exp.Param != nil {
+				r.declare(exp.Param)
+			}
+			r.statements(exp.Catch.Statements)
+			r.closeScope()
+		}
+		r.block(exp.Finally)
+	case *ast.MatchExpression:
+		r.expression(exp.Subject)
+		for _, arm := range exp.Arms {
+			r.openScope()
+			r.pattern(arm.Pattern)
+			r.expression(arm.Guard)
+			if block, ok := arm.Body.(*ast.BlockStatement); ok {
+				r.statements(block.Statements)
+			} else if body, ok := arm.Body.(ast.Expression); ok {
+				r.expression(body)
+			}
+			r.closeScope()
+		}
+	case *ast.FunctionLiteral:
+		r.function(exp)
+	case *ast.CallExpression:
+		if isQuoteCall(exp) {
+			r.unquoted(exp.Arguments)
+			return
+		}
+		r.expression(exp.Function)
+		r.expressions(exp.Arguments)
+	case *ast.ArrayLiteral:
+		r.expressions(exp.Elements)
+	case *ast.HashLiteral:
+		for _, key := range exp.Keys() {
+			r.expression(key)
+			r.expression(exp.Pairs[key])
+		}
+	case *ast.IndexExpression:
+		r.expression(exp.Left)
+		r.expression(exp.Index)
+	case *ast.SliceExpression:
+		r.expression(exp.Left)
+		r.expression(exp.Start)
+		r.expression(exp.End)
+	}
+}
+
+func (r *resolver) expressions(exps []ast.Expression) {
+	for _, exp := range exps {
+		r.expression(exp)
+	}
+}
+
+// function queues fn's body to be resolved in a scope of its own, holding
+// the parameters and the body's declarations, once the current scope is
+// complete.
+func (r *resolver) function(fn *ast.FunctionLiteral) {
+	enclosing := r.scope
+	enclosing.functions = append(enclosing.functions, func() {
+		saved := r.scope
+		r.scope = newResolverScope(enclosing)
+		for _, param := range fn.Parameters {
+			r.declare(param)
+		}
+		if fn.Body != nil {
+			r.statements(fn.Body.Statements)
+		}
+		r.closeScope()
+		r.scope = saved
+	})
+}
+
+// unquoted resolves the arguments of the unquote calls inside quoted
+// code, which are evaluated where the quote is.
+func (r *resolver) unquoted(quoted []ast.Expression) {
+	for _, exp := range quoted {
+		ast.Inspect(exp, func(node ast.Node) bool {
+			if isUnquoteCall(node) {
+				r.expressions(node.(*ast.CallExpression).Arguments)
+				return false
+			}
+			return true
+		})
+	}
+}
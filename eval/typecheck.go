@@ -0,0 +1,832 @@
+package eval
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/object"
+	"monkey/token"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// TypeError is an operation TypeCheck found to be bound to fail, at the
+// 1-based line and column of its token.
+type TypeError struct {
+	Line    int
+	Column  int
+	Message string
+	// Fatal is set when the program should not run at all: the error
+	// breaks an annotation, or is in code that runs whenever the program
+	// does, and it is outside any try expression that could catch it.
+	Fatal bool
+}
+
+func (e TypeError) String() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Message)
+}
+
+// TypeCheck reports, in source order, the operations in program that
+// would fail with a type error when they run. Types are inferred from
+// literals, builtins, operators and annotations, and from the values
+// already in env. Where a type can only be known at run time the check is
+// left to the evaluator, which enforces annotations as values cross them;
+// unannotated code is only reported when it is wrong whatever the values.
+//
+// As in Resolve, function bodies are checked last; inside them the
+// variables of enclosing scopes have a type only if every binding of the
+// name in its scope agrees on it.
+func TypeCheck(program *ast.Program, env *object.Environment) []TypeError {
+	c := &checker{names: map[*ast.FunctionLiteral]string{}}
+	c.scope = newTypeScope(nil, false)
+	for slot, name := range env.Names() {
+		if name != "" {
+			val, _ := env.GetAt(slot)
+			c.scope.declare(name, typeOfValue(val))
+		}
+	}
+
+	c.statements(program.Statements)
+	for len(c.functions) > 0 {
+		fn := c.functions[0]
+		c.functions = c.functions[1:]
+		fn()
+	}
+
+	sort.SliceStable(c.errors, func(i, j int) bool {
+		a, b := c.errors[i], c.errors[j]
+		return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+	})
+	return c.errors
+}
+
+// staticType is what the checker knows about a value before it exists:
+// its object type, or "" when that is only known at run time. A function
+// whose literal is in view keeps it, and a builtin its name, so calls to
+// them can be checked.
+type staticType struct {
+	kind object.ObjectType
+	fn   *ast.FunctionLiteral
+	name string
+}
+
+var unknownType = staticType{}
+
+func knownType(kind object.ObjectType) staticType {
+	return staticType{kind: kind}
+}
+
+func typeOfValue(val object.Object) staticType {
+	switch val := val.(type) {
+	case *object.Function:
+		literal := &ast.FunctionLiteral{Parameters: val.Parameters, ReturnType: val.ReturnType}
+		return staticType{kind: object.FUNCTION_OBJ, fn: literal, name: val.Name}
+	default:
+		return knownType(val.Type())
+	}
+}
+
+// join is the type known of a value that has type a or type b.
+func join(a, b staticType) staticType {
+	switch {
+	case a == b:
+		return a
+	case a.kind == b.kind:
+		return knownType(a.kind)
+	default:
+		return unknownType
+	}
+}
+
+// annotationTypes lists the object types each annotation allows; `any`
+// allows every type.
+var annotationTypes = map[string][]object.ObjectType{
+	"int":    {object.INTEGER_OBJ},
+	"string": {object.STRING_OBJ},
+	"bool":   {object.BOOLEAN_OBJ},
+	"null":   {object.NULL_OBJ},
+	"array":  {object.ARRAY_OBJ},
+	"hash":   {object.HASH_OBJ},
+	"fn":     {object.FUNCTION_OBJ, object.BUILTIN_OBJ},
+	"error":  {object.ERROR_VALUE_OBJ},
+	"any":    nil,
+}
+
+// allows reports whether a value of type kind may pass annotation ann.
+// A missing annotation and an unknown kind allow anything.
+func allows(ann *ast.TypeAnnotation, kind object.ObjectType) bool {
+	if ann == nil || kind == "" {
+		return true
+	}
+	kinds, ok := annotationTypes[ann.Name]
+	return !ok || kinds == nil || containsKind(kinds, kind)
+}
+
+// annotated is the type known of a value that passed annotation ann.
+func annotated(ann *ast.TypeAnnotation) staticType {
+	if ann == nil {
+		return unknownType
+	}
+	if kinds := annotationTypes[ann.Name]; len(kinds) == 1 {
+		return knownType(kinds[0])
+	}
+	return unknownType
+}
+
+func containsKind(kinds []object.ObjectType, kind object.ObjectType) bool {
+	for _, k := range kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// typeVar is a variable of one scope: current is the type of its latest
+// binding and all the type every binding so far agrees on.
+type typeVar struct {
+	current staticType
+	all     staticType
+}
+
+type typeScope struct {
+	parent *typeScope
+	// function is set for the scope of a function call, outside of which
+	// variables may have been rebound by the time the call runs.
+	function bool
+	vars     map[string]*typeVar
+}
+
+func newTypeScope(parent *typeScope, function bool) *typeScope {
+	return &typeScope{parent: parent, function: function, vars: map[string]*typeVar{}}
+}
+
+func (s *typeScope) declare(name string, t staticType) {
+	if v, ok := s.vars[name]; ok {
+		v.current = t
+		v.all = join(v.all, t)
+		return
+	}
+	s.vars[name] = &typeVar{current: t, all: t}
+}
+
+type checker struct {
+	scope     *typeScope
+	functions []func()
+	errors    []TypeError
+	// names holds the names function literals are bound to by let.
+	names map[*ast.FunctionLiteral]string
+	// fn is the function whose body is being checked, if any.
+	fn *ast.FunctionLiteral
+	// conditional counts the enclosing code that may not run when the
+	// program does: function bodies, branches, match arms, the right of
+	// `??` and whatever follows a top-level return or throw. guarded
+	// counts the enclosing try expressions.
+	conditional, guarded int
+}
+
+// errorf reports an operation that fails whatever the values, fatal only
+// where it must run.
+func (c *checker) errorf(node ast.Node, f string, a ...interface{}) {
+	c.report(node, c.guarded == 0 && c.conditional == 0, f, a...)
+}
+
+// annotationErrorf reports a value that cannot pass an annotation.
+func (c *checker) annotationErrorf(node ast.Node, f string, a ...interface{}) {
+	c.report(node, c.guarded == 0, f, a...)
+}
+
+func (c *checker) report(node ast.Node, fatal bool, f string, a ...interface{}) {
+	tok := nodeToken(node)
+	c.errors = append(c.errors, TypeError{Line: tok.Line, Column: tok.Column, Message: fmt.Sprintf(f, a...), Fatal: fatal})
+}
+
+// maybe checks code that may not run when the code around it does.
+func (c *checker) maybe(check func()) {
+	c.conditional++
+	defer func() { c.conditional-- }()
+	check()
+}
+
+func (c *checker) openScope() {
+	c.scope = newTypeScope(c.scope, false)
+}
+
+func (c *checker) closeScope() {
+	c.scope = c.scope.parent
+}
+
+func (c *checker) lookup(name string) staticType {
+	crossed := false
+	for s := c.scope; s != nil; s = s.parent {
+		if v, ok := s.vars[name]; ok {
+			if crossed {
+				return v.all
+			}
+			return v.current
+		}
+		crossed = crossed || s.function
+	}
+	if IsBuiltin(name) {
+		return staticType{kind: object.BUILTIN_OBJ, name: name}
+	}
+	return unknownType
+}
+
+// statements checks stmts in order and returns the type of the value
+// they produce, known only when the last one is an expression.
+func (c *checker) statements(stmts []ast.Statement) staticType {
+	result := unknownType
+	for _, stmt := range stmts {
+		result = c.statement(stmt)
+	}
+	return result
+}
+
+func (c *checker) statement(stmt ast.Statement) staticType {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		c.let(stmt)
+	case *ast.ExportStatement:
+		c.let(stmt.Statement)
+	case *ast.ImportStatement:
+		if stmt.Alias != nil {
+			c.scope.declare(stmt.Alias.Value, knownType(object.MODULE_OBJ))
+		}
+		for _, name := range stmt.Bindings {
+			c.scope.declare(name.Value, unknownType)
+		}
+	case *ast.ReturnStatement:
+		c.returns(stmt.Value, c.expression(stmt.Value))
+		c.stopsProgram()
+	case *ast.ThrowStatement:
+		c.expression(stmt.Value)
+		c.stopsProgram()
+	case *ast.DeferStatement:
+		c.expression(stmt.Call)
+	case *ast.ExpressionStatement:
+		return c.expression(stmt.Expression)
+	case *ast.BlockStatement:
+		return c.statements(stmt.Statements)
+	}
+	return unknownType
+}
+
+// stopsProgram notes a return or throw outside any function, after which
+// the rest of the program may not run.
+func (c *checker) stopsProgram() {
+	if c.fn == nil {
+		c.conditional++
+	}
+}
+
+func (c *checker) let(stmt *ast.LetStatement) {
+	if fn, ok := stmt.Value.(*ast.FunctionLiteral); ok && stmt.Bind != nil {
+		c.names[fn] = stmt.Bind.Value
+	}
+	t := c.expression(stmt.Value)
+	if stmt.Pattern != nil {
+		c.pattern(stmt.Pattern)
+		return
+	}
+	if ann := stmt.Bind.Type; ann != nil {
+		if !allows(ann, t.kind) {
+			c.annotationErrorf(stmt.Bind, "cannot use %s as %s in let %s", t.kind, ann.Name, stmt.Bind.Value)
+		}
+		if t.kind == "" || !allows(ann, t.kind) {
+			t = annotated(ann)
+		}
+	}
+	c.scope.declare(stmt.Bind.Value, t)
+}
+
+// pattern declares the names pattern binds, whose types are only known at
+// run time.
+func (c *checker) pattern(pattern ast.Pattern) {
+	switch pattern := pattern.(type) {
+	case *ast.BindingPattern:
+		c.expression(pattern.Default)
+		c.scope.declare(pattern.Name.Value, unknownType)
+	case *ast.ArrayPattern:
+		for _, el := range pattern.Elements {
+			c.pattern(el)
+		}
+		if pattern.Rest != nil {
+			c.scope.declare(pattern.Rest.Value, knownType(object.ARRAY_OBJ))
+		}
+	case *ast.HashPattern:
+		for _, value := range pattern.Values {
+			c.pattern(value)
+		}
+	}
+}
+
+// returns checks a value of type t produced by node, the operand of a
+// return or the last expression of a function body, against the
+// function's result annotation.
+func (c *checker) returns(node ast.Node, t staticType) {
+	if c.fn == nil || allows(c.fn.ReturnType, t.kind) {
+		return
+	}
+	c.annotationErrorf(node, "cannot use %s as %s in return from %s", t.kind, c.fn.ReturnType.Name, c.functionName(c.fn))
+}
+
+func (c *checker) functionName(fn *ast.FunctionLiteral) string {
+	if name := c.names[fn]; name != "" {
+		return name
+	}
+	return "<anonymous>"
+}
+
+// block checks a block that runs in a scope of its own.
+func (c *checker) block(block *ast.BlockStatement) staticType {
+	if block == nil {
+		return unknownType
+	}
+	c.openScope()
+	defer c.closeScope()
+	return c.statements(block.Statements)
+}
+
+func (c *checker) expression(exp ast.Expression) staticType {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		return knownType(object.INTEGER_OBJ)
+	case *ast.Boolean:
+		return knownType(object.BOOLEAN_OBJ)
+	case *ast.StringLiteral:
+		return knownType(object.STRING_OBJ)
+	case *ast.InterpolatedString:
+		c.expressions(exp.Parts)
+		return knownType(object.STRING_OBJ)
+	case *ast.Identifier:
+		return c.lookup(exp.Value)
+	case *ast.PrefixExpression:
+		return c.prefix(exp)
+	case *ast.InfixExpression:
+		return c.infix(exp)
+	case *ast.IfExpression:
+		c.expression(exp.Condition)
+		var consequence, alternative staticType
+		c.maybe(func() {
+			consequence = c.block(exp.Consequence)
+			alternative = knownType(object.NULL_OBJ)
+			if exp.Alternative != nil {
+				alternative = c.block(exp.Alternative)
+			}
+		})
+		return join(consequence, alternative)
+	case *ast.TryExpression:
+		c.guarded++
+		c.block(exp.Block)
+		c.guarded--
+		c.maybe(func() {
+			if exp.Catch != nil {
+				c.openScope()
+				if exp.Param != nil {
+					c.scope.declare(exp.Param.Value, knownType(object.ERROR_VALUE_OBJ))
+				}
+				c.statements(exp.Catch.Statements)
+				c.closeScope()
+			}
+		})
+		c.block(exp.Finally)
+	case *ast.MatchExpression:
+		c.expression(exp.Subject)
+		c.maybe(func() {
+			for _, arm := range exp.Arms {
+				c.openScope()
+				c.pattern(arm.Pattern)
+				c.expression(arm.Guard)
+				if block, ok := arm.Body.(*ast.BlockStatement); ok {
+					c.statements(block.Statements)
+				} else if body, ok := arm.Body.(ast.Expression); ok {
+					c.expression(body)
+				}
+				c.closeScope()
+			}
+		})
+	case *ast.FunctionLiteral:
+		return c.function(exp)
+	case *ast.CallExpression:
+		t, _ := c.call(exp)
+		return t
+	case *ast.ArrayLiteral:
+		c.expressions(exp.Elements)
+		return knownType(object.ARRAY_OBJ)
+	case *ast.HashLiteral:
+		for _, key := range exp.Keys() {
+			if k := c.expression(key); !hashableKind(k.kind) {
+				c.errorf(key, "unusable as hash key: %s", k.kind)
+			}
+			c.expression(exp.Pairs[key])
+		}
+		return knownType(object.HASH_OBJ)
+	case *ast.IndexExpression:
+		t, _ := c.index(exp)
+		return t
+	case *ast.SliceExpression:
+		t, _ := c.slice(exp)
+		return t
+	case *ast.RangeExpression:
+		start, end := c.expression(exp.Start), c.expression(exp.End)
+		if start.kind != "" && end.kind != "" &&
+			(start.kind != object.INTEGER_OBJ || end.kind != object.INTEGER_OBJ) {
+			c.errorf(exp, "range bounds must be INTEGER, got %s%s%s", start.kind, exp.Operator, end.kind)
+		}
+		return knownType(object.ARRAY_OBJ)
+	}
+	return unknownType
+}
+
+func (c *checker) expressions(exps []ast.Expression) []staticType {
+	types := make([]staticType, len(exps))
+	for i, exp := range exps {
+		types[i] = c.expression(exp)
+	}
+	return types
+}
+
+func (c *checker) prefix(exp *ast.PrefixExpression) staticType {
+	on := c.expression(exp.On)
+	switch exp.Operator {
+	case "!":
+		return knownType(object.BOOLEAN_OBJ)
+	case "-":
+		if on.kind != "" && on.kind != object.INTEGER_OBJ {
+			c.errorf(exp, "unknown operator: -%s", on.kind)
+			return unknownType
+		}
+		return knownType(object.INTEGER_OBJ)
+	}
+	return unknownType
+}
+
+// infix follows evalInfixExpression: integers take every operator, strings
+// `+` and comparisons, and any two values `==` and `!=`.
+func (c *checker) infix(exp *ast.InfixExpression) staticType {
+	op := exp.Operator
+	left := c.expression(exp.Left)
+	var right staticType
+	if op == "??" {
+		c.maybe(func() { right = c.expression(exp.Right) })
+	} else {
+		right = c.expression(exp.Right)
+	}
+
+	switch {
+	case op == "??":
+		switch left.kind {
+		case "":
+			return unknownType
+		case object.NULL_OBJ:
+			return right
+		default:
+			return left
+		}
+	case op == "==" || op == "!=":
+		return knownType(object.BOOLEAN_OBJ)
+	case left.kind == "" || right.kind == "":
+		return infixResult(op, left.kind+right.kind)
+	case left.kind == object.INTEGER_OBJ && right.kind == object.INTEGER_OBJ:
+		return infixResult(op, object.INTEGER_OBJ)
+	case left.kind == object.STRING_OBJ && right.kind == object.STRING_OBJ:
+		if op == "+" || op == "<" || op == ">" {
+			return infixResult(op, object.STRING_OBJ)
+		}
+		c.errorf(exp, "unknown operator: %s %s %s", left.kind, op, right.kind)
+	case left.kind != right.kind:
+		c.errorf(exp, "type mismatch: %s %s %s", left.kind, op, right.kind)
+	default:
+		c.errorf(exp, "unknown operator: %s %s %s", left.kind, op, right.kind)
+	}
+	return unknownType
+}
+
+// infixResult is the type of a successful op on operands of type kind,
+// "" if it is not known.
+func infixResult(op string, kind object.ObjectType) staticType {
+	switch op {
+	case "<", ">":
+		return knownType(object.BOOLEAN_OBJ)
+	case "-", "*", "/":
+		return knownType(object.INTEGER_OBJ)
+	}
+	if kind == object.INTEGER_OBJ || kind == object.STRING_OBJ {
+		return knownType(kind)
+	}
+	return unknownType
+}
+
+// chainLink checks an index, slice or call the way evalChainLink runs
+// it. optional reports that an optional index earlier in the chain may
+// find null and end it, so the rest of the chain may not run; if its type
+// is also null, it is known to.
+func (c *checker) chainLink(exp ast.Expression) (t staticType, optional bool) {
+	switch exp := exp.(type) {
+	case *ast.IndexExpression:
+		return c.index(exp)
+	case *ast.SliceExpression:
+		return c.slice(exp)
+	case *ast.CallExpression:
+		return c.call(exp)
+	}
+	return c.expression(exp), false
+}
+
+// link checks the rest of a link in a chain, which may not run when the
+// chain is optional.
+func (c *checker) link(optional bool, check func()) {
+	if optional {
+		c.maybe(check)
+	} else {
+		check()
+	}
+}
+
+func (c *checker) index(exp *ast.IndexExpression) (staticType, bool) {
+	left, optional := c.chainLink(exp.Left)
+	if (optional || exp.Optional) && left.kind == object.NULL_OBJ {
+		c.maybe(func() { c.expression(exp.Index) })
+		return knownType(object.NULL_OBJ), true
+	}
+	optional = optional || exp.Optional && left.kind == ""
+	result := unknownType
+	c.link(optional, func() { result = c.indexType(exp, left) })
+	return result, optional
+}
+
+func (c *checker) indexType(exp *ast.IndexExpression, left staticType) staticType {
+	index := c.expression(exp.Index)
+	var want object.ObjectType
+	switch left.kind {
+	case "":
+		return unknownType
+	case object.ARRAY_OBJ, object.STRING_OBJ:
+		want = object.INTEGER_OBJ
+	case object.ERROR_VALUE_OBJ, object.MODULE_OBJ:
+		want = object.STRING_OBJ
+	case object.HASH_OBJ:
+		if !hashableKind(index.kind) {
+			c.errorf(exp.Index, "unusable as hash key: %s", index.kind)
+		}
+		return unknownType
+	default:
+		c.errorf(exp, "index operator not supported: %s", left.kind)
+		return unknownType
+	}
+	if index.kind != "" && index.kind != want {
+		c.errorf(exp, "index operator not supported: %s", left.kind)
+	}
+	return unknownType
+}
+
+func (c *checker) slice(exp *ast.SliceExpression) (staticType, bool) {
+	left, optional := c.chainLink(exp.Left)
+	if optional && left.kind == object.NULL_OBJ {
+		c.maybe(func() { c.expressions([]ast.Expression{exp.Start, exp.End}) })
+		return left, true
+	}
+	result := unknownType
+	c.link(optional, func() { result = c.sliceType(exp, left) })
+	return result, optional
+}
+
+func (c *checker) sliceType(exp *ast.SliceExpression, left staticType) staticType {
+	result := unknownType
+	switch left.kind {
+	case "":
+	case object.STRING_OBJ, object.ARRAY_OBJ:
+		result = knownType(left.kind)
+	default:
+		c.errorf(exp, "slice operator not supported: %s", left.kind)
+	}
+	for _, bound := range []ast.Expression{exp.Start, exp.End} {
+		if bound == nil {
+			continue
+		}
+		if t := c.expression(bound); t.kind != "" && t.kind != object.INTEGER_OBJ {
+			c.errorf(bound, "slice bound must be INTEGER, got %s", t.kind)
+		}
+	}
+	return result
+}
+
+// hashableKind reports whether values of type kind may be hash keys.
+func hashableKind(kind object.ObjectType) bool {
+	switch kind {
+	case object.FUNCTION_OBJ, object.BUILTIN_OBJ, object.MODULE_OBJ, object.ERROR_VALUE_OBJ:
+		return false
+	default:
+		return true
+	}
+}
+
+// function queues fn's body to be checked once the whole program has
+// been, with the parameters typed by their annotations.
+func (c *checker) function(fn *ast.FunctionLiteral) staticType {
+	enclosing, guarded := c.scope, c.guarded
+	c.functions = append(c.functions, func() {
+		saved, savedFn := c.scope, c.fn
+		savedConditional, savedGuarded := c.conditional, c.guarded
+		defer func() { c.conditional, c.guarded = savedConditional, savedGuarded }()
+		c.scope, c.fn = newTypeScope(enclosing, true), fn
+		c.conditional, c.guarded = 1, guarded
+		for _, param := range fn.Parameters {
+			c.scope.declare(param.Value, annotated(param.Type))
+		}
+		if fn.Body != nil && len(fn.Body.Statements) > 0 {
+			result := c.statements(fn.Body.Statements)
+			last := fn.Body.Statements[len(fn.Body.Statements)-1]
+			if stmt, ok := last.(*ast.ExpressionStatement); ok {
+				c.returns(stmt.Expression, result)
+			}
+		}
+		c.scope, c.fn = saved, savedFn
+	})
+	return staticType{kind: object.FUNCTION_OBJ, fn: fn, name: c.names[fn]}
+}
+
+func (c *checker) call(exp *ast.CallExpression) (staticType, bool) {
+	if isQuoteCall(exp) {
+		for _, quoted := range exp.Arguments {
+			ast.Inspect(quoted, func(node ast.Node) bool {
+				if isUnquoteCall(node) {
+					c.expressions(node.(*ast.CallExpression).Arguments)
+					return false
+				}
+				return true
+			})
+		}
+		return knownType(object.QUOTE_OBJ), false
+	}
+
+	callee, optional := c.chainLink(exp.Function)
+	if optional && callee.kind == object.NULL_OBJ {
+		c.maybe(func() { c.expressions(exp.Arguments) })
+		return callee, true
+	}
+	result := unknownType
+	c.link(optional, func() { result = c.callType(exp, callee) })
+	return result, optional
+}
+
+func (c *checker) callType(exp *ast.CallExpression, callee staticType) staticType {
+	args := c.expressions(exp.Arguments)
+	switch callee.kind {
+	case "":
+		return unknownType
+	case object.FUNCTION_OBJ:
+		if callee.fn == nil {
+			return unknownType
+		}
+		name := callee.name
+		if name == "" {
+			name = "<anonymous>"
+		}
+		for i, param := range callee.fn.Parameters {
+			if i < len(args) && !allows(param.Type, args[i].kind) {
+				c.annotationErrorf(exp.Arguments[i], "cannot use %s as %s in argument %s to %s",
+					args[i].kind, param.Type.Name, param.Value, name)
+			}
+		}
+		return annotated(callee.fn.ReturnType)
+	case object.BUILTIN_OBJ:
+		return c.builtinCall(exp, callee.name, args)
+	default:
+		c.errorf(exp.Function, "not a function: %s", callee.kind)
+		return unknownType
+	}
+}
+
+func (c *checker) builtinCall(exp *ast.CallExpression, name string, args []staticType) staticType {
+	bt, ok := builtinTypes[name]
+	if !ok {
+		return unknownType
+	}
+	for i, arg := range args {
+		kinds, which := bt.rest, "argument"
+		if i < len(bt.args) {
+			kinds = bt.args[i]
+			if i < len(ordinals) {
+				which = ordinals[i]
+			}
+		}
+		if kinds != nil && arg.kind != "" && !containsKind(kinds, arg.kind) {
+			c.errorf(exp.Arguments[i], "%s to `%s` must be %s, got %s", which, name, describeKinds(kinds), arg.kind)
+		}
+	}
+	return knownType(bt.result)
+}
+
+var ordinals = []string{"argument", "second argument", "third argument"}
+
+func describeKinds(kinds []object.ObjectType) string {
+	names := make([]string, len(kinds))
+	for i, kind := range kinds {
+		names[i] = string(kind)
+	}
+	if len(names) == 1 {
+		return names[0]
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " or " + names[len(names)-1]
+}
+
+// builtinType describes the arguments a builtin accepts and its result,
+// "" when that depends on the arguments. Arguments past the listed ones
+// must be of the rest kinds; nil kinds allow anything.
+type builtinType struct {
+	args   [][]object.ObjectType
+	rest   []object.ObjectType
+	result object.ObjectType
+}
+
+var (
+	anyKind      []object.ObjectType
+	integerKind  = []object.ObjectType{object.INTEGER_OBJ}
+	stringKind   = []object.ObjectType{object.STRING_OBJ}
+	arrayKind    = []object.ObjectType{object.ARRAY_OBJ}
+	hashKind     = []object.ObjectType{object.HASH_OBJ}
+	callableKind = []object.ObjectType{object.FUNCTION_OBJ, object.BUILTIN_OBJ}
+)
+
+var builtinTypes = map[string]builtinType{
+	"len":        {args: [][]object.ObjectType{{object.ARRAY_OBJ, object.STRING_OBJ, object.HASH_OBJ}}, result: object.INTEGER_OBJ},
+	"first":      {args: [][]object.ObjectType{arrayKind}},
+	"last":       {args: [][]object.ObjectType{arrayKind}},
+	"rest":       {args: [][]object.ObjectType{arrayKind}},
+	"push":       {args: [][]object.ObjectType{arrayKind, anyKind}, result: object.ARRAY_OBJ},
+	"puts":       {result: object.NULL_OBJ},
+	"error":      {args: [][]object.ObjectType{stringKind, stringKind}, result: object.ERROR_VALUE_OBJ},
+	"format":     {args: [][]object.ObjectType{stringKind}, result: object.STRING_OBJ},
+	"printf":     {args: [][]object.ObjectType{stringKind}, result: object.NULL_OBJ},
+	"map":        {args: [][]object.ObjectType{arrayKind, callableKind}, result: object.ARRAY_OBJ},
+	"filter":     {args: [][]object.ObjectType{arrayKind, callableKind}, result: object.ARRAY_OBJ},
+	"reduce":     {args: [][]object.ObjectType{arrayKind, callableKind, anyKind}},
+	"each":       {args: [][]object.ObjectType{arrayKind, callableKind}, result: object.NULL_OBJ},
+	"find":       {args: [][]object.ObjectType{arrayKind, callableKind}},
+	"any":        {args: [][]object.ObjectType{arrayKind, callableKind}, result: object.BOOLEAN_OBJ},
+	"all":        {args: [][]object.ObjectType{arrayKind, callableKind}, result: object.BOOLEAN_OBJ},
+	"sort":       {args: [][]object.ObjectType{arrayKind, callableKind}, result: object.ARRAY_OBJ},
+	"reverse":    {args: [][]object.ObjectType{{object.ARRAY_OBJ, object.STRING_OBJ}}},
+	"zip":        {rest: arrayKind, result: object.ARRAY_OBJ},
+	"flatten":    {args: [][]object.ObjectType{arrayKind, integerKind}, result: object.ARRAY_OBJ},
+	"uniq":       {args: [][]object.ObjectType{arrayKind}, result: object.ARRAY_OBJ},
+	"sum":        {args: [][]object.ObjectType{arrayKind}, result: object.INTEGER_OBJ},
+	"min":        {args: [][]object.ObjectType{arrayKind}},
+	"max":        {args: [][]object.ObjectType{arrayKind}},
+	"keys":       {args: [][]object.ObjectType{hashKind}, result: object.ARRAY_OBJ},
+	"values":     {args: [][]object.ObjectType{hashKind}, result: object.ARRAY_OBJ},
+	"entries":    {args: [][]object.ObjectType{hashKind}, result: object.ARRAY_OBJ},
+	"has":        {args: [][]object.ObjectType{hashKind, anyKind}, result: object.BOOLEAN_OBJ},
+	"delete":     {args: [][]object.ObjectType{hashKind, anyKind}, result: object.HASH_OBJ},
+	"merge":      {rest: hashKind, result: object.HASH_OBJ},
+	"split":      {args: [][]object.ObjectType{stringKind, stringKind}, result: object.ARRAY_OBJ},
+	"join":       {args: [][]object.ObjectType{arrayKind, stringKind}, result: object.STRING_OBJ},
+	"trim":       {args: [][]object.ObjectType{stringKind}, result: object.STRING_OBJ},
+	"upper":      {args: [][]object.ObjectType{stringKind}, result: object.STRING_OBJ},
+	"lower":      {args: [][]object.ObjectType{stringKind}, result: object.STRING_OBJ},
+	"contains":   {args: [][]object.ObjectType{stringKind, stringKind}, result: object.BOOLEAN_OBJ},
+	"startsWith": {args: [][]object.ObjectType{stringKind, stringKind}, result: object.BOOLEAN_OBJ},
+	"endsWith":   {args: [][]object.ObjectType{stringKind, stringKind}, result: object.BOOLEAN_OBJ},
+	"replace":    {args: [][]object.ObjectType{stringKind, stringKind, stringKind}, result: object.STRING_OBJ},
+	"indexOf":    {args: [][]object.ObjectType{stringKind, stringKind}, result: object.INTEGER_OBJ},
+	"repeat":     {args: [][]object.ObjectType{stringKind, integerKind}, result: object.STRING_OBJ},
+	"chars":      {args: [][]object.ObjectType{stringKind}, result: object.ARRAY_OBJ},
+	"str":        {result: object.STRING_OBJ},
+	"int":        {args: [][]object.ObjectType{{object.INTEGER_OBJ, object.BOOLEAN_OBJ, object.STRING_OBJ}}, result: object.INTEGER_OBJ},
+	"parseInt":   {args: [][]object.ObjectType{stringKind, integerKind}},
+}
+
+// nodeToken returns the token node was parsed from, where errors about
+// it are reported.
+func nodeToken(node ast.Node) token.Token {
+	v := reflect.ValueOf(node)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return token.Token{}
+	}
+	field := v.Elem().FieldByName("Token")
+	if !field.IsValid() {
+		return token.Token{}
+	}
+	tok, _ := field.Interface().(token.Token)
+	return tok
+}
+
+// checkAnnotation is the run-time side of an annotation: it fails unless
+// val may pass ann, which is described as the given use, e.g.
+// "let x".
+func checkAnnotation(ann *ast.TypeAnnotation, val object.Object, use string) *object.Error {
+	kind := object.ObjectType(object.NULL_OBJ)
+	if val != nil {
+		kind = val.Type()
+	}
+	if allows(ann, kind) {
+		return nil
+	}
+	return newTypeError("cannot use %s as %s in %s", kind, ann.Name, use)
+}
+
+func newTypeError(f string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(f, a...), Kind: "TypeError"}
+}
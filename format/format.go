@@ -0,0 +1,565 @@
+// Package format prints Monkey programs in a canonical layout: four-space
+// indentation, one statement per line, the parentheses the operators
+// need and no others, and lists broken one element per line when they do
+// not fit in 80 columns. Comments stay with the statements they sit next
+// to, and single blank lines between statements are kept.
+package format
+
+import (
+	"errors"
+	"monkey/ast"
+	"monkey/lexer"
+	"monkey/parser"
+	"monkey/token"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	indentWidth = 4
+	maxWidth    = 80
+)
+
+// Source formats Monkey source code. Source that does not parse is
+// returned unchanged along with the parse errors.
+func Source(src []byte) ([]byte, error) {
+	p := parser.New(lexer.New(string(src)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return src, errors.New(strings.Join(p.Errors(), "; "))
+	}
+	return []byte(Program(program, string(src))), nil
+}
+
+// Program prints program, parsed from source, in canonical form. The
+// comments in program.Comments are placed by their positions, and a blank
+// source line before a statement or comment is kept; source may be empty
+// for trees that were not parsed from text.
+func Program(program *ast.Program, source string) string {
+	p := &printer{comments: program.Comments, lines: strings.Split(source, "\n")}
+	return p.statements(program.Statements, token.Token{})
+}
+
+type printer struct {
+	comments []token.Token
+	lines    []string // source lines, to find blank ones
+	next     int      // index of the first comment not yet printed
+	depth    int      // indentation level of the current line
+}
+
+// blankBefore reports whether the source line above line is blank.
+func (p *printer) blankBefore(line int) bool {
+	return line >= 2 && line-2 < len(p.lines) && strings.TrimSpace(p.lines[line-2]) == ""
+}
+
+func (p *printer) indent() string {
+	return strings.Repeat(" ", p.depth*indentWidth)
+}
+
+// line is a statement or comment printed on lines of its own.
+type line struct {
+	start   int    // source line, to find blank lines before it
+	text    string // the statement or comment
+	comment string // comments after the statement
+	// open marks a statement that ends in a brace and is printed without
+	// a semicolon unless the next statement would otherwise continue it.
+	open bool
+}
+
+// statements prints stmts one per line at the current depth, with the
+// comments that come before end. A zero end takes all remaining comments.
+func (p *printer) statements(stmts []ast.Statement, end token.Token) string {
+	indent := p.indent()
+	var lines []line
+	flushBefore := func(start, column int) {
+		for p.next < len(p.comments) {
+			c := p.comments[p.next]
+			if start > 0 && (c.Line > start || c.Line == start && c.Column > column) {
+				return
+			}
+			lines = append(lines, line{start: c.Line, text: c.Literal})
+			p.next++
+		}
+	}
+
+	for _, stmt := range stmts {
+		l := line{start: tokenOf(stmt).Line}
+		flushBefore(l.start, 0)
+
+		l.text = p.statement(stmt, len(indent))
+		l.open = endsInBrace(stmt)
+		last := lastLine(stmt)
+		for p.next < len(p.comments) && p.comments[p.next].Line <= last {
+			l.comment += " " + p.comments[p.next].Literal
+			p.next++
+		}
+		lines = append(lines, l)
+	}
+	flushBefore(end.Line, end.Column)
+
+	var out strings.Builder
+	for i, l := range lines {
+		if i > 0 && p.blankBefore(l.start) {
+			out.WriteString("\n")
+		}
+		if l.open && continued(lines[i+1:]) {
+			l.text += ";"
+		}
+		out.WriteString(indent + l.text + l.comment + "\n")
+	}
+	return out.String()
+}
+
+// endsInBrace reports whether stmt is an if, try or match expression,
+// which reads as finished without a semicolon.
+func endsInBrace(stmt ast.Statement) bool {
+	if stmt, ok := stmt.(*ast.ExpressionStatement); ok {
+		switch stmt.Expression.(type) {
+		case *ast.IfExpression, *ast.TryExpression, *ast.MatchExpression:
+			return true
+		}
+	}
+	return false
+}
+
+// continued reports whether the first statement in rest starts with a
+// token the parser would read as an operator applied to the statement
+// before it, as `[` or `(` on the next line would index or call an if
+// expression.
+func continued(rest []line) bool {
+	for _, l := range rest {
+		if strings.HasPrefix(l.text, "//") {
+			continue
+		}
+		first := lexer.New(l.text).NextToken()
+		return parser.Precedence(first.Type) > parser.LOWEST
+	}
+	return false
+}
+
+func (p *printer) statement(stmt ast.Statement, col int) string {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		return p.let(stmt, col)
+	case *ast.ReturnStatement:
+		if stmt.Value == nil {
+			return "return;"
+		}
+		return "return " + p.expr(stmt.Value, col+len("return ")) + ";"
+	case *ast.ThrowStatement:
+		return "throw " + p.expr(stmt.Value, col+len("throw ")) + ";"
+	case *ast.DeferStatement:
+		return "defer " + p.expr(stmt.Call, col+len("defer ")) + ";"
+	case *ast.ImportStatement:
+		return stmt.String()
+	case *ast.ExportStatement:
+		return "export " + p.let(stmt.Statement, col+len("export "))
+	case *ast.ExpressionStatement:
+		text := p.expr(stmt.Expression, col)
+		if endsInBrace(stmt) {
+			return text
+		}
+		return text + ";"
+	case *ast.BlockStatement:
+		return p.block(stmt, col)
+	}
+	return stmt.String()
+}
+
+func (p *printer) let(stmt *ast.LetStatement, col int) string {
+	target := ""
+	if stmt.Pattern != nil {
+		target = p.pattern(stmt.Pattern)
+	} else {
+		target = stmt.Bind.String()
+	}
+	prefix := stmt.Token.Literal + " " + target + " = "
+	return prefix + p.expr(stmt.Value, col+len(prefix)) + ";"
+}
+
+// block prints a block starting at column col. A block written on one
+// line around a single expression stays on one line if it still fits; it
+// cannot hold a comment, which would run past the closing brace.
+func (p *printer) block(block *ast.BlockStatement, col int) string {
+	if text, ok := p.inlineBlock(block, col); ok {
+		return text
+	}
+	p.depth++
+	body := p.statements(block.Statements, block.Rbrace)
+	p.depth--
+	if body == "" {
+		return "{}"
+	}
+	return "{\n" + body + p.indent() + "}"
+}
+
+func (p *printer) inlineBlock(block *ast.BlockStatement, col int) (string, bool) {
+	if block.Rbrace.Line == 0 || block.Token.Line != block.Rbrace.Line {
+		return "", false
+	}
+	if len(block.Statements) == 0 {
+		return "{}", true
+	}
+	stmt, ok := block.Statements[0].(*ast.ExpressionStatement)
+	if !ok || len(block.Statements) != 1 {
+		return "", false
+	}
+	text := "{ " + p.expr(stmt.Expression, col+2) + " }"
+	return text, fits(col, text)
+}
+
+func (p *printer) expr(exp ast.Expression, col int) string {
+	switch exp := exp.(type) {
+	case *ast.Identifier:
+		return exp.Value
+	case *ast.IntegerLiteral:
+		return strconv.FormatInt(exp.Value, 10)
+	case *ast.Boolean:
+		return strconv.FormatBool(exp.Value)
+	case *ast.StringLiteral:
+		return `"` + exp.Value + `"`
+	case *ast.InterpolatedString:
+		return `"` + exp.Token.Literal + `"`
+	case *ast.PrefixExpression:
+		return exp.Operator + p.operand(exp.On, parser.PREFIX, col+len(exp.Operator))
+	case *ast.InfixExpression:
+		prec := parser.Precedence(exp.Token.Type)
+		left := p.operand(exp.Left, prec, col)
+		op := " " + exp.Operator + " "
+		return left + op + p.operand(exp.Right, prec+1, endCol(col, left)+len(op))
+	case *ast.RangeExpression:
+		left := p.operand(exp.Start, parser.RANGE, col)
+		return left + exp.Operator + p.operand(exp.End, parser.RANGE+1, endCol(col, left)+len(exp.Operator))
+	case *ast.IfExpression:
+		return p.ifExpression(exp, col)
+	case *ast.TryExpression:
+		return p.tryExpression(exp, col)
+	case *ast.MatchExpression:
+		return p.matchExpression(exp, col)
+	case *ast.FunctionLiteral:
+		return p.function("fn", exp.Parameters, exp.ReturnType, exp.Body, col)
+	case *ast.MacroLiteral:
+		return p.function("macro", exp.Parameters, nil, exp.Body, col)
+	case *ast.CallExpression:
+		fn := p.operand(exp.Function, parser.CALL, col)
+		return fn + p.list("(", ")", exp.Rparen, spansOf(exp.Arguments), endCol(col, fn), func(i, col int) string {
+			return p.expr(exp.Arguments[i], col)
+		})
+	case *ast.ArrayLiteral:
+		return p.list("[", "]", exp.Rbracket, spansOf(exp.Elements), col, func(i, col int) string {
+			return p.expr(exp.Elements[i], col)
+		})
+	case *ast.HashLiteral:
+		keys := exp.Keys()
+		spans := make([]span, len(keys))
+		for i, key := range keys {
+			spans[i] = span{first: tokenOf(key).Line, last: lastLine(exp.Pairs[key])}
+		}
+		return p.list("{", "}", exp.Rbrace, spans, col, func(i, col int) string {
+			key := p.expr(keys[i], col) + ": "
+			return key + p.expr(exp.Pairs[keys[i]], endCol(col, key))
+		})
+	case *ast.IndexExpression:
+		left := p.operand(exp.Left, parser.INDEX, col)
+		if name, ok := exp.Index.(*ast.StringLiteral); ok && exp.Optional && name.Token.Type == token.IDENT {
+			return left + "?." + name.Value
+		}
+		if exp.Optional {
+			left += "?."
+		}
+		return left + "[" + p.expr(exp.Index, endCol(col, left)+1) + "]"
+	case *ast.SliceExpression:
+		text := p.operand(exp.Left, parser.INDEX, col) + "["
+		if exp.Start != nil {
+			text += p.expr(exp.Start, endCol(col, text))
+		}
+		text += ":"
+		if exp.End != nil {
+			text += p.expr(exp.End, endCol(col, text))
+		}
+		return text + "]"
+	}
+	return exp.String()
+}
+
+// operand prints exp where an operator of precedence prec expects an
+// operand, adding parentheses if exp binds more loosely.
+func (p *printer) operand(exp ast.Expression, prec int, col int) string {
+	if precedence(exp) < prec {
+		return "(" + p.expr(exp, col+1) + ")"
+	}
+	return p.expr(exp, col)
+}
+
+func precedence(exp ast.Expression) int {
+	switch exp := exp.(type) {
+	case *ast.InfixExpression:
+		return parser.Precedence(exp.Token.Type)
+	case *ast.RangeExpression:
+		return parser.RANGE
+	case *ast.PrefixExpression:
+		return parser.PREFIX
+	}
+	return parser.INDEX + 1
+}
+
+func (p *printer) function(
+	keyword string,
+	params []*ast.Identifier,
+	result *ast.TypeAnnotation,
+	body *ast.BlockStatement,
+	col int,
+) string {
+	names := []string{}
+	for _, param := range params {
+		names = append(names, param.String())
+	}
+	head := keyword + "(" + strings.Join(names, ", ") + ") "
+	if result != nil {
+		head += "-> " + result.Name + " "
+	}
+	return head + p.block(body, col+len(head))
+}
+
+func (p *printer) ifExpression(exp *ast.IfExpression, col int) string {
+	head := "if (" + p.expr(exp.Condition, col+len("if (")) + ") "
+	text := head + p.block(exp.Consequence, endCol(col, head))
+	if elseIf := exp.ElseIf(); elseIf != nil {
+		text += " else "
+		return text + p.ifExpression(elseIf, endCol(col, text))
+	}
+	if exp.Alternative != nil {
+		text += " else "
+		text += p.block(exp.Alternative, endCol(col, text))
+	}
+	return text
+}
+
+func (p *printer) tryExpression(exp *ast.TryExpression, col int) string {
+	text := "try " + p.block(exp.Block, col+len("try "))
+	if exp.Catch != nil {
+		text += " catch "
+		if exp.Param != nil {
+			text += "(" + exp.Param.Value + ") "
+		}
+		text += p.block(exp.Catch, endCol(col, text))
+	}
+	if exp.Finally != nil {
+		text += " finally "
+		text += p.block(exp.Finally, endCol(col, text))
+	}
+	return text
+}
+
+// matchExpression prints one arm per line. Comments before an arm are
+// printed above it and comments on its last line after it.
+func (p *printer) matchExpression(exp *ast.MatchExpression, col int) string {
+	head := "match (" + p.expr(exp.Subject, col+len("match (")) + ") {"
+	if len(exp.Arms) == 0 {
+		return head + "}"
+	}
+
+	var out strings.Builder
+	out.WriteString(head + "\n")
+	p.depth++
+	indent := p.indent()
+	for i, arm := range exp.Arms {
+		start := tokenOf(arm.Pattern).Line
+		for p.next < len(p.comments) && p.comments[p.next].Line < start {
+			out.WriteString(indent + p.comments[p.next].Literal + "\n")
+			p.next++
+		}
+
+		text := p.pattern(arm.Pattern)
+		if arm.Guard != nil {
+			text += " if " + p.expr(arm.Guard, len(indent)+len(text)+len(" if "))
+		}
+		text += " => "
+		if block, ok := arm.Body.(*ast.BlockStatement); ok {
+			text += p.block(block, endCol(len(indent), text))
+		} else {
+			text += p.expr(arm.Body.(ast.Expression), endCol(len(indent), text))
+		}
+		if i < len(exp.Arms)-1 {
+			text += ","
+		}
+
+		last := lastLine(arm.Body)
+		for p.next < len(p.comments) && p.comments[p.next].Line <= last {
+			text += " " + p.comments[p.next].Literal
+			p.next++
+		}
+		out.WriteString(indent + text + "\n")
+	}
+	p.depth--
+	out.WriteString(p.indent() + "}")
+	return out.String()
+}
+
+// span is the first and last source line of a list item.
+type span struct{ first, last int }
+
+func spansOf(exps []ast.Expression) []span {
+	spans := make([]span, len(exps))
+	for i, exp := range exps {
+		spans[i] = span{first: tokenOf(exp).Line, last: lastLine(exp)}
+	}
+	return spans
+}
+
+// list prints the items, whose source lines are spans, between open and
+// close. They stay on one line when that fits, allowing only the last
+// item to span lines so a trailing function literal keeps its usual
+// shape; otherwise each item gets a line of its own. So do items with
+// comments among them before end, the closing token, and each comment is
+// printed above or after the item it was next to.
+func (p *printer) list(open, close string, end token.Token, spans []span, col int, item func(i, col int) string) string {
+	n := len(spans)
+	mark := p.next
+	inside := func() bool {
+		if p.next >= len(p.comments) || end.Line == 0 {
+			return false
+		}
+		c := p.comments[p.next]
+		return c.Line < end.Line || c.Line == end.Line && c.Column < end.Column
+	}
+	parts := make([]string, n)
+	flatOK := true
+	c := col + len(open)
+	for i := range parts {
+		parts[i] = item(i, c)
+		if i < n-1 && strings.Contains(parts[i], "\n") {
+			flatOK = false
+		}
+		c = endCol(c, parts[i]) + len(", ")
+	}
+	flat := open + strings.Join(parts, ", ") + close
+	firstLine := strings.SplitN(flat, "\n", 2)[0]
+	if !inside() && (n == 0 || flatOK && fits(col, firstLine)) {
+		return flat
+	}
+
+	p.next = mark
+	var out strings.Builder
+	out.WriteString(open + "\n")
+	p.depth++
+	indent := p.indent()
+	for i := 0; i < n; i++ {
+		for inside() && p.comments[p.next].Line < spans[i].first {
+			out.WriteString(indent + p.comments[p.next].Literal + "\n")
+			p.next++
+		}
+		out.WriteString(indent + item(i, len(indent)))
+		if i < n-1 {
+			out.WriteString(",")
+		}
+		for inside() && p.comments[p.next].Line <= spans[i].last {
+			out.WriteString(" " + p.comments[p.next].Literal)
+			p.next++
+		}
+		out.WriteString("\n")
+	}
+	for inside() {
+		out.WriteString(indent + p.comments[p.next].Literal + "\n")
+		p.next++
+	}
+	p.depth--
+	out.WriteString(p.indent() + close)
+	return out.String()
+}
+
+func (p *printer) pattern(pattern ast.Pattern) string {
+	switch pattern := pattern.(type) {
+	case *ast.BindingPattern:
+		if pattern.Default != nil {
+			return pattern.Name.Value + " = " + p.expr(pattern.Default, 0)
+		}
+		return pattern.Name.Value
+	case *ast.ArrayPattern:
+		elements := []string{}
+		for _, el := range pattern.Elements {
+			elements = append(elements, p.pattern(el))
+		}
+		if pattern.Rest != nil {
+			elements = append(elements, "..."+pattern.Rest.Value)
+		}
+		return "[" + strings.Join(elements, ", ") + "]"
+	case *ast.HashPattern:
+		pairs := []string{}
+		for i, key := range pattern.Keys {
+			value := p.pattern(pattern.Values[i])
+			name, isName := key.(*ast.StringLiteral)
+			isName = isName && name.Token.Type == token.IDENT
+			if binding, ok := pattern.Values[i].(*ast.BindingPattern); ok && isName && binding.Name.Value == name.Value {
+				pairs = append(pairs, value)
+			} else if isName {
+				pairs = append(pairs, name.Value+": "+value)
+			} else {
+				pairs = append(pairs, p.expr(key, 0)+": "+value)
+			}
+		}
+		return "{" + strings.Join(pairs, ", ") + "}"
+	}
+	return pattern.String()
+}
+
+// tokenOf returns the Token field every node type carries.
+func tokenOf(node ast.Node) token.Token {
+	v := reflect.ValueOf(node).Elem().FieldByName("Token")
+	if !v.IsValid() {
+		return token.Token{}
+	}
+	return v.Interface().(token.Token)
+}
+
+// lastLine is the last source line the tree rooted at node occupies.
+func lastLine(node ast.Node) int {
+	last := 0
+	ast.Inspect(node, func(n ast.Node) bool {
+		if n == nil {
+			return false
+		}
+		tok := tokenOf(n)
+		line := tok.Line
+		if tok.Type == token.STRING || tok.Type == token.TEMPLATE {
+			line += strings.Count(tok.Literal, "\n")
+		}
+		if end := closing(n); end.Line > line {
+			line = end.Line
+		}
+		if line > last {
+			last = line
+		}
+		return true
+	})
+	return last
+}
+
+// closing returns the token that closes node, if it records one.
+func closing(node ast.Node) token.Token {
+	switch node := node.(type) {
+	case *ast.BlockStatement:
+		return node.Rbrace
+	case *ast.CallExpression:
+		return node.Rparen
+	case *ast.ArrayLiteral:
+		return node.Rbracket
+	case *ast.HashLiteral:
+		return node.Rbrace
+	}
+	return token.Token{}
+}
+
+// endCol is the column after s when it is printed starting at col.
+func endCol(col int, s string) int {
+	if i := strings.LastIndex(s, "\n"); i >= 0 {
+		return utf8.RuneCountInString(s[i+1:])
+	}
+	return col + utf8.RuneCountInString(s)
+}
+
+func fits(col int, s string) bool {
+	return !strings.Contains(s, "\n") && col+utf8.RuneCountInString(s) <= maxWidth
+}
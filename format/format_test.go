@@ -0,0 +1,205 @@
+package format
+
+import (
+	"monkey/lexer"
+	"monkey/parser"
+	"strings"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x=1+2*3", "let x = 1 + 2 * 3;\n"},
+		{"let x = (1 + 2) * 3;", "let x = (1 + 2) * 3;\n"},
+		{"((1 - 2)) - 3;", "1 - 2 - 3;\n"},
+		{"1 - (2 - 3);", "1 - (2 - 3);\n"},
+		{"-(a + b); !(-a); (-a)[0];", "-(a + b);\n!-a;\n(-a)[0];\n"},
+		{"(a ?? b)(1); a ?? b ?? c;", "(a ?? b)(1);\na ?? b ?? c;\n"},
+		{"xs[1:]; xs[:2]; 1..<n;", "xs[1:];\nxs[:2];\n1..<n;\n"},
+		{"h?.name; h?.[\"a b\"];", "h?.name;\nh?.[\"a b\"];\n"},
+		{`"hi ${name}!";`, "\"hi ${name}!\";\n"},
+		{"let add = fn(a,b){a+b};", "let add = fn(a, b) { a + b };\n"},
+		{"let n:int=1; let f=fn(a:int,b)->bool{a>b};", "let n: int = 1;\nlet f = fn(a: int, b) -> bool { a > b };\n"},
+		{
+			"let f = fn(x) {\nlet y = x;\n  return y\n}",
+			"let f = fn(x) {\n    let y = x;\n    return y;\n};\n",
+		},
+		{
+			"if (x > 1) { a } else if (x < 0) { b } else { c }",
+			"if (x > 1) { a } else if (x < 0) { b } else { c }\n",
+		},
+		{
+			"if (x) {\nputs(x)\n}\nelse {\n}",
+			"if (x) {\n    puts(x);\n} else {}\n",
+		},
+		{
+			"try { f() } catch (e) {\nputs(e) } finally { g() }",
+			"try { f() } catch (e) {\n    puts(e);\n} finally { g() }\n",
+		},
+		{
+			"match (v) { 0 => \"zero\", [a, ...rest] if a > 1 => { a }, {name: name, age: n} => n, _ => null }",
+			"match (v) {\n    0 => \"zero\",\n    [a, ...rest] if a > 1 => { a },\n    {name, age: n} => n,\n    _ => null\n}\n",
+		},
+		{"let {a, \"b\": [c, d = 1]} = h;", "let {a, \"b\": [c, d = 1]} = h;\n"},
+		{"const x = 1; export let y = 2;", "const x = 1;\nexport let y = 2;\n"},
+		{`import {a, b as c} from "lib.mk"`, "import {a, b as c} from \"lib.mk\";\n"},
+		{
+			"let x = [1000000, 2000000, 3000000, 4000000, 5000000, 6000000, 7000000, 8000000, 9000000];",
+			"let x = [\n    1000000,\n    2000000,\n    3000000,\n    4000000,\n    5000000,\n    6000000,\n    7000000,\n    8000000,\n    9000000\n];\n",
+		},
+		{
+			`puts({"first": "aaaaaaaaaaaaaaaa", "second": "bbbbbbbbbbbbbbbb", "third": "cccccccccc"});`,
+			"puts({\n    \"first\": \"aaaaaaaaaaaaaaaa\",\n    \"second\": \"bbbbbbbbbbbbbbbb\",\n    \"third\": \"cccccccccc\"\n});\n",
+		},
+		{
+			"map(xs, fn(x) {\nx * 2\n});",
+			"map(xs, fn(x) {\n    x * 2;\n});\n",
+		},
+	}
+
+	for _, tt := range tests {
+		formatted, err := Source([]byte(tt.input))
+		if err != nil {
+			t.Errorf("Source(%q) failed: %s", tt.input, err)
+			continue
+		}
+		if string(formatted) != tt.expected {
+			t.Errorf("Source(%q) wrong.\nwant:\n%s\ngot:\n%s", tt.input, tt.expected, formatted)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// header
+
+let a = 1;   // one
+
+
+let f = fn() {
+  // inside
+  let b = 2;
+
+  b
+  // end of body
+};
+let m = match (a) {
+  // first
+  1 => "one", // the one
+  _ => "many"
+};
+// trailing`
+	expected := `// header
+
+let a = 1; // one
+
+let f = fn() {
+    // inside
+    let b = 2;
+
+    b;
+    // end of body
+};
+let m = match (a) {
+    // first
+    1 => "one", // the one
+    _ => "many"
+};
+// trailing
+`
+	formatted, err := Source([]byte(input))
+	if err != nil {
+		t.Fatalf("Source failed: %s", err)
+	}
+	if string(formatted) != expected {
+		t.Errorf("wrong output.\nwant:\n%s\ngot:\n%s", expected, formatted)
+	}
+}
+
+func TestListComments(t *testing.T) {
+	input := `let h = {
+  "a": 1, // first
+  // before b
+  "b": [2,
+    3] // two and three
+};
+puts(1, 2); // flat
+let xs = [
+  1
+  // no more
+]; // after`
+	expected := `let h = {
+    "a": 1, // first
+    // before b
+    "b": [2, 3] // two and three
+};
+puts(1, 2); // flat
+let xs = [
+    1
+    // no more
+]; // after
+`
+	formatted, err := Source([]byte(input))
+	if err != nil {
+		t.Fatalf("Source failed: %s", err)
+	}
+	if string(formatted) != expected {
+		t.Errorf("wrong output.\nwant:\n%s\ngot:\n%s", expected, formatted)
+	}
+	if again, _ := Source(formatted); string(again) != expected {
+		t.Errorf("formatting is not stable. got:\n%s", again)
+	}
+}
+
+func TestSourceParseError(t *testing.T) {
+	input := "let = 5;"
+	formatted, err := Source([]byte(input))
+	if err == nil {
+		t.Fatalf("expected a parse error")
+	}
+	if string(formatted) != input {
+		t.Errorf("source changed on error. got=%q", formatted)
+	}
+}
+
+// TestStable checks that formatting keeps the meaning of a program and
+// that formatted code is left alone by a second pass.
+func TestStable(t *testing.T) {
+	inputs := []string{
+		"let fib = fn(n) { if (n < 2) { return n; } fib(n - 1) + fib(n - 2) }; puts(fib(10));",
+		"let people = [{\"name\": \"Ann\", \"age\": 31}, {\"name\": \"Bob\", \"age\": 27}, {\"name\": \"Cy\", \"age\": 45}];",
+		"let r = try { throw \"x\" } catch { 1 } finally { puts(\"done\") }; defer close(f);",
+		"let [x, ...xs] = 1..10; let s = \"${x}: ${len(xs)}\"; -x - -x * (x - 1) / 2;",
+		"let unless = macro(c, a, b) { quote(if (!(unquote(c))) { unquote(a) } else { unquote(b) }) };",
+		"if (x) { puts(1) };\n[1, 2];",
+		"try { f() } catch (e) { 0 };\n(g)(1);",
+		"let v = 1; match (v) { _ => 1 };\n-1;",
+		"if (x) { puts(1) };\n// comment\n[1, 2];",
+	}
+
+	for _, input := range inputs {
+		first, err := Source([]byte(input))
+		if err != nil {
+			t.Errorf("Source(%q) failed: %s", input, err)
+			continue
+		}
+		second, _ := Source(first)
+		if string(first) != string(second) {
+			t.Errorf("formatting %q is not stable.\nfirst:\n%s\nsecond:\n%s", input, first, second)
+		}
+		if want, got := parse(t, input), parse(t, string(first)); want != got {
+			t.Errorf("formatting %q changed the program.\nwant=%s\ngot=%s", input, want, got)
+		}
+	}
+}
+
+func parse(t *testing.T, input string) string {
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parse errors in %q: %s", input, strings.Join(p.Errors(), "; "))
+	}
+	return program.String()
+}
@@ -1,21 +1,32 @@
 package lexer
 
-import "monkey/token"
+import (
+	"monkey/token"
+	"strings"
+)
 
 type Lexer struct {
 	input        string
 	position     int
 	readPosition int
 	ch           byte
+	line         int
+	column       int
+	comments     []token.Token
 }
 
 func New(s string) *Lexer {
-	l := &Lexer{input: s}
+	l := &Lexer{input: s, line: 1}
 	l.readChar()
 	return l
 }
 
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
+	l.column++
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
@@ -33,16 +44,99 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[p:l.position]
 }
 
-func (l *Lexer) readString() string {
+func (l *Lexer) readString() (string, bool) {
     position := l.position + 1
+    interpolated := false
+    depth := 0
     for {
         l.readChar()
-        if l.ch == '"' || l.ch == 0 {
+        if l.ch == 0 {
             break
         }
+        if depth == 0 {
+            if l.ch == '"' {
+                break
+            }
+            if l.ch == '$' && l.peekChar() == '{' {
+                interpolated = true
+                depth = 1
+                l.readChar()
+            }
+            continue
+        }
+        switch l.ch {
+        case '{':
+            depth++
+        case '}':
+            depth--
+        case '"':
+            l.skipNestedString()
+            if l.ch == 0 {
+                return l.input[position:l.position], interpolated
+            }
+        }
+    }
+
+    return l.input[position:l.position], interpolated
+}
+
+// skipNestedString moves past a string literal that appears inside an
+// interpolation segment so its quotes do not end the enclosing string.
+func (l *Lexer) skipNestedString() {
+    for {
+        l.readChar()
+        if l.ch == '"' || l.ch == 0 {
+            return
+        }
     }
+}
 
-    return l.input[position:l.position]
+// TemplateSegment is a piece of an interpolated string: either literal
+// text or the source of an embedded `${...}` expression.
+type TemplateSegment struct {
+    Text   string
+    IsExpr bool
+}
+
+// SplitTemplate breaks the literal of a TEMPLATE token into its text and
+// expression segments. It reports false if an interpolation is never closed.
+func SplitTemplate(s string) ([]TemplateSegment, bool) {
+    segments := []TemplateSegment{}
+    start := 0
+
+    for i := 0; i < len(s); i++ {
+        if s[i] != '$' || i+1 >= len(s) || s[i+1] != '{' {
+            continue
+        }
+        if i > start {
+            segments = append(segments, TemplateSegment{Text: s[start:i]})
+        }
+
+        depth := 1
+        j := i + 2
+        for ; j < len(s) && depth > 0; j++ {
+            switch s[j] {
+            case '{':
+                depth++
+            case '}':
+                depth--
+            case '"':
+                for j++; j < len(s) && s[j] != '"'; j++ {
+                }
+            }
+        }
+        if depth > 0 {
+            return segments, false
+        }
+        segments = append(segments, TemplateSegment{Text: s[i+2 : j-1], IsExpr: true})
+        start = j
+        i = j - 1
+    }
+    if start < len(s) {
+        segments = append(segments, TemplateSegment{Text: s[start:]})
+    }
+
+    return segments, true
 }
 
 func isLetter(ch byte) bool {
@@ -70,24 +164,61 @@ func (l *Lexer) peekChar() byte {
 }
 
 func (l *Lexer) NextToken() token.Token {
-	var tok token.Token
-	//Remove whitespace
-	for l.ch == ' ' || l.ch == '\n' || l.ch == '\r' || l.ch == '\t' {
-		l.readChar()
+	l.skipWhitespaceAndComments()
+	line, column := l.line, l.column
+	tok := l.nextToken()
+	tok.Line, tok.Column = line, column
+	return tok
+}
+
+// Comments returns the `//` comments read so far, as COMMENT tokens
+// holding the whole comment text.
+func (l *Lexer) Comments() []token.Token {
+	return l.comments
+}
+
+func (l *Lexer) skipWhitespaceAndComments() {
+	for {
+		for l.ch == ' ' || l.ch == '\n' || l.ch == '\r' || l.ch == '\t' {
+			l.readChar()
+		}
+		if l.ch != '/' || l.peekChar() != '/' {
+			return
+		}
+
+		comment := token.Token{Type: token.COMMENT, Line: l.line, Column: l.column}
+		start := l.position
+		for l.ch != '\n' && l.ch != 0 {
+			l.readChar()
+		}
+		comment.Literal = strings.TrimRight(l.input[start:l.position], " \t\r")
+		l.comments = append(l.comments, comment)
 	}
+}
+
+func (l *Lexer) nextToken() token.Token {
+	var tok token.Token
 
 	switch l.ch {
 	case '=':
 		if l.peekChar() == '=' {
 			tok = token.Token{Type: token.EQ, Literal: "=="}
 			l.readChar()
+		} else if l.peekChar() == '>' {
+			tok = token.Token{Type: token.ARROW, Literal: "=>"}
+			l.readChar()
 		} else {
 			tok = newToken(token.ASSIGN, l.ch)
 		}
 	case '+':
 		tok = newToken(token.PLUS, l.ch)
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		if l.peekChar() == '>' {
+			tok = token.Token{Type: token.THIN_ARROW, Literal: "->"}
+			l.readChar()
+		} else {
+			tok = newToken(token.MINUS, l.ch)
+		}
 	case '!':
 		if l.peekChar() == '=' {
 			tok = token.Token{Type: token.NOT_EQ, Literal: "!="}
@@ -103,11 +234,40 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.LT, l.ch)
 	case '>':
 		tok = newToken(token.GT, l.ch)
+	case '.':
+		if l.peekChar() == '.' {
+			l.readChar()
+			if l.peekChar() == '<' {
+				l.readChar()
+				tok = token.Token{Type: token.RANGE_EX, Literal: "..<"}
+			} else if l.peekChar() == '.' {
+				l.readChar()
+				tok = token.Token{Type: token.ELLIPSIS, Literal: "..."}
+			} else {
+				tok = token.Token{Type: token.RANGE, Literal: ".."}
+			}
+		} else {
+			tok = newToken(token.ILLEGAL, l.ch)
+		}
+	case '?':
+		if l.peekChar() == '?' {
+			l.readChar()
+			tok = token.Token{Type: token.NULLISH, Literal: "??"}
+		} else if l.peekChar() == '.' {
+			l.readChar()
+			tok = token.Token{Type: token.OPTIONAL, Literal: "?."}
+		} else {
+			tok = newToken(token.ILLEGAL, l.ch)
+		}
 	case ',':
 		tok = newToken(token.COMMA, l.ch)
     case '"':
+        literal, interpolated := l.readString()
         tok.Type = token.STRING
-        tok.Literal = l.readString()
+        if interpolated {
+            tok.Type = token.TEMPLATE
+        }
+        tok.Literal = literal
 	case ';':
 		tok = newToken(token.SEMICOLON, l.ch)
     case ':':
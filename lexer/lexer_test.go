@@ -131,3 +131,197 @@ func TestNextToken(t *testing.T) {
 		}
 	}
 }
+
+func TestInterpolatedString(t *testing.T) {
+	input := `"plain" "Hi ${user["name"]}, ${len(items)} items" "$5"`
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.STRING, "plain"},
+		{token.TEMPLATE, `Hi ${user["name"]}, ${len(items)} items`},
+		{token.STRING, "$5"},
+		{token.EOF, ""},
+	}
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Errorf("tests[%d] - tokentype wrong expectedType=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Errorf("tests[%d] - tokentype wrong expectedLiteral=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestSplitTemplate(t *testing.T) {
+	segments, ok := SplitTemplate(`Hi ${user["name"]}, ${ {"a": 1}["a"] }!`)
+	if !ok {
+		t.Fatalf("SplitTemplate reported unterminated interpolation")
+	}
+	expected := []TemplateSegment{
+		{Text: "Hi "},
+		{Text: `user["name"]`, IsExpr: true},
+		{Text: ", "},
+		{Text: ` {"a": 1}["a"] `, IsExpr: true},
+		{Text: "!"},
+	}
+	if len(segments) != len(expected) {
+		t.Fatalf("wrong number of segments. want=%d got=%d (%+v)",
+			len(expected), len(segments), segments)
+	}
+	for i, seg := range segments {
+		if seg != expected[i] {
+			t.Errorf("segments[%d] wrong. want=%+v got=%+v", i, expected[i], seg)
+		}
+	}
+
+	if _, ok := SplitTemplate("oops ${x"); ok {
+		t.Errorf("SplitTemplate accepted unterminated interpolation")
+	}
+}
+
+func TestRangeTokens(t *testing.T) {
+	input := `1..10 1..<n .`
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.INT, "1"},
+		{token.RANGE, ".."},
+		{token.INT, "10"},
+		{token.INT, "1"},
+		{token.RANGE_EX, "..<"},
+		{token.IDENT, "n"},
+		{token.ILLEGAL, "."},
+		{token.EOF, ""},
+	}
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Errorf("tests[%d] - tokentype wrong expectedType=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Errorf("tests[%d] - tokentype wrong expectedLiteral=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestNullishTokens(t *testing.T) {
+	input := `a ?? b?.c?.[0] ?`
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "a"},
+		{token.NULLISH, "??"},
+		{token.IDENT, "b"},
+		{token.OPTIONAL, "?."},
+		{token.IDENT, "c"},
+		{token.OPTIONAL, "?."},
+		{token.LBRACKET, "["},
+		{token.INT, "0"},
+		{token.RBRACKET, "]"},
+		{token.ILLEGAL, "?"},
+		{token.EOF, ""},
+	}
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Errorf("tests[%d] - tokentype wrong expectedType=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Errorf("tests[%d] - tokentype wrong expectedLiteral=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestAnnotationTokens(t *testing.T) {
+	input := `fn(x: int) -> bool { x->y - 1 }`
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.FUNC, "fn"},
+		{token.LPAREN, "("},
+		{token.IDENT, "x"},
+		{token.COLON, ":"},
+		{token.IDENT, "int"},
+		{token.RPAREN, ")"},
+		{token.THIN_ARROW, "->"},
+		{token.IDENT, "bool"},
+		{token.LCURLY, "{"},
+		{token.IDENT, "x"},
+		{token.THIN_ARROW, "->"},
+		{token.IDENT, "y"},
+		{token.MINUS, "-"},
+		{token.INT, "1"},
+		{token.RCURLY, "}"},
+		{token.EOF, ""},
+	}
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Errorf("tests[%d] - tokentype wrong expectedType=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Errorf("tests[%d] - tokentype wrong expectedLiteral=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestPositionsAndComments(t *testing.T) {
+	input := "// header\nlet x = 10; // ten\n\n  x / 2 //end"
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedLine    int
+		expectedColumn  int
+	}{
+		{token.LET, "let", 2, 1},
+		{token.IDENT, "x", 2, 5},
+		{token.ASSIGN, "=", 2, 7},
+		{token.INT, "10", 2, 9},
+		{token.SEMICOLON, ";", 2, 11},
+		{token.IDENT, "x", 4, 3},
+		{token.SLASH, "/", 4, 5},
+		{token.INT, "2", 4, 7},
+		{token.EOF, "", 4, 14},
+	}
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token. expected=%q %q, got=%q %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+		if tok.Line != tt.expectedLine || tok.Column != tt.expectedColumn {
+			t.Errorf("tests[%d] - wrong position. expected=%d:%d, got=%d:%d",
+				i, tt.expectedLine, tt.expectedColumn, tok.Line, tok.Column)
+		}
+	}
+
+	expected := []token.Token{
+		{Type: token.COMMENT, Literal: "// header", Line: 1, Column: 1},
+		{Type: token.COMMENT, Literal: "// ten", Line: 2, Column: 13},
+		{Type: token.COMMENT, Literal: "//end", Line: 4, Column: 9},
+	}
+	comments := l.Comments()
+	if len(comments) != len(expected) {
+		t.Fatalf("wrong number of comments. want=%d got=%d", len(expected), len(comments))
+	}
+	for i, comment := range comments {
+		if comment != expected[i] {
+			t.Errorf("comments[%d] wrong. want=%+v got=%+v", i, expected[i], comment)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+// Package lint reports likely mistakes in Monkey programs without running
+// them: unused and shadowing bindings, undefined names, unreachable code,
+// calls with the wrong number of arguments, if conditions that never
+// change and the type errors eval.TypeCheck finds.
+package lint
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/eval"
+	"monkey/object"
+	"monkey/token"
+	"sort"
+	"strings"
+)
+
+// Diagnostic is a problem found at a source position.
+type Diagnostic struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%d:%d: %s", d.Line, d.Column, d.Message)
+}
+
+// Check returns the problems in program, ordered by position. Names are
+// resolved by eval.Analyze, so lint sees the scopes the evaluator creates.
+func Check(program *ast.Program) []Diagnostic {
+	c := &checker{
+		analysis: eval.Analyze(program),
+		within:   map[ast.Node]token.Token{},
+		lets:     map[*ast.Identifier]bool{},
+		exported: map[*ast.Identifier]bool{},
+		arity:    map[*ast.Identifier]int{},
+	}
+	ast.Inspect(program, c.collect)
+	c.names()
+	ast.Inspect(program, c.visit)
+	for _, err := range eval.TypeCheck(program, object.NewEnvironment()) {
+		c.diagnostics = append(c.diagnostics, Diagnostic{Line: err.Line, Column: err.Column, Message: err.Message})
+	}
+
+	sort.SliceStable(c.diagnostics, func(i, j int) bool {
+		a, b := c.diagnostics[i], c.diagnostics[j]
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return c.diagnostics
+}
+
+type checker struct {
+	analysis    *eval.Analysis
+	diagnostics []Diagnostic
+	// within maps the nodes inside an interpolated string to the string's
+	// token. Expressions in an interpolation are parsed separately and
+	// carry positions relative to it, so diagnostics there point at the
+	// string instead.
+	within map[ast.Node]token.Token
+	// lets holds the names let and const declare, the only ones reported
+	// when unused, and exported those of them a module exports.
+	lets, exported map[*ast.Identifier]bool
+	// arity holds the parameter count of each name declared to a function
+	// literal.
+	arity map[*ast.Identifier]int
+}
+
+func (c *checker) report(node ast.Node, tok token.Token, format string, a ...interface{}) {
+	if outer, ok := c.within[node]; ok {
+		tok = outer
+	}
+	c.diagnostics = append(c.diagnostics, Diagnostic{tok.Line, tok.Column, fmt.Sprintf(format, a...)})
+}
+
+// collect notes the let declarations, the function arities and the nodes
+// inside interpolated strings.
+func (c *checker) collect(node ast.Node) bool {
+	switch node := node.(type) {
+	case *ast.ExportStatement:
+		for _, name := range node.Statement.Names() {
+			c.exported[name] = true
+		}
+	case *ast.LetStatement:
+		for _, name := range node.Names() {
+			c.lets[name] = true
+		}
+		if fn, ok := node.Value.(*ast.FunctionLiteral); ok && node.Pattern == nil {
+			c.arity[node.Bind] = len(fn.Parameters)
+		}
+	case *ast.InterpolatedString:
+		for _, part := range node.Parts {
+			ast.Inspect(part, func(inner ast.Node) bool {
+				if inner != nil {
+					c.within[inner] = node.Token
+				}
+				return c.collect(inner)
+			})
+		}
+		return false
+	}
+	return true
+}
+
+// names reports the undefined names, the declarations that hide another
+// or a builtin, and the let and const declarations nothing refers to.
+func (c *checker) names() {
+	used := map[*ast.Identifier]bool{}
+	for ident, decl := range c.analysis.Definitions {
+		if ident != decl {
+			used[decl] = true
+		}
+	}
+
+	for _, decl := range c.analysis.Declarations {
+		name := decl.Name
+		switch {
+		case decl.Hides != nil:
+			c.report(name, name.Token, "%s shadows the declaration on line %d", name.Value, decl.Hides.Token.Line)
+		case decl.Replaces == nil && c.lets[name] && eval.IsBuiltin(name.Value):
+			c.report(name, name.Token, "%s shadows a builtin", name.Value)
+		}
+		if c.lets[name] && !c.exported[name] && !used[name] && !strings.HasPrefix(name.Value, "_") {
+			c.report(name, name.Token, "%s declared and not used", name.Value)
+		}
+	}
+
+	for _, ident := range c.analysis.Undefined {
+		c.report(ident, ident.Token, "undefined identifier %s", ident.Value)
+	}
+}
+
+// visit reports unreachable code, if conditions that never change and
+// calls with the wrong number of arguments. Quoted code is skipped.
+func (c *checker) visit(node ast.Node) bool {
+	switch node := node.(type) {
+	case *ast.Program:
+		c.statements(node.Statements)
+	case *ast.BlockStatement:
+		c.statements(node.Statements)
+	case *ast.IfExpression:
+		if truthy, ok := constantTruth(node.Condition); ok {
+			c.report(node, node.Token, "if condition is always %t", truthy)
+		}
+	case *ast.CallExpression:
+		ident, ok := node.Function.(*ast.Identifier)
+		if !ok {
+			break
+		}
+		if ident.Value == "quote" {
+			return false
+		}
+		arity, ok := c.arity[c.analysis.Definitions[ident]]
+		if ok && arity != len(node.Arguments) {
+			c.report(ident, ident.Token, "wrong number of arguments to %s, want=%d got=%d",
+				ident.Value, arity, len(node.Arguments))
+		}
+	}
+	return true
+}
+
+// statements reports the first statement that follows a return or throw.
+func (c *checker) statements(stmts []ast.Statement) {
+	for i := 0; i+1 < len(stmts); i++ {
+		switch stmts[i].(type) {
+		case *ast.ReturnStatement, *ast.ThrowStatement:
+			c.report(stmts[i+1], tokenOf(stmts[i+1]), "unreachable code")
+			return
+		}
+	}
+}
+
+// constantTruth reports whether exp always has the same truthiness, and
+// which.
+func constantTruth(exp ast.Expression) (bool, bool) {
+	switch exp.(type) {
+	case *ast.ArrayLiteral, *ast.HashLiteral, *ast.FunctionLiteral:
+		return true, true
+	}
+	switch value := constant(exp).(type) {
+	case bool:
+		return value, true
+	case int64:
+		return value != 0, true
+	case string:
+		return value != "", true
+	}
+	return false, false
+}
+
+// constant evaluates an expression built only from literals and returns
+// its value as a bool, int64 or string, or nil if it is not constant.
+func constant(exp ast.Expression) interface{} {
+	switch exp := exp.(type) {
+	case *ast.Boolean:
+		return exp.Value
+	case *ast.IntegerLiteral:
+		return exp.Value
+	case *ast.StringLiteral:
+		return exp.Value
+	case *ast.PrefixExpression:
+		on := constant(exp.On)
+		switch {
+		case on == nil:
+			return nil
+		case exp.Operator == "!":
+			truthy, _ := constantTruth(exp.On)
+			return !truthy
+		case exp.Operator == "-":
+			if n, ok := on.(int64); ok {
+				return -n
+			}
+		}
+	case *ast.InfixExpression:
+		left, right := constant(exp.Left), constant(exp.Right)
+		if left == nil || right == nil {
+			return nil
+		}
+		switch exp.Operator {
+		case "==":
+			return left == right
+		case "!=":
+			return left != right
+		case "??":
+			return left
+		}
+		l, lok := left.(int64)
+		r, rok := right.(int64)
+		if !lok || !rok {
+			return nil
+		}
+		switch exp.Operator {
+		case "+":
+			return l + r
+		case "-":
+			return l - r
+		case "*":
+			return l * r
+		case "/":
+			if r != 0 {
+				return l / r
+			}
+		case "<":
+			return l < r
+		case ">":
+			return l > r
+		}
+	}
+	return nil
+}
+
+func tokenOf(stmt ast.Statement) token.Token {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		return stmt.Token
+	case *ast.ReturnStatement:
+		return stmt.Token
+	case *ast.ThrowStatement:
+		return stmt.Token
+	case *ast.DeferStatement:
+		return stmt.Token
+	case *ast.ImportStatement:
+		return stmt.Token
+	case *ast.ExportStatement:
+		return stmt.Token
+	case *ast.ExpressionStatement:
+		return stmt.Token
+	case *ast.BlockStatement:
+		return stmt.Token
+	}
+	return token.Token{}
+}
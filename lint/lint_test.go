@@ -0,0 +1,83 @@
+package lint
+
+import (
+	"monkey/lexer"
+	"monkey/parser"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"let x = 1; puts(x);", nil},
+		{"let x = 1;", []string{"1:5: x declared and not used"}},
+		{"let _x = 1; export let y = 2;", nil},
+		{"let [a, b] = [1, 2]; a;", []string{"1:9: b declared and not used"}},
+		{"let x = 1; let x = 2; x;", []string{"1:5: x declared and not used"}},
+		{
+			"let x = 1; let f = fn(x) { x }; f(x);",
+			[]string{"1:23: x shadows the declaration on line 1"},
+		},
+		{
+			"let x = 1; if (x > 0) { let x = 2; puts(x) }",
+			[]string{"1:29: x shadows the declaration on line 1"},
+		},
+		{"let len = fn(s) { s }; len(1);", []string{"1:5: len shadows a builtin"}},
+		{"match ([1]) { [h, ...rest] => h };", nil},
+		{"puts(y);", []string{"1:6: undefined identifier y"}},
+		{`let s = "a"; puts(s - 1);`, []string{"1:21: type mismatch: STRING - INTEGER"}},
+		{"let f = fn(n: int) { n }; f(true);", []string{"1:29: cannot use BOOLEAN as int in argument n to f"}},
+		{`puts("${y}");`, []string{"1:6: undefined identifier y"}},
+		{"let f = fn() { g() }; let g = fn() { 1 }; f();", nil},
+		{"let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } }; fact(5);", nil},
+		{"if (true) { let y = 1; } puts(y);", []string{
+			"1:1: if condition is always true",
+			"1:17: y declared and not used",
+			"1:31: undefined identifier y",
+		}},
+		{"try { throw 1 } catch (e) { puts(e) } puts(e);", []string{"1:44: undefined identifier e"}},
+		{
+			"let f = fn(x) {\n  return x;\n  puts(x);\n  puts(x);\n}; f(1);",
+			[]string{"3:3: unreachable code"},
+		},
+		{"let f = fn() { throw \"no\"; 1 }; f();", []string{"1:28: unreachable code"}},
+		{
+			"let add = fn(a, b) { a + b }; add(1); add(1, 2, 3); add(1, 2);",
+			[]string{
+				"1:31: wrong number of arguments to add, want=2 got=1",
+				"1:39: wrong number of arguments to add, want=2 got=3",
+			},
+		},
+		{"let f = fn(g) { g(1, 2) }; f(len);", nil},
+		{"if (1 + 1 == 2) { 1 }", []string{"1:1: if condition is always true"}},
+		{"if (!\"\") { 1 } else if (0) { 2 }", []string{
+			"1:1: if condition is always true",
+			"1:21: if condition is always false",
+		}},
+		{"let x = 1; if (x == 1) { 1 }", nil},
+		{
+			"let unless = macro(c, a) { quote(if (!(unquote(c))) { unquote(a) }) }; unless(false, 1);",
+			nil,
+		},
+		{`import {a, b as c} from "lib.mk"; import "m.mk" as m; puts(a);`, nil},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Fatalf("parse errors in %q: %s", tt.input, strings.Join(p.Errors(), "; "))
+		}
+
+		got := []string{}
+		for _, d := range Check(program) {
+			got = append(got, d.String())
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.expected, "\n") {
+			t.Errorf("Check(%q) wrong.\nwant=%q\ngot=%q", tt.input, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,492 @@
+package lsp
+
+import (
+	"monkey/ast"
+	"monkey/eval"
+	"monkey/lexer"
+	"monkey/lint"
+	"monkey/parser"
+	"monkey/token"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// document is an open file and what the server knows about it, worked
+// out again whenever its text changes.
+type document struct {
+	uri   string
+	text  string
+	lines []string
+
+	program     *ast.Program
+	diagnostics []diagnostic
+	// idents are the identifiers found in the text, in source order, and
+	// definitions maps each one that refers to a variable to the
+	// identifier declaring it.
+	idents       []*ast.Identifier
+	definitions  map[*ast.Identifier]*ast.Identifier
+	declarations map[*ast.Identifier]*declaration
+}
+
+// declaration is a name bound in the document.
+type declaration struct {
+	name *ast.Identifier
+	// detail describes the binding, e.g. `let add = fn(a, b)`.
+	detail string
+	kind   int
+	// scope is where the name is visible; an end with line 0 is the end of
+	// the document.
+	scope [2]token.Token
+}
+
+func newDocument(uri, text string) *document {
+	d := &document{uri: uri, text: text, lines: strings.Split(text, "\n")}
+
+	p := parser.New(lexer.New(text))
+	d.program = p.ParseProgram()
+	positions := p.ErrorPositions()
+	for i, msg := range p.Errors() {
+		d.diagnostics = append(d.diagnostics, diagnostic{
+			Range:    d.tokenRange(positions[i]),
+			Severity: severityError,
+			Source:   "monkey",
+			Message:  msg,
+		})
+	}
+	if len(d.diagnostics) == 0 {
+		for _, problem := range lint.Check(d.program) {
+			start := d.position(problem.Line, problem.Column)
+			d.diagnostics = append(d.diagnostics, diagnostic{
+				Range:    textRange{Start: start, End: d.wordEnd(problem.Line, problem.Column)},
+				Severity: severityWarning,
+				Source:   "monkey lint",
+				Message:  problem.Message,
+			})
+		}
+	}
+
+	d.definitions = eval.Definitions(d.program)
+	c := &declarationCollector{doc: d, declarations: map[*ast.Identifier]*declaration{}, seen: map[*ast.Identifier]bool{}}
+	c.scopes = append(c.scopes, [2]token.Token{})
+	ast.Walk(c, d.program)
+	d.declarations = c.declarations
+	return d
+}
+
+// located reports whether ident is at its token's position in the text.
+// The parser places identifiers inside string interpolations relative to
+// the interpolation, and those are left out.
+func (d *document) located(ident *ast.Identifier) bool {
+	line, col := ident.Token.Line, ident.Token.Column
+	if line < 1 || line > len(d.lines) || col < 1 || col > len(d.lines[line-1]) {
+		return false
+	}
+	return strings.HasPrefix(d.lines[line-1][col-1:], ident.Value)
+}
+
+// position converts a 1-based line and byte column to an LSP position.
+func (d *document) position(line, col int) position {
+	if line < 1 {
+		return position{}
+	}
+	if line > len(d.lines) {
+		return position{Line: len(d.lines) - 1, Character: utf16Len(d.lines[len(d.lines)-1])}
+	}
+	text := d.lines[line-1]
+	if col < 1 {
+		col = 1
+	}
+	if col-1 > len(text) {
+		col = len(text) + 1
+	}
+	return position{Line: line - 1, Character: utf16Len(text[:col-1])}
+}
+
+// offset converts an LSP position to a 1-based line and byte column.
+func (d *document) offset(pos position) (line, col int) {
+	if pos.Line < 0 || pos.Line >= len(d.lines) {
+		return 0, 0
+	}
+	text := d.lines[pos.Line]
+	units := 0
+	for i, r := range text {
+		if units >= pos.Character {
+			return pos.Line + 1, i + 1
+		}
+		units += utf16RuneLen(r)
+	}
+	return pos.Line + 1, len(text) + 1
+}
+
+func (d *document) end() position {
+	return d.position(len(d.lines), len(d.lines[len(d.lines)-1])+1)
+}
+
+// tokenRange is the range tok covers, or at least one character.
+func (d *document) tokenRange(tok token.Token) textRange {
+	start := d.position(tok.Line, tok.Column)
+	length := len(tok.Literal)
+	if length == 0 || tok.Type == token.EOF {
+		length = 1
+	}
+	return textRange{Start: start, End: d.position(tok.Line, tok.Column+length)}
+}
+
+// wordEnd is the end of the identifier or number starting at line and col,
+// or the next character if there is none.
+func (d *document) wordEnd(line, col int) position {
+	if line >= 1 && line <= len(d.lines) && col >= 1 {
+		text := d.lines[line-1]
+		end := col - 1
+		for end < len(text) && isWordByte(text[end]) {
+			end++
+		}
+		if end > col-1 {
+			return d.position(line, end+1)
+		}
+	}
+	return d.position(line, col+1)
+}
+
+func isWordByte(b byte) bool {
+	return 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || '0' <= b && b <= '9' || b == '_'
+}
+
+func (d *document) identRange(ident *ast.Identifier) textRange {
+	return textRange{
+		Start: d.position(ident.Token.Line, ident.Token.Column),
+		End:   d.position(ident.Token.Line, ident.Token.Column+len(ident.Value)),
+	}
+}
+
+func (d *document) location(ident *ast.Identifier) location {
+	return location{URI: d.uri, Range: d.identRange(ident)}
+}
+
+// identAt returns the identifier under or just before pos.
+func (d *document) identAt(pos position) *ast.Identifier {
+	line, col := d.offset(pos)
+	for _, ident := range d.idents {
+		if ident.Token.Line == line && ident.Token.Column <= col && col <= ident.Token.Column+len(ident.Value) {
+			return ident
+		}
+	}
+	return nil
+}
+
+func (d *document) hover(pos position) *hover {
+	ident := d.identAt(pos)
+	if ident == nil {
+		return nil
+	}
+	var text string
+	if decl, ok := d.declarations[d.definitions[ident]]; ok {
+		text = decl.detail
+	} else if _, ok := d.definitions[ident]; !ok && eval.IsBuiltin(ident.Value) {
+		text = "builtin " + eval.BuiltinSignature(ident.Value)
+	} else {
+		return nil
+	}
+	return &hover{
+		Contents: markupContent{Kind: "markdown", Value: "```monkey\n" + text + "\n```"},
+		Range:    d.identRange(ident),
+	}
+}
+
+func (d *document) definition(pos position) *location {
+	ident := d.identAt(pos)
+	if ident == nil {
+		return nil
+	}
+	decl, ok := d.definitions[ident]
+	if !ok || !d.located(decl) {
+		return nil
+	}
+	loc := d.location(decl)
+	return &loc
+}
+
+func (d *document) references(pos position, includeDeclaration bool) []location {
+	locations := []location{}
+	ident := d.identAt(pos)
+	if ident == nil {
+		return locations
+	}
+	decl, ok := d.definitions[ident]
+	if !ok {
+		return locations
+	}
+	for _, other := range d.idents {
+		if d.definitions[other] != decl || other == decl && !includeDeclaration {
+			continue
+		}
+		locations = append(locations, d.location(other))
+	}
+	return locations
+}
+
+// symbols lists the names declared at the top level, with those declared
+// at the top of a function's body as its children.
+func (d *document) symbols() []documentSymbol {
+	return d.statementSymbols(d.program.Statements)
+}
+
+func (d *document) statementSymbols(stmts []ast.Statement) []documentSymbol {
+	symbols := []documentSymbol{}
+	for _, stmt := range stmts {
+		if export, ok := stmt.(*ast.ExportStatement); ok {
+			stmt = export.Statement
+		}
+		switch stmt := stmt.(type) {
+		case *ast.LetStatement:
+			for _, name := range stmt.Names() {
+				symbol, ok := d.symbol(name, stmt.Token)
+				if !ok {
+					continue
+				}
+				if fn, ok := stmt.Value.(*ast.FunctionLiteral); ok && fn != nil && stmt.Pattern == nil && fn.Body != nil {
+					symbol.Range.End = d.position(fn.Body.Rbrace.Line, fn.Body.Rbrace.Column+1)
+					symbol.Children = d.statementSymbols(fn.Body.Statements)
+				}
+				symbols = append(symbols, symbol)
+			}
+		case *ast.ImportStatement:
+			names := append([]*ast.Identifier{}, stmt.Bindings...)
+			if stmt.Alias != nil {
+				names = append(names, stmt.Alias)
+			}
+			for _, name := range names {
+				if symbol, ok := d.symbol(name, stmt.Token); ok {
+					symbols = append(symbols, symbol)
+				}
+			}
+		}
+	}
+	return symbols
+}
+
+func (d *document) symbol(name *ast.Identifier, start token.Token) (documentSymbol, bool) {
+	decl, ok := d.declarations[name]
+	if !ok {
+		return documentSymbol{}, false
+	}
+	selection := d.identRange(name)
+	return documentSymbol{
+		Name:           name.Value,
+		Detail:         decl.detail,
+		Kind:           decl.kind,
+		Range:          textRange{Start: d.position(start.Line, start.Column), End: selection.End},
+		SelectionRange: selection,
+	}, true
+}
+
+// completions offers the names visible at pos, then the builtins not
+// shadowed by one of them.
+func (d *document) completions(pos position) []completionItem {
+	line, col := d.offset(pos)
+	items := []completionItem{}
+	seen := map[string]bool{}
+
+	decls := make([]*declaration, 0, len(d.declarations))
+	for _, decl := range d.declarations {
+		decls = append(decls, decl)
+	}
+	sort.Slice(decls, func(i, j int) bool {
+		a, b := decls[i].name.Token, decls[j].name.Token
+		return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+	})
+	for _, decl := range decls {
+		if seen[decl.name.Value] || !decl.visibleAt(line, col) {
+			continue
+		}
+		seen[decl.name.Value] = true
+		items = append(items, completionItem{Label: decl.name.Value, Kind: completionKind(decl.kind), Detail: decl.detail})
+	}
+	for _, name := range eval.BuiltinNames() {
+		if !seen[name] {
+			items = append(items, completionItem{Label: name, Kind: completionFunction, Detail: eval.BuiltinSignature(name)})
+		}
+	}
+	return items
+}
+
+func completionKind(symbolKind int) int {
+	switch symbolKind {
+	case symbolFunction:
+		return completionFunction
+	case symbolConstant:
+		return completionConstant
+	case symbolModule:
+		return completionModule
+	default:
+		return completionVariable
+	}
+}
+
+func (decl *declaration) visibleAt(line, col int) bool {
+	start, end := decl.scope[0], decl.scope[1]
+	if start.Line > 0 && (line < start.Line || line == start.Line && col <= start.Column) {
+		return false
+	}
+	if end.Line > 0 && (line > end.Line || line == end.Line && col > end.Column) {
+		return false
+	}
+	return true
+}
+
+// declarationCollector walks a program recording its identifiers and
+// declarations, keeping a stack of the scopes it is inside.
+type declarationCollector struct {
+	doc          *document
+	declarations map[*ast.Identifier]*declaration
+	seen         map[*ast.Identifier]bool
+	scopes       [][2]token.Token
+	exported     map[*ast.LetStatement]bool
+}
+
+func (c *declarationCollector) Visit(node ast.Node) ast.Visitor {
+	if node == nil {
+		c.scopes = c.scopes[:len(c.scopes)-1]
+		return nil
+	}
+	scope := c.scopes[len(c.scopes)-1]
+
+	switch node := node.(type) {
+	case *ast.Identifier:
+		if !c.seen[node] && c.doc.located(node) {
+			c.seen[node] = true
+			c.doc.idents = append(c.doc.idents, node)
+		}
+	case *ast.BlockStatement:
+		if node.Rbrace.Line > 0 {
+			scope = blockScope(node)
+		}
+	case *ast.ExportStatement:
+		if c.exported == nil {
+			c.exported = map[*ast.LetStatement]bool{}
+		}
+		c.exported[node.Statement] = true
+	case *ast.LetStatement:
+		c.let(node, scope)
+	case *ast.ImportStatement:
+		if node.Alias != nil {
+			c.declare(node.Alias, "import "+strconv.Quote(node.Path.Value)+" as "+node.Alias.Value, symbolModule, scope)
+		}
+		for i, name := range node.Bindings {
+			detail := "import {" + node.Names[i].Value + "} from " + strconv.Quote(node.Path.Value)
+			if node.Names[i].Value != name.Value {
+				detail = "import {" + node.Names[i].Value + " as " + name.Value + "} from " + strconv.Quote(node.Path.Value)
+			}
+			c.declare(name, detail, symbolVariable, scope)
+		}
+	case *ast.FunctionLiteral:
+		paramScope := scope
+		if node.Body != nil && node.Body.Rbrace.Line > 0 {
+			paramScope = blockScope(node.Body)
+		}
+		for _, param := range node.Parameters {
+			c.declare(param, "parameter "+param.String(), symbolVariable, paramScope)
+		}
+	case *ast.TryExpression:
+		if node.Param != nil {
+			catchScope := scope
+			if node.Catch != nil && node.Catch.Rbrace.Line > 0 {
+				catchScope = blockScope(node.Catch)
+			}
+			c.declare(node.Param, "catch ("+node.Param.Value+")", symbolVariable, catchScope)
+		}
+	case *ast.MatchExpression:
+		for _, arm := range node.Arms {
+			for _, name := range ast.PatternNames(arm.Pattern) {
+				c.declare(name, "match "+arm.Pattern.String(), symbolVariable, scope)
+			}
+		}
+	}
+
+	c.scopes = append(c.scopes, scope)
+	return c
+}
+
+func blockScope(block *ast.BlockStatement) [2]token.Token {
+	return [2]token.Token{block.Token, block.Rbrace}
+}
+
+func (c *declarationCollector) let(stmt *ast.LetStatement, scope [2]token.Token) {
+	keyword, kind := "let", symbolVariable
+	if stmt.IsConst() {
+		keyword, kind = "const", symbolConstant
+	}
+	if c.exported[stmt] {
+		keyword = "export " + keyword
+	}
+	if stmt.Pattern != nil {
+		for _, name := range ast.PatternNames(stmt.Pattern) {
+			c.declare(name, keyword+" "+name.String()+" from "+stmt.Pattern.String(), kind, scope)
+		}
+		return
+	}
+
+	if stmt.Bind == nil {
+		return
+	}
+	detail := keyword + " " + stmt.Bind.String()
+	switch value := stmt.Value.(type) {
+	case *ast.FunctionLiteral:
+		if value == nil {
+			break
+		}
+		kind = symbolFunction
+		detail += " = " + signature(value)
+	case *ast.MacroLiteral:
+		if value == nil {
+			break
+		}
+		kind = symbolFunction
+		params := []string{}
+		for _, param := range value.Parameters {
+			params = append(params, param.String())
+		}
+		detail += " = macro(" + strings.Join(params, ", ") + ")"
+	case *ast.IntegerLiteral, *ast.Boolean:
+		detail += " = " + value.String()
+	case *ast.StringLiteral:
+		detail += " = " + strconv.Quote(value.Value)
+	}
+	c.declare(stmt.Bind, detail, kind, scope)
+}
+
+func signature(fn *ast.FunctionLiteral) string {
+	params := []string{}
+	for _, param := range fn.Parameters {
+		params = append(params, param.String())
+	}
+	sig := "fn(" + strings.Join(params, ", ") + ")"
+	if fn.ReturnType != nil {
+		sig += " -> " + fn.ReturnType.String()
+	}
+	return sig
+}
+
+func (c *declarationCollector) declare(name *ast.Identifier, detail string, kind int, scope [2]token.Token) {
+	if name == nil || !c.doc.located(name) {
+		return
+	}
+	c.declarations[name] = &declaration{name: name, detail: detail, kind: kind, scope: scope}
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16RuneLen(r)
+	}
+	return n
+}
+
+func utf16RuneLen(r rune) int {
+	if r >= 0x10000 && r <= utf8.MaxRune {
+		return 2
+	}
+	return 1
+}
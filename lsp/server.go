@@ -0,0 +1,240 @@
+// Package lsp implements a Language Server Protocol server for Monkey,
+// so editors can show parse errors and lint warnings as you type, hover
+// over names for their definitions and builtin signatures, jump to
+// definitions and references, list a file's symbols, complete names and
+// format files.
+//
+// The server keeps the text of the documents the editor opens and
+// analyzes each one on its own: imports are not followed.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"monkey/format"
+)
+
+// Server answers LSP requests read from in, writing responses and
+// notifications to out.
+type Server struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	docs        map[string]*document
+	initialized bool
+	shutdown    bool
+}
+
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{in: bufio.NewReader(in), out: out, docs: map[string]*document{}}
+}
+
+// Serve handles messages until the client sends exit. It fails if the
+// client exits or disconnects without asking the server to shut down
+// first, or a message cannot be read or written.
+func (s *Server) Serve() error {
+	for {
+		msg, err := readMessage(s.in)
+		if err == io.EOF {
+			if s.shutdown {
+				return nil
+			}
+			return errors.New("connection closed before shutdown")
+		}
+		var rpcErr *responseError
+		if errors.As(err, &rpcErr) {
+			if err := s.reply(msg, nil, rpcErr); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return errors.New("exit before shutdown")
+			}
+			return nil
+		}
+		if err := s.handle(msg); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) handle(msg *message) error {
+	if !msg.isRequest() {
+		return s.notification(msg)
+	}
+
+	var result interface{}
+	var rpcErr *responseError
+	switch {
+	case s.shutdown:
+		rpcErr = &responseError{Code: codeInvalidRequest, Message: "server is shut down"}
+	case msg.Method == "initialize":
+		s.initialized = true
+		result = map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":           1, // full text on every change
+				"hoverProvider":              true,
+				"definitionProvider":         true,
+				"referencesProvider":         true,
+				"documentSymbolProvider":     true,
+				"completionProvider":         map[string]interface{}{},
+				"documentFormattingProvider": true,
+			},
+			"serverInfo": map[string]string{"name": "monkey"},
+		}
+	case !s.initialized:
+		rpcErr = &responseError{Code: codeNotInitialized, Message: "server not initialized"}
+	case msg.Method == "shutdown":
+		s.shutdown = true
+	default:
+		result, rpcErr = s.request(msg)
+	}
+	return s.reply(msg, result, rpcErr)
+}
+
+func (s *Server) request(msg *message) (interface{}, *responseError) {
+	switch msg.Method {
+	case "textDocument/hover":
+		var params positionParams
+		doc, err := s.document(msg.Params, &params, &params.TextDocument)
+		if err != nil {
+			return nil, err
+		}
+		return doc.hover(params.Position), nil
+	case "textDocument/definition":
+		var params positionParams
+		doc, err := s.document(msg.Params, &params, &params.TextDocument)
+		if err != nil {
+			return nil, err
+		}
+		return doc.definition(params.Position), nil
+	case "textDocument/references":
+		var params referenceParams
+		doc, err := s.document(msg.Params, &params, &params.TextDocument)
+		if err != nil {
+			return nil, err
+		}
+		return doc.references(params.Position, params.Context.IncludeDeclaration), nil
+	case "textDocument/documentSymbol":
+		var params documentParams
+		doc, err := s.document(msg.Params, &params, &params.TextDocument)
+		if err != nil {
+			return nil, err
+		}
+		return doc.symbols(), nil
+	case "textDocument/completion":
+		var params positionParams
+		doc, err := s.document(msg.Params, &params, &params.TextDocument)
+		if err != nil {
+			return nil, err
+		}
+		return doc.completions(params.Position), nil
+	case "textDocument/formatting":
+		var params documentParams
+		doc, err := s.document(msg.Params, &params, &params.TextDocument)
+		if err != nil {
+			return nil, err
+		}
+		formatted, ferr := format.Source([]byte(doc.text))
+		if ferr != nil {
+			// The parse errors are already shown as diagnostics.
+			return nil, nil
+		}
+		edits := []textEdit{}
+		if string(formatted) != doc.text {
+			edits = append(edits, textEdit{
+				Range:   textRange{End: doc.end()},
+				NewText: string(formatted),
+			})
+		}
+		return edits, nil
+	default:
+		return nil, &responseError{Code: codeMethodNotFound, Message: fmt.Sprintf("method not found: %s", msg.Method)}
+	}
+}
+
+// document decodes raw into params and returns the open document id
+// names.
+func (s *Server) document(raw json.RawMessage, params interface{}, id *textDocumentIdentifier) (*document, *responseError) {
+	if err := json.Unmarshal(raw, params); err != nil {
+		return nil, &responseError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	doc, ok := s.docs[id.URI]
+	if !ok {
+		return nil, &responseError{Code: codeInvalidParams, Message: fmt.Sprintf("document not open: %s", id.URI)}
+	}
+	return doc, nil
+}
+
+// notification handles a message that needs no response. Unknown ones
+// are ignored, as the protocol asks.
+func (s *Server) notification(msg *message) error {
+	if !s.initialized || s.shutdown {
+		return nil
+	}
+	switch msg.Method {
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if json.Unmarshal(msg.Params, &params) == nil {
+			return s.open(params.TextDocument.URI, params.TextDocument.Text)
+		}
+	case "textDocument/didChange":
+		var params didChangeParams
+		if json.Unmarshal(msg.Params, &params) == nil && len(params.ContentChanges) > 0 {
+			return s.open(params.TextDocument.URI, params.ContentChanges[len(params.ContentChanges)-1].Text)
+		}
+	case "textDocument/didClose":
+		var params documentParams
+		if json.Unmarshal(msg.Params, &params) == nil {
+			delete(s.docs, params.TextDocument.URI)
+			return s.publish(params.TextDocument.URI, []diagnostic{})
+		}
+	}
+	return nil
+}
+
+// open analyzes the text of the document at uri and publishes its
+// diagnostics.
+func (s *Server) open(uri, text string) error {
+	doc := newDocument(uri, text)
+	s.docs[uri] = doc
+	diagnostics := doc.diagnostics
+	if diagnostics == nil {
+		diagnostics = []diagnostic{}
+	}
+	return s.publish(uri, diagnostics)
+}
+
+func (s *Server) publish(uri string, diagnostics []diagnostic) error {
+	params, err := json.Marshal(publishDiagnosticsParams{URI: uri, Diagnostics: diagnostics})
+	if err != nil {
+		return err
+	}
+	return writeMessage(s.out, &message{JSONRPC: "2.0", Method: "textDocument/publishDiagnostics", Params: params})
+}
+
+func (s *Server) reply(msg *message, result interface{}, rpcErr *responseError) error {
+	response := &message{JSONRPC: "2.0", ID: msg.ID}
+	if len(response.ID) == 0 {
+		response.ID = json.RawMessage("null")
+	}
+	if rpcErr != nil {
+		response.Error = rpcErr
+	} else {
+		raw, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		response.Result = raw
+	}
+	return writeMessage(s.out, response)
+}
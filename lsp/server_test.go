@@ -0,0 +1,266 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+const testSource = `let day = 60 * 60 * 24;
+let add = fn(a: int, b) -> int {
+    let total = a + b;
+    total
+};
+const name = "monkey";
+add(day, len(name));`
+
+// runSession sends the messages, each a JSON object without "jsonrpc",
+// to a new server and returns what it wrote back: the responses by id and
+// the notifications in order.
+func runSession(t *testing.T, msgs ...string) (map[string]*message, []*message) {
+	t.Helper()
+	var in bytes.Buffer
+	for _, msg := range msgs {
+		body := `{"jsonrpc": "2.0", ` + strings.TrimPrefix(msg, "{")
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	}
+	var out bytes.Buffer
+	if err := NewServer(&in, &out).Serve(); err != nil {
+		t.Fatalf("Serve failed: %s", err)
+	}
+
+	responses := map[string]*message{}
+	var notifications []*message
+	r := bufio.NewReader(&out)
+	for {
+		msg, err := readMessage(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("bad message from server: %s", err)
+		}
+		if msg.Method != "" {
+			notifications = append(notifications, msg)
+		} else {
+			responses[string(msg.ID)] = msg
+		}
+	}
+	return responses, notifications
+}
+
+func openDocument(uri, text string) string {
+	item, _ := json.Marshal(map[string]interface{}{"uri": uri, "languageId": "monkey", "version": 1, "text": text})
+	return `{"method": "textDocument/didOpen", "params": {"textDocument": ` + string(item) + `}}`
+}
+
+func positionRequest(id int, method string, line, character int) string {
+	return fmt.Sprintf(`{"id": %d, "method": %q, "params": {"textDocument": {"uri": "file:///main.mk"}, "position": {"line": %d, "character": %d}, "context": {"includeDeclaration": true}}}`,
+		id, method, line, character)
+}
+
+func documentRequest(id int, method, uri string) string {
+	return fmt.Sprintf(`{"id": %d, "method": %q, "params": {"textDocument": {"uri": %q}}}`, id, method, uri)
+}
+
+func TestServer(t *testing.T) {
+	responses, notifications := runSession(t,
+		`{"id": 1, "method": "initialize", "params": {}}`,
+		`{"method": "initialized", "params": {}}`,
+		openDocument("file:///main.mk", testSource),
+		openDocument("file:///broken.mk", "let x = 1;\nlet y = ;"),
+		positionRequest(2, "textDocument/hover", 6, 1),
+		positionRequest(3, "textDocument/hover", 6, 10),
+		positionRequest(4, "textDocument/definition", 6, 5),
+		positionRequest(5, "textDocument/references", 2, 16),
+		documentRequest(6, "textDocument/documentSymbol", "file:///main.mk"),
+		positionRequest(7, "textDocument/completion", 3, 4),
+		positionRequest(8, "textDocument/completion", 6, 0),
+		documentRequest(9, "textDocument/formatting", "file:///main.mk"),
+		documentRequest(10, "textDocument/formatting", "file:///broken.mk"),
+		`{"id": 11, "method": "textDocument/rename", "params": {}}`,
+		`{"id": 12, "method": "shutdown"}`,
+		`{"method": "exit"}`,
+	)
+
+	tests := []struct {
+		id       string
+		expected string
+	}{
+		{"2", `{"contents":{"kind":"markdown","value":"` + "```monkey\\nlet add = fn(a: int, b) -\\u003e int\\n```" + `"},"range":{"start":{"line":6,"character":0},"end":{"line":6,"character":3}}}`},
+		{"3", `{"contents":{"kind":"markdown","value":"` + "```monkey\\nbuiltin len(value: array | string | hash) -\\u003e int\\n```" + `"},"range":{"start":{"line":6,"character":9},"end":{"line":6,"character":12}}}`},
+		{"4", `{"uri":"file:///main.mk","range":{"start":{"line":0,"character":4},"end":{"line":0,"character":7}}}`},
+		{"5", `[{"uri":"file:///main.mk","range":{"start":{"line":1,"character":13},"end":{"line":1,"character":14}}},` +
+			`{"uri":"file:///main.mk","range":{"start":{"line":2,"character":16},"end":{"line":2,"character":17}}}]`},
+		{"10", `null`},
+	}
+	for _, tt := range tests {
+		resp, ok := responses[tt.id]
+		if !ok {
+			t.Errorf("no response to request %s", tt.id)
+			continue
+		}
+		if string(resp.Result) != tt.expected {
+			t.Errorf("request %s: wrong result.\nwant=%s\ngot =%s", tt.id, tt.expected, resp.Result)
+		}
+	}
+
+	var symbols []documentSymbol
+	json.Unmarshal(responses["6"].Result, &symbols)
+	var outline []string
+	for _, symbol := range symbols {
+		entry := fmt.Sprintf("%s:%d", symbol.Name, symbol.Kind)
+		for _, child := range symbol.Children {
+			entry += fmt.Sprintf(" (%s:%d)", child.Name, child.Kind)
+		}
+		outline = append(outline, entry)
+	}
+	if got := strings.Join(outline, ", "); got != "day:13, add:12 (total:13), name:14" {
+		t.Errorf("wrong symbols. got=%q", got)
+	}
+	if end := symbols[1].Range.End; end.Line != 4 || end.Character != 1 {
+		t.Errorf("wrong end of add. got=%+v", end)
+	}
+
+	for id, want := range map[string]string{"7": "day add a b total name", "8": "day add name"} {
+		var items []completionItem
+		json.Unmarshal(responses[id].Result, &items)
+		var names []string
+		builtins := 0
+		for _, item := range items {
+			if item.Kind == completionFunction && strings.HasPrefix(item.Detail, item.Label+"(") {
+				builtins++
+				continue
+			}
+			names = append(names, item.Label)
+		}
+		if strings.Join(names, " ") != want || builtins == 0 {
+			t.Errorf("request %s: wrong completions. got=%v and %d builtins", id, names, builtins)
+		}
+	}
+
+	var edits []textEdit
+	json.Unmarshal(responses["9"].Result, &edits)
+	if len(edits) != 1 || !strings.HasPrefix(edits[0].NewText, "let day = 60 * 60 * 24;\nlet add = fn(a: int, b) -> int {") {
+		t.Errorf("wrong formatting edits. got=%+v", edits)
+	}
+	if resp := responses["11"]; resp.Error == nil || resp.Error.Code != codeMethodNotFound {
+		t.Errorf("unknown method did not fail. got=%+v", resp)
+	}
+	if resp := responses["12"]; resp.Error != nil || string(resp.Result) != "null" {
+		t.Errorf("wrong shutdown response. got=%+v", resp)
+	}
+
+	if len(notifications) != 2 {
+		t.Fatalf("wrong number of notifications. got=%d", len(notifications))
+	}
+	var main, broken publishDiagnosticsParams
+	json.Unmarshal(notifications[0].Params, &main)
+	json.Unmarshal(notifications[1].Params, &broken)
+	if len(main.Diagnostics) != 0 {
+		t.Errorf("unexpected diagnostics for main.mk: %+v", main.Diagnostics)
+	}
+	want := diagnostic{
+		Range:    textRange{Start: position{Line: 1, Character: 8}, End: position{Line: 1, Character: 9}},
+		Severity: severityError,
+		Source:   "monkey",
+		Message:  "no prefix parse function for ; found",
+	}
+	if len(broken.Diagnostics) == 0 || broken.Diagnostics[0] != want {
+		t.Errorf("wrong diagnostics for broken.mk. got=%+v", broken.Diagnostics)
+	}
+}
+
+const prefixSource = `import "lib/math.mk" as m;
+import {square, cube as c} from "lib/math.mk";
+export const limit: int = 10;
+let [head, ...others] = [1, 2, 3];
+let {name: who} = {"name": "monkey"};
+let greet = fn(person: string) -> string {
+    defer puts("done");
+    let message = "hello ${person}";
+    if (len(person) > limit) {
+        throw error("too long", "ValueError");
+    }
+    message
+};
+let safe = try { greet(who) } catch (e) { e["message"] } finally { puts("x") };
+let kind = match (head) {
+    1 => "one",
+    [a, b] if a < b => { a + b },
+    {"k": v} => v,
+    _ => "many"
+};
+let unless = macro(cond, body) { quote(if (!unquote(cond)) { unquote(body) }) };
+unless(false, puts(m?.square(2), square(3), c(2), others[0:1], 1..3));
+return safe ?? kind;`
+
+// TestServerPrefixes opens every prefix of a file, as an editor does
+// while it is typed, and asks about the last position of each.
+func TestServerPrefixes(t *testing.T) {
+	msgs := []string{`{"id": 0, "method": "initialize", "params": {}}`}
+	id := 1
+	for i := 0; i <= len(prefixSource); i++ {
+		text := prefixSource[:i]
+		msgs = append(msgs, openDocument("file:///main.mk", text))
+		lines := strings.Split(text, "\n")
+		line, character := len(lines)-1, len(lines[len(lines)-1])
+		for _, method := range []string{"textDocument/hover", "textDocument/definition", "textDocument/references", "textDocument/completion"} {
+			msgs = append(msgs, positionRequest(id, method, line, character))
+			id++
+		}
+		for _, method := range []string{"textDocument/documentSymbol", "textDocument/formatting"} {
+			msgs = append(msgs, documentRequest(id, method, "file:///main.mk"))
+			id++
+		}
+	}
+	msgs = append(msgs, `{"id": -1, "method": "shutdown"}`, `{"method": "exit"}`)
+
+	responses, _ := runSession(t, msgs...)
+	for i := 1; i < id; i++ {
+		if resp := responses[fmt.Sprint(i)]; resp == nil || resp.Error != nil {
+			t.Fatalf("request %d failed: %+v", i, resp)
+		}
+	}
+}
+
+func TestServerLifecycle(t *testing.T) {
+	responses, _ := runSession(t,
+		documentRequest(1, "textDocument/documentSymbol", "file:///main.mk"),
+		`{"id": 2, "method": "initialize", "params": {}}`,
+		documentRequest(3, "textDocument/documentSymbol", "file:///missing.mk"),
+		`{"id": 4, "method": "shutdown"}`,
+		`{"id": 5, "method": "shutdown"}`,
+		`{"method": "exit"}`,
+	)
+	codes := map[string]int{"1": codeNotInitialized, "3": codeInvalidParams, "5": codeInvalidRequest}
+	for id, code := range codes {
+		if resp := responses[id]; resp == nil || resp.Error == nil || resp.Error.Code != code {
+			t.Errorf("request %s: want error %d. got=%+v", id, code, resp)
+		}
+	}
+
+	err := NewServer(strings.NewReader(""), io.Discard).Serve()
+	if err == nil || err.Error() != "connection closed before shutdown" {
+		t.Errorf("wrong error for a closed connection. got=%v", err)
+	}
+}
+
+func TestPositions(t *testing.T) {
+	doc := newDocument("file:///u.mk", "let s = \"é😀\"; s")
+	// The last s is at byte column 19 but UTF-16 character 15.
+	pos := doc.position(1, 19)
+	if pos.Character != 15 {
+		t.Errorf("wrong character. got=%d", pos.Character)
+	}
+	if line, col := doc.offset(pos); line != 1 || col != 19 {
+		t.Errorf("wrong offset. got=%d:%d", line, col)
+	}
+	if loc := doc.definition(pos); loc == nil || loc.Range.Start.Character != 4 {
+		t.Errorf("wrong definition. got=%+v", loc)
+	}
+}
@@ -1,18 +1,253 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"monkey/ast"
+	"monkey/eval"
+	"monkey/format"
+	"monkey/lexer"
+	"monkey/lint"
+	"monkey/lsp"
+	"monkey/object"
+	"monkey/parser"
 	"monkey/repl"
 	"os"
 	"os/user"
+	"path/filepath"
+	"strings"
 )
 
 func main() {
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		switch os.Args[1] {
+		case "run":
+			os.Exit(run(os.Args[2:]))
+		case "ast":
+			os.Exit(dumpAST(os.Args[2:]))
+		case "fmt":
+			os.Exit(formatFiles(os.Args[2:]))
+		case "lint":
+			os.Exit(lintFiles(os.Args[2:]))
+		case "lsp":
+			os.Exit(serveLSP(os.Args[2:]))
+		default:
+			fmt.Fprintf(os.Stderr, "unknown command %q\n", os.Args[1])
+			fmt.Fprintf(os.Stderr, "usage: monkey [-no-optimize | run [-I dir]... [-no-optimize] file.mk | ast [--json] file.mk | fmt [-w | -check] file.mk... | lint file.mk... | lsp]\n")
+			os.Exit(2)
+		}
+	}
+
+	fs := flag.NewFlagSet("monkey", flag.ExitOnError)
+	noOptimize := fs.Bool("no-optimize", false, "evaluate lines without optimizing them first")
+	fs.Parse(os.Args[1:])
+
 	user, err := user.Current()
 	if err != nil {
 		panic(err)
 	}
+	eval.Modules.SearchPaths = searchPaths(nil)
+	eval.Modules.Optimize = !*noOptimize
 	fmt.Printf("Hello %s! This is your lovely monkey language\n", user.Username)
 	fmt.Printf("Type commands my Lord!\n")
-	repl.Start(os.Stdin, os.Stdout)
+	repl.Start(os.Stdin, os.Stdout, !*noOptimize)
+}
+
+// pathList collects repeated -I flags.
+type pathList []string
+
+func (pl *pathList) String() string { return strings.Join(*pl, string(filepath.ListSeparator)) }
+func (pl *pathList) Set(dir string) error {
+	*pl = append(*pl, dir)
+	return nil
+}
+
+// searchPaths puts directories given with -I before those listed in the
+// MONKEYPATH environment variable.
+func searchPaths(flagged pathList) []string {
+	paths := append([]string{}, flagged...)
+	if env := os.Getenv("MONKEYPATH"); env != "" {
+		paths = append(paths, filepath.SplitList(env)...)
+	}
+	return paths
+}
+
+func run(args []string) int {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	var include pathList
+	fs.Var(&include, "I", "add a directory to the module search path")
+	noOptimize := fs.Bool("no-optimize", false, "evaluate programs without optimizing them first")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: monkey run [-I dir]... [-no-optimize] file.mk")
+		return 2
+	}
+
+	eval.Modules.SearchPaths = searchPaths(include)
+	eval.Modules.Optimize = !*noOptimize
+	result := eval.RunFile(fs.Arg(0))
+	if err, ok := result.(*object.Error); ok {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", err.Kind, err.Message)
+		for _, frame := range err.Stack {
+			fmt.Fprintf(os.Stderr, "\t%s\n", frame)
+		}
+		return 1
+	}
+	return 0
+}
+
+// dumpAST prints the syntax tree of a file, as an indented outline or, with
+// --json, in the format ast.UnmarshalJSON reads back. Flags may follow the
+// file name.
+func dumpAST(args []string) int {
+	asJSON := false
+	files := []string{}
+	for _, arg := range args {
+		switch arg {
+		case "--json", "-json":
+			asJSON = true
+		default:
+			files = append(files, arg)
+		}
+	}
+	if len(files) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: monkey ast [--json] file.mk")
+		return 2
+	}
+
+	source, err := os.ReadFile(files[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	p := parser.New(lexer.New(string(source)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		for _, msg := range p.Errors() {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", files[0], msg)
+		}
+		return 1
+	}
+
+	if !asJSON {
+		depth := 0
+		ast.Inspect(program, func(node ast.Node) bool {
+			if node == nil {
+				depth--
+				return false
+			}
+			fmt.Printf("%s%T %s\n", strings.Repeat("  ", depth), node, node.TokenLiteral())
+			depth++
+			return true
+		})
+		return 0
+	}
+
+	data, err := ast.MarshalJSON(program)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	var out bytes.Buffer
+	json.Indent(&out, data, "", "  ")
+	out.WriteString("\n")
+	out.WriteTo(os.Stdout)
+	return 0
+}
+
+// formatFiles prints the canonical form of each file, or with -w rewrites
+// the files that are not formatted. With -check it only lists those files
+// and fails if there are any.
+func formatFiles(args []string) int {
+	fs := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := fs.Bool("w", false, "write the result back to the files")
+	check := fs.Bool("check", false, "list files whose formatting differs and fail if any do")
+	fs.Parse(args)
+	if fs.NArg() == 0 || *write && *check {
+		fmt.Fprintln(os.Stderr, "usage: monkey fmt [-w | -check] file.mk...")
+		return 2
+	}
+
+	status := 0
+	for _, file := range fs.Args() {
+		source, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+		formatted, err := format.Source(source)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", file, err)
+			status = 1
+			continue
+		}
+
+		switch {
+		case *check:
+			if !bytes.Equal(source, formatted) {
+				fmt.Println(file)
+				status = 1
+			}
+		case *write:
+			if bytes.Equal(source, formatted) {
+				continue
+			}
+			if err := os.WriteFile(file, formatted, 0644); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				status = 1
+			}
+		default:
+			os.Stdout.Write(formatted)
+		}
+	}
+	return status
+}
+
+// lintFiles reports the problems lint finds in each file and fails if
+// there are any.
+func lintFiles(files []string) int {
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: monkey lint file.mk...")
+		return 2
+	}
+
+	status := 0
+	for _, file := range files {
+		source, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+		p := parser.New(lexer.New(string(source)))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			for _, msg := range p.Errors() {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", file, msg)
+			}
+			status = 1
+			continue
+		}
+		for _, d := range lint.Check(program) {
+			fmt.Printf("%s:%s\n", file, d)
+			status = 1
+		}
+	}
+	return status
+}
+
+// serveLSP runs a language server for editors on stdin and stdout.
+func serveLSP(args []string) int {
+	if len(args) != 0 {
+		fmt.Fprintln(os.Stderr, "usage: monkey lsp")
+		return 2
+	}
+	if err := lsp.NewServer(os.Stdin, os.Stdout).Serve(); err != nil {
+		fmt.Fprintln(os.Stderr, "monkey lsp:", err)
+		return 1
+	}
+	return 0
 }
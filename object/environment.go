@@ -1,13 +1,32 @@
 package object
 
+// Environment holds the variables of one scope in a slice. The resolver
+// gives each variable a slot, so evaluated code reads and writes it by
+// index; lookups by name remain for code the resolver has not seen, such
+// as macro bodies, and for reading a scope from the outside.
+// Those scan the slice, which costs time linear in the size of the scope;
+// scopes are small and resolved code never takes that path, so no index
+// by name is kept.
 type Environment struct {
-    store map[string]Object
+    vars []variable
     outer *Environment
+    frame *Frame
+}
+
+type variable struct {
+    name string
+    value Object
+    constant bool
+}
+
+// Frame holds the state of one function call. Deferred thunks run in
+// reverse order of registration when the call returns.
+type Frame struct {
+    Deferred []func() Object
 }
 
 func NewEnvironment() *Environment {
-    s := make(map[string]Object)
-    return &Environment{store: s, outer: nil}
+    return &Environment{}
 }
 
 func NewEnclosedEnvironment(outer *Environment) *Environment {
@@ -17,14 +36,119 @@ func NewEnclosedEnvironment(outer *Environment) *Environment {
 }
 
 func (env *Environment) Get(name string) (Object, bool) {
-    obj, ok := env.store[name]
-    if !ok && env.outer != nil {
-        obj, ok = env.outer.Get(name)
+    for e := env; e != nil; e = e.outer {
+        if slot, ok := e.slotOf(name); ok {
+            return e.vars[slot].value, true
+        }
     }
-    return obj,ok
+    return nil, false
 }
 
 func (env *Environment) Set(name string, val Object) Object {
-    env.store[name] = val
+    if slot, ok := env.slotOf(name); ok {
+        env.vars[slot] = variable{name: name, value: val}
+        return val
+    }
+    env.vars = append(env.vars, variable{name: name, value: val})
+    return val
+}
+
+// SetConst binds name like Set and marks it constant in this scope.
+func (env *Environment) SetConst(name string, val Object) Object {
+    env.Set(name, val)
+    slot, _ := env.slotOf(name)
+    env.vars[slot].constant = true
+    return val
+}
+
+// IsConst reports whether name is a constant of this scope; constants of
+// enclosing scopes may be shadowed.
+func (env *Environment) IsConst(name string) bool {
+    slot, ok := env.slotOf(name)
+    return ok && env.vars[slot].constant
+}
+
+// slotOf returns the first slot of this scope bound to name.
+func (env *Environment) slotOf(name string) (int, bool) {
+    for slot, v := range env.vars {
+        if v.name == name && v.value != nil {
+            return slot, true
+        }
+    }
+    return 0, false
+}
+
+// Enclosing returns the environment depth scopes out from env, env itself
+// for 0, or nil if there are not that many.
+func (env *Environment) Enclosing(depth int) *Environment {
+    e := env
+    for ; e != nil && depth > 0; depth-- {
+        e = e.outer
+    }
+    return e
+}
+
+// GetAt returns the value in slot of this scope, and false if nothing has
+// been bound there yet.
+func (env *Environment) GetAt(slot int) (Object, bool) {
+    if env == nil || slot >= len(env.vars) || env.vars[slot].value == nil {
+        return nil, false
+    }
+    return env.vars[slot].value, true
+}
+
+// SetAt binds name to val in slot of this scope. A variable bound there by
+// name with Set is moved to a free slot first.
+func (env *Environment) SetAt(slot int, name string, val Object) Object {
+    for len(env.vars) <= slot {
+        env.vars = append(env.vars, variable{})
+    }
+    if old := env.vars[slot]; old.name != "" && old.name != name {
+        env.vars = append(env.vars, old)
+    }
+    env.vars[slot] = variable{name: name, value: val}
     return val
 }
+
+// SetConstAt binds name like SetAt and marks it constant in this scope.
+func (env *Environment) SetConstAt(slot int, name string, val Object) Object {
+    env.SetAt(slot, name, val)
+    env.vars[slot].constant = true
+    return val
+}
+
+// IsConstAt reports whether slot holds a constant of this scope.
+func (env *Environment) IsConstAt(slot int) bool {
+    return slot < len(env.vars) && env.vars[slot].constant
+}
+
+// Names returns the names bound in this scope indexed by slot, with ""
+// for slots that are still empty.
+func (env *Environment) Names() []string {
+    names := make([]string, len(env.vars))
+    for slot, v := range env.vars {
+        if v.value != nil {
+            names[slot] = v.name
+        }
+    }
+    return names
+}
+
+// NewFunctionEnvironment is the environment of a single function call; it
+// owns the call's Frame.
+func NewFunctionEnvironment(outer *Environment) *Environment {
+    env := NewEnclosedEnvironment(outer)
+    env.frame = &Frame{}
+    return env
+}
+
+// Frame returns the frame of the innermost function call env belongs to,
+// or nil at the top level.
+func (env *Environment) Frame() *Frame {
+    for e := env; e != nil; e = e.outer {
+        if e.frame != nil {
+            return e.frame
+        }
+    }
+    return nil
+}
@@ -0,0 +1,108 @@
+package object
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+)
+
+// Equal reports whether a and b hold the same value. Integers, strings,
+// booleans and null compare by value, arrays element by element and hashes
+// by their key/value pairs regardless of insertion order. Functions and
+// builtins compare by identity.
+func Equal(a, b Object) bool {
+	switch a := a.(type) {
+	case *Integer:
+		other, ok := b.(*Integer)
+		return ok && a.Value == other.Value
+	case *String:
+		other, ok := b.(*String)
+		return ok && a.Value == other.Value
+	case *Boolean:
+		other, ok := b.(*Boolean)
+		return ok && a.Value == other.Value
+	case *Null:
+		_, ok := b.(*Null)
+		return ok
+	case *Array:
+		other, ok := b.(*Array)
+		if !ok || len(a.Elements) != len(other.Elements) {
+			return false
+		}
+		for i, el := range a.Elements {
+			if !Equal(el, other.Elements[i]) {
+				return false
+			}
+		}
+		return true
+	case *Hash:
+		other, ok := b.(*Hash)
+		if !ok || a.Len() != other.Len() {
+			return false
+		}
+		for _, pair := range a.Entries() {
+			otherPair, ok := other.Get(pair.Key.(Hashable))
+			if !ok || !Equal(pair.Value, otherPair.Value) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+// AsHashable returns obj as a Hashable if it can be used as a hash key.
+// Arrays and hashes implement HashKey but only qualify when everything they
+// contain is hashable too.
+func AsHashable(obj Object) (Hashable, bool) {
+	switch obj := obj.(type) {
+	case *Array:
+		for _, el := range obj.Elements {
+			if _, ok := AsHashable(el); !ok {
+				return nil, false
+			}
+		}
+		return obj, true
+	case *Hash:
+		for _, pair := range obj.Entries() {
+			if _, ok := AsHashable(pair.Value); !ok {
+				return nil, false
+			}
+		}
+		return obj, true
+	case Hashable:
+		return obj, true
+	default:
+		return nil, false
+	}
+}
+
+// HashKey combines the keys of the elements in order. Callers must check
+// the array with AsHashable first.
+func (a *Array) HashKey() HashKey {
+	h := fnv.New64a()
+	for _, el := range a.Elements {
+		writeHashKey(h, el.(Hashable).HashKey())
+	}
+	return HashKey{Type: a.Type(), Value: h.Sum64()}
+}
+
+// HashKey is independent of insertion order so that equal hashes share a
+// key. Callers must check the hash with AsHashable first.
+func (h *Hash) HashKey() HashKey {
+	var sum uint64
+	for _, pair := range h.Entries() {
+		pairHash := fnv.New64a()
+		writeHashKey(pairHash, pair.Key.(Hashable).HashKey())
+		writeHashKey(pairHash, pair.Value.(Hashable).HashKey())
+		sum += pairHash.Sum64()
+	}
+	return HashKey{Type: h.Type(), Value: sum}
+}
+
+func writeHashKey(w interface{ Write([]byte) (int, error) }, key HashKey) {
+	var buf [8]byte
+	w.Write([]byte(key.Type))
+	binary.LittleEndian.PutUint64(buf[:], key.Value)
+	w.Write(buf[:])
+}
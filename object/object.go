@@ -15,7 +15,11 @@ type Object interface {
     Inspect() string
 }
 
+// Hashable objects can be used as hash keys. HashKey only narrows the
+// search: distinct keys may share a HashKey, so Hash compares candidates
+// with Equal before treating them as the same key.
 type Hashable interface {
+    Object
     HashKey() HashKey
 }
 
@@ -25,11 +29,15 @@ const (
     NULL_OBJ = "NULL"
     RETURN_VALUE_OBJ = "RETURN_VALUE"
     ERROR_OBJ = "ERROR"
+    ERROR_VALUE_OBJ = "ERROR_VALUE"
     FUNCTION_OBJ = "FUNCTION"
     STRING_OBJ = "STRING"
     BUILTIN_OBJ = "BUILTIN"
     ARRAY_OBJ = "ARRAY"
     HASH_OBJ = "HASH"
+    MODULE_OBJ = "MODULE"
+    QUOTE_OBJ = "QUOTE"
+    MACRO_OBJ = "MACRO"
 )
 
 type Integer struct {
@@ -57,6 +65,7 @@ type Null struct {}
 
 func (n *Null) Type() ObjectType { return NULL_OBJ }
 func (n *Null) Inspect() string { return "null" }
+func (n *Null) HashKey() HashKey { return HashKey{Type: n.Type()} }
 
 type ReturnValue struct {
     Value Object
@@ -65,15 +74,36 @@ type ReturnValue struct {
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (rv *ReturnValue) Inspect() string { return rv.Value.Inspect() }
 
+// Error is a failure unwinding the evaluator. Kind is "RuntimeError" for
+// errors raised by the interpreter and whatever the script chose for
+// thrown ones; Value holds the thrown object, if any. Stack gains one
+// frame per function the error leaves, innermost first.
 type Error struct {
     Message string
+    Kind string
+    Value Object
+    Stack []string
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
 func (e *Error) Inspect() string { return e.Message }
 
+// ErrorValue is an Error caught by `catch` (or built with the `error`
+// builtin). Unlike Error it is an ordinary value that does not unwind, so
+// scripts can bind it, index it and throw it again.
+type ErrorValue struct {
+    Error *Error
+}
+
+func (ev *ErrorValue) Type() ObjectType { return ERROR_VALUE_OBJ }
+func (ev *ErrorValue) Inspect() string {
+    return ev.Error.Kind + ": " + ev.Error.Message
+}
+
 type Function struct {
+    Name string
     Parameters []*ast.Identifier
+    ReturnType *ast.TypeAnnotation
     Body *ast.BlockStatement
     Env *Environment
 }
@@ -90,12 +120,58 @@ func (f *Function) Inspect() string {
     out.WriteString("fn")
     out.WriteString("(")
     out.WriteString(strings.Join(params, ","))
-    out.WriteString(") {\n")
+    out.WriteString(")")
+    if f.ReturnType != nil {
+        out.WriteString(" -> " + f.ReturnType.String())
+    }
+    out.WriteString(" {\n")
     out.WriteString(f.Body.String()+ "\n}")
 
     return out.String()
 }
 
+// Module is an imported file. Only the names it exports are reachable
+// through it, as m["name"].
+type Module struct {
+    Path string
+    Exports map[string]Object
+}
+
+func (m *Module) Type() ObjectType { return MODULE_OBJ }
+func (m *Module) Inspect() string { return "<module " + m.Path + ">" }
+
+// Quote wraps the unevaluated AST produced by `quote(expr)`.
+type Quote struct {
+    Node ast.Node
+}
+
+func (q *Quote) Type() ObjectType { return QUOTE_OBJ }
+func (q *Quote) Inspect() string { return "QUOTE(" + q.Node.String() + ")" }
+
+type Macro struct {
+    Parameters []*ast.Identifier
+    Body *ast.BlockStatement
+    Env *Environment
+}
+
+func (m *Macro) Type() ObjectType { return MACRO_OBJ }
+func (m *Macro) Inspect() string {
+    var out bytes.Buffer
+
+    params := []string{}
+    for _, p := range m.Parameters {
+        params = append(params, p.String())
+    }
+
+    out.WriteString("macro")
+    out.WriteString("(")
+    out.WriteString(strings.Join(params, ","))
+    out.WriteString(") {\n")
+    out.WriteString(m.Body.String() + "\n}")
+
+    return out.String()
+}
+
 type BuiltinFunction func(args ...Object) Object
 
 type Builtin struct {
@@ -146,11 +222,16 @@ func (i * Integer) HashKey() HashKey {
     return HashKey{Type: i.Type(),Value: uint64(i.Value)}
 }
 
-func (s *String) HashKey() HashKey {
+// hashString is a variable so tests can swap in a degenerate function
+// and force collisions.
+var hashString = func(s string) uint64 {
     h := fnv.New64a()
-    h.Write([]byte(s.Value))
+    h.Write([]byte(s))
+    return h.Sum64()
+}
 
-    return HashKey{Type: s.Type(), Value: h.Sum64()}
+func (s *String) HashKey() HashKey {
+    return HashKey{Type: s.Type(), Value: hashString(s.Value)}
 }
 
 type HashPair struct {
@@ -158,8 +239,88 @@ type HashPair struct {
     Value Object
 }
 
+// Hash maps keys to values in insertion order. Keys are grouped into
+// buckets by HashKey and told apart with Equal, so colliding keys never
+// overwrite each other.
 type Hash struct {
-    Pairs map[HashKey]HashPair
+    buckets map[HashKey][]HashPair
+    order   []Hashable
+}
+
+func NewHash() *Hash {
+    return &Hash{buckets: make(map[HashKey][]HashPair)}
+}
+
+func (h *Hash) find(key Hashable) (HashKey, int) {
+    hashKey := key.HashKey()
+    for i, pair := range h.buckets[hashKey] {
+        if Equal(pair.Key, key) {
+            return hashKey, i
+        }
+    }
+    return hashKey, -1
+}
+
+func (h *Hash) Get(key Hashable) (HashPair, bool) {
+    hashKey, i := h.find(key)
+    if i < 0 {
+        return HashPair{}, false
+    }
+    return h.buckets[hashKey][i], true
+}
+
+// Set stores value under key. Overwriting an existing key keeps its
+// original position.
+func (h *Hash) Set(key Hashable, value Object) {
+    hashKey, i := h.find(key)
+    if i >= 0 {
+        h.buckets[hashKey][i].Value = value
+        return
+    }
+    h.buckets[hashKey] = append(h.buckets[hashKey], HashPair{Key: key, Value: value})
+    h.order = append(h.order, key)
+}
+
+func (h *Hash) Delete(key Hashable) bool {
+    hashKey, i := h.find(key)
+    if i < 0 {
+        return false
+    }
+
+    bucket := h.buckets[hashKey]
+    if len(bucket) == 1 {
+        delete(h.buckets, hashKey)
+    } else {
+        h.buckets[hashKey] = append(bucket[:i:i], bucket[i+1:]...)
+    }
+    for j, k := range h.order {
+        if Equal(k, key) {
+            h.order = append(h.order[:j:j], h.order[j+1:]...)
+            break
+        }
+    }
+    return true
+}
+
+func (h *Hash) Len() int { return len(h.order) }
+
+// Entries returns the pairs in insertion order.
+func (h *Hash) Entries() []HashPair {
+    entries := make([]HashPair, 0, len(h.order))
+    for _, key := range h.order {
+        pair, _ := h.Get(key)
+        entries = append(entries, pair)
+    }
+    return entries
+}
+
+// Copy returns a shallow copy that can be modified without affecting h.
+func (h *Hash) Copy() *Hash {
+    c := NewHash()
+    for _, pair := range h.Entries() {
+        c.Set(pair.Key.(Hashable), pair.Value)
+    }
+    return c
 }
 
 func (h *Hash) Type() ObjectType { return HASH_OBJ }
@@ -167,10 +328,10 @@ func (h *Hash) Inspect() string {
     var out bytes.Buffer
 
     pairs := []string{}
-    for _, pair := range h.Pairs {
+    for _, pair := range h.Entries() {
         pairs = append(pairs, fmt.Sprintf("%s: %s",
-    pair.Key.Inspect(), pair.Value.Inspect()))
-}
+            pair.Key.Inspect(), pair.Value.Inspect()))
+    }
     out.WriteString("{" + strings.Join(pairs, ",") + "}")
 
     return out.String()
@@ -58,3 +58,195 @@ func TestIntegerHashKey(t *testing.T) {
 		t.Errorf("integers with twoerent content have same hash keys")
 	}
 }
+
+func TestHashInsertionOrder(t *testing.T) {
+	hash := NewHash()
+	keys := []*String{{Value: "c"}, {Value: "a"}, {Value: "b"}}
+	for i, key := range keys {
+		hash.Set(key, &Integer{Value: int64(i)})
+	}
+	hash.Set(keys[0], &Integer{Value: 9})
+
+	if hash.Inspect() != "{c: 9,a: 1,b: 2}" {
+		t.Errorf("hash.Inspect() wrong. got=%q", hash.Inspect())
+	}
+
+	if !hash.Delete(keys[1]) {
+		t.Errorf("Delete did not find existing key")
+	}
+	if hash.Delete(keys[1]) {
+		t.Errorf("Delete found already deleted key")
+	}
+	if hash.Len() != 2 || hash.Inspect() != "{c: 9,b: 2}" {
+		t.Errorf("hash wrong after Delete. got=%q", hash.Inspect())
+	}
+
+	copied := hash.Copy()
+	copied.Set(keys[1], &Integer{Value: 1})
+	if hash.Len() != 2 || copied.Inspect() != "{c: 9,b: 2,a: 1}" {
+		t.Errorf("Copy shares state with original. original=%q copy=%q",
+			hash.Inspect(), copied.Inspect())
+	}
+}
+
+func TestHashKeyCollisions(t *testing.T) {
+	original := hashString
+	hashString = func(string) uint64 { return 42 }
+	defer func() { hashString = original }()
+
+	a := &String{Value: "alpha"}
+	b := &String{Value: "beta"}
+	c := &String{Value: "gamma"}
+	if a.HashKey() != b.HashKey() {
+		t.Fatalf("test harness did not force a collision")
+	}
+
+	hash := NewHash()
+	hash.Set(a, &Integer{Value: 1})
+	hash.Set(b, &Integer{Value: 2})
+	hash.Set(c, &Integer{Value: 3})
+	hash.Set(&String{Value: "beta"}, &Integer{Value: 20})
+
+	if hash.Len() != 3 {
+		t.Fatalf("colliding keys overwrote each other. got=%s", hash.Inspect())
+	}
+	for key, want := range map[string]int64{"alpha": 1, "beta": 20, "gamma": 3} {
+		pair, ok := hash.Get(&String{Value: key})
+		if !ok {
+			t.Errorf("no pair for %q", key)
+			continue
+		}
+		if pair.Value.(*Integer).Value != want {
+			t.Errorf("wrong value for %q. want=%d got=%s", key, want, pair.Value.Inspect())
+		}
+	}
+	if _, ok := hash.Get(&String{Value: "delta"}); ok {
+		t.Errorf("found a key that was never set")
+	}
+
+	hash.Delete(b)
+	if _, ok := hash.Get(a); !ok {
+		t.Errorf("deleting a colliding key removed its neighbour")
+	}
+	if hash.Inspect() != "{alpha: 1,gamma: 3}" {
+		t.Errorf("hash wrong after Delete. got=%q", hash.Inspect())
+	}
+
+	// Integers and strings share no bucket even with equal hash values.
+	mixed := NewHash()
+	mixed.Set(&Integer{Value: 42}, &Boolean{Value: true})
+	mixed.Set(&String{Value: "x"}, &Boolean{Value: true})
+	if mixed.Len() != 2 {
+		t.Errorf("keys of different types collided. got=%s", mixed.Inspect())
+	}
+}
+
+func TestEqual(t *testing.T) {
+	arr := func(els ...Object) *Array { return &Array{Elements: els} }
+	hash := func(kv ...Object) *Hash {
+		h := NewHash()
+		for i := 0; i < len(kv); i += 2 {
+			h.Set(kv[i].(Hashable), kv[i+1])
+		}
+		return h
+	}
+	one, two := &Integer{Value: 1}, &Integer{Value: 2}
+	a, b := &String{Value: "a"}, &String{Value: "b"}
+	fn := &Builtin{}
+
+	tests := []struct {
+		left, right Object
+		expected    bool
+	}{
+		{arr(one, two), arr(&Integer{Value: 1}, &Integer{Value: 2}), true},
+		{arr(one, two), arr(two, one), false},
+		{arr(one), arr(one, one), false},
+		{arr(arr(a), &Null{}), arr(arr(&String{Value: "a"}), &Null{}), true},
+		{hash(a, one, b, two), hash(b, two, a, one), true},
+		{hash(a, one), hash(a, two), false},
+		{hash(a, one), hash(b, one), false},
+		{&Null{}, &Null{}, true},
+		{&Null{}, &Boolean{Value: false}, false},
+		{one, a, false},
+		{fn, fn, true},
+		{fn, &Builtin{}, false},
+	}
+	for i, tt := range tests {
+		if Equal(tt.left, tt.right) != tt.expected {
+			t.Errorf("tests[%d]: Equal(%s, %s) != %t", i,
+				tt.left.Inspect(), tt.right.Inspect(), tt.expected)
+		}
+	}
+}
+
+func TestCompositeHashKey(t *testing.T) {
+	tuple1 := &Array{Elements: []Object{&String{Value: "x"}, &Integer{Value: 1}}}
+	tuple2 := &Array{Elements: []Object{&String{Value: "x"}, &Integer{Value: 1}}}
+	swapped := &Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "x"}}}
+
+	if tuple1.HashKey() != tuple2.HashKey() {
+		t.Errorf("arrays with same content have different hash keys")
+	}
+	if tuple1.HashKey() == swapped.HashKey() {
+		t.Errorf("arrays with different order have same hash keys")
+	}
+
+	h1 := NewHash()
+	h1.Set(&String{Value: "a"}, &Integer{Value: 1})
+	h1.Set(&String{Value: "b"}, tuple1)
+	h2 := NewHash()
+	h2.Set(&String{Value: "b"}, tuple2)
+	h2.Set(&String{Value: "a"}, &Integer{Value: 1})
+	if h1.HashKey() != h2.HashKey() {
+		t.Errorf("equal hashes have different hash keys")
+	}
+
+	if _, ok := AsHashable(tuple1); !ok {
+		t.Errorf("array of hashables is not hashable")
+	}
+	withFn := &Array{Elements: []Object{&Integer{Value: 1}, &Builtin{}}}
+	if _, ok := AsHashable(withFn); ok {
+		t.Errorf("array containing a builtin is hashable")
+	}
+	nested := &Array{Elements: []Object{withFn}}
+	if _, ok := AsHashable(nested); ok {
+		t.Errorf("array nesting a builtin is hashable")
+	}
+}
+
+func TestEnvironmentSlots(t *testing.T) {
+	env := NewEnvironment()
+	env.Set("byName", &Integer{Value: 1})
+	// Binding slot 0 moves the variable set by name out of the way.
+	env.SetAt(0, "a", &Integer{Value: 2})
+	env.SetConstAt(3, "c", &Integer{Value: 3})
+
+	if got, ok := env.Get("byName"); !ok || got.(*Integer).Value != 1 {
+		t.Errorf("variable set by name was lost. got=%v", got)
+	}
+	if got, ok := env.GetAt(0); !ok || got.(*Integer).Value != 2 {
+		t.Errorf("wrong value in slot 0. got=%v", got)
+	}
+	if _, ok := env.GetAt(2); ok {
+		t.Errorf("empty slot 2 has a value")
+	}
+	if !env.IsConstAt(3) || !env.IsConst("c") || env.IsConst("a") {
+		t.Errorf("wrong constants")
+	}
+
+	want := []string{"a", "byName", "", "c"}
+	names := env.Names()
+	if len(names) != len(want) {
+		t.Fatalf("wrong names. want=%q got=%q", want, names)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("wrong name in slot %d. want=%q got=%q", i, want[i], names[i])
+		}
+	}
+
+	inner := NewEnclosedEnvironment(env)
+	if inner.Enclosing(1) != env || inner.Enclosing(2) != nil {
+		t.Errorf("wrong enclosing environments")
+	}
+}
@@ -0,0 +1,119 @@
+package parser
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/token"
+)
+
+// `as` and `from` are only special inside import statements, so they stay
+// ordinary identifiers everywhere else.
+const (
+	importAs   = "as"
+	importFrom = "from"
+)
+
+// parseImportStatement parses `import "path" [as name];` and
+// `import {a, b as c} from "path";`.
+func (p *Parser) parseImportStatement() *ast.ImportStatement {
+	//     defer untrace(trace("ParseImportStatement"))
+	stmt := &ast.ImportStatement{Token: p.curToken}
+
+	if p.peekTokenIs(token.LCURLY) {
+		p.nextToken()
+		if !p.parseImportNames(stmt) {
+			return nil
+		}
+		if !p.expectWord(importFrom) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.STRING) {
+		return nil
+	}
+	stmt.Path = &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+
+	if stmt.Names == nil && p.peekTokenIs(token.IDENT) && p.peekToken.Literal == importAs {
+		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Alias = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+func (p *Parser) parseImportNames(stmt *ast.ImportStatement) bool {
+	stmt.Names = []*ast.Identifier{}
+	stmt.Bindings = []*ast.Identifier{}
+
+	for !p.peekTokenIs(token.RCURLY) {
+		if !p.expectPeek(token.IDENT) {
+			return false
+		}
+		name := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		binding := name
+		if p.peekTokenIs(token.IDENT) && p.peekToken.Literal == importAs {
+			p.nextToken()
+			if !p.expectPeek(token.IDENT) {
+				return false
+			}
+			binding = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		}
+		stmt.Names = append(stmt.Names, name)
+		stmt.Bindings = append(stmt.Bindings, binding)
+
+		if !p.peekTokenIs(token.RCURLY) && !p.expectPeek(token.COMMA) {
+			return false
+		}
+	}
+
+	return p.expectPeek(token.RCURLY)
+}
+
+func (p *Parser) parseExportStatement() *ast.ExportStatement {
+	//     defer untrace(trace("ParseExportStatement"))
+	stmt := &ast.ExportStatement{Token: p.curToken}
+
+	if !p.peekTokenIs(token.LET) && !p.peekTokenIs(token.CONST) {
+		msg := fmt.Sprintf("expected let or const after export, got %s instead", p.peekToken.Type)
+		p.errorAt(p.peekToken, msg)
+		return nil
+	}
+	p.nextToken()
+
+	stmt.Statement = p.parseLetStatement()
+	if stmt.Statement == nil {
+		return nil
+	}
+	return stmt
+}
+
+// expectWord is expectPeek for a contextual keyword such as `from`.
+func (p *Parser) expectWord(word string) bool {
+	if p.peekTokenIs(token.IDENT) && p.peekToken.Literal == word {
+		p.nextToken()
+		return true
+	}
+	msg := fmt.Sprintf("expected next token to be %s, got %s instead", word, p.peekToken.Type)
+	p.errorAt(p.peekToken, msg)
+	return false
+}
+
+// checkTopLevel reports imports and exports nested in a block. A module's
+// exports are collected from its top-level statements once it has run,
+// so an export in a block would never be seen, and imports, which load
+// their module when they run, bind names in the module scope alone.
+func (p *Parser) checkTopLevel(stmt ast.Statement) {
+	switch stmt := stmt.(type) {
+	case *ast.ImportStatement:
+		p.errorAt(stmt.Token, "import is only allowed at the top level")
+	case *ast.ExportStatement:
+		p.errorAt(stmt.Token, "export is only allowed at the top level")
+	}
+}
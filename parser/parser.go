@@ -17,6 +17,8 @@ type Parser struct {
 	infixParseFns  map[token.TokenType]infixParseFn
 
 	errors []string
+	// positions holds the token each error was found at.
+	positions []token.Token
 }
 
 type (
@@ -27,8 +29,10 @@ type (
 const (
 	_ int = iota
 	LOWEST
+	NULLISH     // a ?? b
 	EQUALS      // ==
 	LESSGREATER // > or <
+	RANGE       // 1..10 or 1..<10
 	SUM         // +
 	PRODUCT     // *
 	PREFIX      // -X or !X
@@ -37,16 +41,29 @@ const (
 )
 
 var precedences = map[token.TokenType]int{
+	token.NULLISH:  NULLISH,
 	token.EQ:       EQUALS,
 	token.NOT_EQ:   EQUALS,
 	token.LT:       LESSGREATER,
 	token.GT:       LESSGREATER,
+	token.RANGE:    RANGE,
+	token.RANGE_EX: RANGE,
 	token.PLUS:     SUM,
 	token.MINUS:    SUM,
 	token.SLASH:    PRODUCT,
 	token.ASTERISK: PRODUCT,
 	token.LPAREN:   CALL,
 	token.LBRACKET: INDEX,
+	token.OPTIONAL: INDEX,
+}
+
+// Precedence is the binding power of the infix operator t, or LOWEST if t
+// is not one.
+func Precedence(t token.TokenType) int {
+	if pr, ok := precedences[t]; ok {
+		return pr
+	}
+	return LOWEST
 }
 
 func (p *Parser) peekPrecedence() int {
@@ -93,8 +110,12 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.FALSE, p.parseBoolean)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
+	p.registerPrefix(token.TRY, p.parseTryExpression)
+	p.registerPrefix(token.MATCH, p.parseMatchExpression)
 	p.registerPrefix(token.FUNC, p.parseFunctionLiteral)
+	p.registerPrefix(token.MACRO, p.parseMacroLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
+	p.registerPrefix(token.TEMPLATE, p.parseInterpolatedString)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
     p.registerPrefix(token.LCURLY, p.parseHashLiteral)
 
@@ -107,8 +128,12 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.RANGE, p.parseRangeExpression)
+	p.registerInfix(token.RANGE_EX, p.parseRangeExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
+	p.registerInfix(token.NULLISH, p.parseInfixExpression)
+	p.registerInfix(token.OPTIONAL, p.parseOptionalIndexExpression)
 
 	return p
 }
@@ -124,19 +149,42 @@ func (p *Parser) ParseProgram() *ast.Program {
 		}
 		p.nextToken()
 	}
+	p.checkConstants(program.Statements)
+	program.Comments = p.l.Comments()
 	return program
 }
 
+// parseStatement returns nil, rather than a nil pointer of the statement's
+// type, when the statement could not be parsed.
 func (p *Parser) parseStatement() ast.Statement {
 	//     defer untrace(trace("ParseStatement"))
 	switch p.curToken.Type {
-	case token.LET:
-		return p.parseLetStatement()
+	case token.LET, token.CONST:
+		if stmt := p.parseLetStatement(); stmt != nil {
+			return stmt
+		}
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.THROW:
+		if stmt := p.parseThrowStatement(); stmt != nil {
+			return stmt
+		}
+	case token.DEFER:
+		if stmt := p.parseDeferStatement(); stmt != nil {
+			return stmt
+		}
+	case token.IMPORT:
+		if stmt := p.parseImportStatement(); stmt != nil {
+			return stmt
+		}
+	case token.EXPORT:
+		if stmt := p.parseExportStatement(); stmt != nil {
+			return stmt
+		}
 	default:
 		return p.parseExpressionStatement()
 	}
+	return nil
 }
 
 func (p *Parser) parseLetStatement() *ast.LetStatement {
@@ -144,12 +192,25 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 
 	stmt := &ast.LetStatement{Token: p.curToken}
 
-	if !p.expectPeek(token.IDENT) {
-		return nil
+	if p.peekTokenIs(token.LBRACKET) || p.peekTokenIs(token.LCURLY) {
+		p.nextToken()
+		stmt.Pattern = p.parsePattern()
+		if stmt.Pattern == nil {
+			return nil
+		}
+	} else {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Bind = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		if p.peekTokenIs(token.COLON) {
+			p.nextToken()
+			if stmt.Bind.Type = p.parseTypeAnnotation(); stmt.Bind.Type == nil {
+				return nil
+			}
+		}
 	}
 
-	stmt.Bind = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-
 	if !p.expectPeek(token.ASSIGN) {
 		return nil
 	}
@@ -176,6 +237,38 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
+func (p *Parser) parseThrowStatement() *ast.ThrowStatement {
+	//     defer untrace(trace("ParseThrowStatement"))
+	stmt := &ast.ThrowStatement{Token: p.curToken}
+
+	p.nextToken()
+
+	stmt.Value = p.parseExpression(LOWEST)
+	if stmt.Value == nil {
+		return nil
+	}
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+func (p *Parser) parseDeferStatement() *ast.DeferStatement {
+	//     defer untrace(trace("ParseDeferStatement"))
+	stmt := &ast.DeferStatement{Token: p.curToken}
+
+	p.nextToken()
+
+	stmt.Call = p.parseExpression(LOWEST)
+	if stmt.Call == nil {
+		return nil
+	}
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 	// 		defer untrace(trace("parseExpressionStatement"))
 
@@ -232,7 +325,7 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 
 	if err != nil {
 		msg := fmt.Sprintf("could not parse %q as int", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.errorAt(p.curToken, msg)
 		return nil
 	}
 	lit.Value = v
@@ -245,6 +338,44 @@ func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
 }
 
+func (p *Parser) parseInterpolatedString() ast.Expression {
+	//     defer untrace(trace("ParseInterpolatedString"))
+	str := &ast.InterpolatedString{Token: p.curToken}
+
+	segments, ok := lexer.SplitTemplate(p.curToken.Literal)
+	if !ok {
+		msg := fmt.Sprintf("unterminated interpolation in %q", p.curToken.Literal)
+		p.errorAt(p.curToken, msg)
+		return nil
+	}
+
+	for _, seg := range segments {
+		if !seg.IsExpr {
+			tok := token.Token{Type: token.STRING, Literal: seg.Text}
+			str.Parts = append(str.Parts, &ast.StringLiteral{Token: tok, Value: seg.Text})
+			continue
+		}
+
+		sub := New(lexer.New(seg.Text))
+		exp := sub.parseExpression(LOWEST)
+		if !sub.peekTokenIs(token.EOF) {
+			sub.errorAt(sub.peekToken,
+				fmt.Sprintf("unexpected %s in interpolation ${%s}", sub.peekToken.Type, seg.Text))
+		}
+		if len(sub.Errors()) != 0 {
+			// Positions inside the interpolation are relative to it, so
+			// its errors are placed at the string.
+			for _, msg := range sub.Errors() {
+				p.errorAt(p.curToken, msg)
+			}
+			return nil
+		}
+		str.Parts = append(str.Parts, exp)
+	}
+
+	return str
+}
+
 func (p *Parser) parsePrefixExpression() ast.Expression {
 	// 		defer untrace(trace("parsePrefixExpression"))
 
@@ -276,6 +407,21 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	return expression
 }
 
+func (p *Parser) parseRangeExpression(start ast.Expression) ast.Expression {
+	//     defer untrace(trace("ParseRangeExpression"))
+	exp := &ast.RangeExpression{
+		Token:    p.curToken,
+		Operator: p.curToken.Literal,
+		Start:    start,
+	}
+
+	precedence := p.curPrecedence()
+	p.nextToken()
+	exp.End = p.parseExpression(precedence)
+
+	return exp
+}
+
 func (p *Parser) parseGroupedExpression() ast.Expression {
 	// 		defer untrace(trace("parseGroupedExpression"))
 	p.nextToken()
@@ -308,6 +454,13 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	exp.Consequence = p.parseBlockStatement()
 	if p.peekTokenIs(token.ELSE) {
 		p.nextToken()
+		if p.peekTokenIs(token.IF) {
+			exp.Alternative = p.parseElseIf()
+			if exp.Alternative == nil {
+				return nil
+			}
+			return exp
+		}
 		if !p.expectPeek(token.LCURLY) {
 			return nil
 		}
@@ -316,24 +469,111 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return exp
 }
 
-func (p *Parser) parseBlockStatement() *ast.BlockStatement {
-	// 		defer untrace(trace("parseBlockExpression"))
+// parseElseIf parses the `if` following an `else` and wraps it in a block
+// of its own, so `else if` chains nest as ordinary alternatives.
+func (p *Parser) parseElseIf() *ast.BlockStatement {
+	// 		defer untrace(trace("parseElseIf"))
 	p.nextToken()
+	block := &ast.BlockStatement{Token: p.curToken}
+
+	stmt := &ast.ExpressionStatement{Token: p.curToken}
+	stmt.Expression = p.parseIfExpression()
+	if stmt.Expression == nil {
+		return nil
+	}
+	block.Statements = []ast.Statement{stmt}
 
+	return block
+}
+
+func (p *Parser) parseTryExpression() ast.Expression {
+	//     defer untrace(trace("ParseTryExpression"))
+	exp := &ast.TryExpression{Token: p.curToken}
+	if !p.expectPeek(token.LCURLY) {
+		return nil
+	}
+	exp.Block = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.CATCH) {
+		p.nextToken()
+		if p.peekTokenIs(token.LPAREN) {
+			p.nextToken()
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			exp.Param = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			if !p.expectPeek(token.RPAREN) {
+				return nil
+			}
+		}
+		if !p.expectPeek(token.LCURLY) {
+			return nil
+		}
+		exp.Catch = p.parseBlockStatement()
+	}
+
+	if p.peekTokenIs(token.FINALLY) {
+		p.nextToken()
+		if !p.expectPeek(token.LCURLY) {
+			return nil
+		}
+		exp.Finally = p.parseBlockStatement()
+	}
+
+	if exp.Catch == nil && exp.Finally == nil {
+		p.errorAt(exp.Token, "try requires a catch or finally block")
+		return nil
+	}
+	return exp
+}
+
+func (p *Parser) parseBlockStatement() *ast.BlockStatement {
+	// 		defer untrace(trace("parseBlockExpression"))
 	block := &ast.BlockStatement{Token: p.curToken}
 	block.Statements = []ast.Statement{}
+	p.nextToken()
 
 	for !p.curTokenIs(token.RCURLY) && !p.curTokenIs(token.EOF) {
 		stmt := p.parseStatement()
+		p.checkTopLevel(stmt)
 
 		if stmt != nil {
 			block.Statements = append(block.Statements, stmt)
 		}
 		p.nextToken()
 	}
+	p.checkConstants(block.Statements)
+	if p.curTokenIs(token.RCURLY) {
+		block.Rbrace = p.curToken
+	}
 	return block
 }
 
+// checkConstants reports declarations that rebind a constant declared
+// earlier in the same block. Shadowing it in a nested block or function is
+// allowed, and the evaluator catches the cases this cannot see.
+func (p *Parser) checkConstants(stmts []ast.Statement) {
+	constants := map[string]bool{}
+	for _, stmt := range stmts {
+		if export, ok := stmt.(*ast.ExportStatement); ok && export != nil {
+			stmt = export.Statement
+		}
+		let, ok := stmt.(*ast.LetStatement)
+		if !ok || let == nil {
+			continue
+		}
+		for _, name := range let.Names() {
+			if constants[name.Value] {
+				msg := fmt.Sprintf("cannot reassign constant %s", name.Value)
+				p.errorAt(name.Token, msg)
+			}
+			if let.IsConst() {
+				constants[name.Value] = true
+			}
+		}
+	}
+}
+
 func (p *Parser) parseFunctionLiteral() ast.Expression {
 	//     defer untrace(trace("ParseFunctionLiteral"))
 	f := &ast.FunctionLiteral{Token: p.curToken}
@@ -344,6 +584,13 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 
 	f.Parameters = p.parseFunctionParameters()
 
+	if p.peekTokenIs(token.THIN_ARROW) {
+		p.nextToken()
+		if f.ReturnType = p.parseTypeAnnotation(); f.ReturnType == nil {
+			return nil
+		}
+	}
+
 	if !p.expectPeek(token.LCURLY) {
 		return nil
 	}
@@ -352,6 +599,24 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	return f
 }
 
+func (p *Parser) parseMacroLiteral() ast.Expression {
+	//     defer untrace(trace("ParseMacroLiteral"))
+	m := &ast.MacroLiteral{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	m.Parameters = p.parseFunctionParameters()
+
+	if !p.expectPeek(token.LCURLY) {
+		return nil
+	}
+	m.Body = p.parseBlockStatement()
+
+	return m
+}
+
 func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 	//     defer untrace(trace("ParseFunctionParameters"))
 	parameters := []*ast.Identifier{}
@@ -362,14 +627,12 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 	}
 
 	p.nextToken()
-	i := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	parameters = append(parameters, i)
+	parameters = append(parameters, p.parseParameter())
 
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
 		p.nextToken()
-		i := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-		parameters = append(parameters, i)
+		parameters = append(parameters, p.parseParameter())
 	}
 
 	if !p.expectPeek(token.RPAREN) {
@@ -379,10 +642,40 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 	return parameters
 }
 
+// parseParameter parses a parameter name and its optional annotation, as
+// in `x: int`.
+func (p *Parser) parseParameter() *ast.Identifier {
+	param := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		param.Type = p.parseTypeAnnotation()
+	}
+	return param
+}
+
+// parseTypeAnnotation parses the type name after the `:` or `->` at the
+// current token.
+func (p *Parser) parseTypeAnnotation() *ast.TypeAnnotation {
+	if !p.peekTokenIs(token.IDENT) && !p.peekTokenIs(token.FUNC) {
+		p.addError(token.IDENT)
+		return nil
+	}
+	p.nextToken()
+	for _, name := range ast.TypeNames {
+		if name == p.curToken.Literal {
+			return &ast.TypeAnnotation{Token: p.curToken, Name: name}
+		}
+	}
+	msg := fmt.Sprintf("unknown type %s", p.curToken.Literal)
+	p.errorAt(p.curToken, msg)
+	return nil
+}
+
 func (p *Parser) parseCallExpression(f ast.Expression) ast.Expression {
 	//     defer untrace(trace("ParseCallExpression"))
 	exp := &ast.CallExpression{Token: p.curToken, Function: f}
 	exp.Arguments = p.parseExpressionList(token.RPAREN)
+	exp.Rparen = p.curToken
 	return exp
 }
 
@@ -415,17 +708,41 @@ func (p *Parser) parseArrayLiteral() ast.Expression {
 	arr := &ast.ArrayLiteral{Token: p.curToken}
 
 	arr.Elements = p.parseExpressionList(token.RBRACKET)
+	arr.Rbracket = p.curToken
 
 	return arr
 }
 
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	//     defer untrace(trace("ParseIndexExpression"))
-	exp := &ast.IndexExpression{Token: p.curToken, Left: left}
+	tok := p.curToken
 
 	p.nextToken()
 
-	exp.Index = p.parseExpression(LOWEST)
+	var index ast.Expression
+	if !p.curTokenIs(token.COLON) {
+		index = p.parseExpression(LOWEST)
+		if !p.peekTokenIs(token.COLON) {
+			if !p.expectPeek(token.RBRACKET) {
+				return nil
+			}
+			return &ast.IndexExpression{Token: tok, Left: left, Index: index}
+		}
+		p.nextToken()
+	}
+
+	return p.parseSliceExpression(tok, left, index)
+}
+
+// parseSliceExpression is entered with curToken on the ':' of left[start:end].
+func (p *Parser) parseSliceExpression(tok token.Token, left, start ast.Expression) ast.Expression {
+	//     defer untrace(trace("ParseSliceExpression"))
+	exp := &ast.SliceExpression{Token: tok, Left: left, Start: start}
+
+	if !p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+		exp.End = p.parseExpression(LOWEST)
+	}
 
 	if !p.expectPeek(token.RBRACKET) {
 		return nil
@@ -434,6 +751,32 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	return exp
 }
 
+// parseOptionalIndexExpression handles `left?.[index]` and `left?.name`,
+// the latter being shorthand for `left?.["name"]`.
+func (p *Parser) parseOptionalIndexExpression(left ast.Expression) ast.Expression {
+	//     defer untrace(trace("ParseOptionalIndexExpression"))
+	exp := &ast.IndexExpression{Token: p.curToken, Left: left, Optional: true}
+
+	switch {
+	case p.peekTokenIs(token.IDENT):
+		p.nextToken()
+		exp.Index = &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+	case p.peekTokenIs(token.LBRACKET):
+		p.nextToken()
+		p.nextToken()
+		exp.Index = p.parseExpression(LOWEST)
+		if !p.expectPeek(token.RBRACKET) {
+			return nil
+		}
+	default:
+		msg := fmt.Sprintf("expected identifier or [ after ?., got %s instead", p.peekToken.Type)
+		p.errorAt(p.peekToken, msg)
+		return nil
+	}
+
+	return exp
+}
+
 func (p *Parser) parseHashLiteral() ast.Expression {
     hash := &ast.HashLiteral{Token: p.curToken}
     hash.Pairs = make(map[ast.Expression]ast.Expression)
@@ -451,6 +794,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 
         value := p.parseExpression(LOWEST)
         hash.Pairs[key] = value
+        hash.Order = append(hash.Order, key)
 
         if !p.peekTokenIs(token.RCURLY) && !p.expectPeek(token.COMMA) {
             return nil
@@ -460,6 +804,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
     if !p.expectPeek(token.RCURLY) {
         return nil
     }
+    hash.Rbrace = p.curToken
 
     return hash
 }
@@ -489,12 +834,23 @@ func (p *Parser) Errors() []string {
 	return p.errors
 }
 
+// ErrorPositions returns the token each of Errors was found at, in the
+// same order.
+func (p *Parser) ErrorPositions() []token.Token {
+	return p.positions
+}
+
+func (p *Parser) errorAt(tok token.Token, msg string) {
+	p.errors = append(p.errors, msg)
+	p.positions = append(p.positions, tok)
+}
+
 func (p *Parser) addError(t token.TokenType) {
 	msg := fmt.Sprintf("expected next token to be %s, got %s instead", t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	p.errorAt(p.peekToken, msg)
 }
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
 	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	p.errorAt(p.curToken, msg)
 }
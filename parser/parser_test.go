@@ -26,6 +26,218 @@ func TestParsingIndexExpressions(t *testing.T) {
 	}
 }
 
+func TestParsingSliceExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"s[1:2]", "(s[1:2])"},
+		{"s[:2]", "(s[:2])"},
+		{"s[1:]", "(s[1:])"},
+		{"s[:]", "(s[:])"},
+		{"s[a + 1:len(s)]", "(s[(a + 1):len(s)])"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		if _, ok := stmt.Expression.(*ast.SliceExpression); !ok {
+			t.Fatalf("exp not *ast.SliceExpression. got=%T", stmt.Expression)
+		}
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestParsingRangeExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1..10", "(1..10)"},
+		{"1..<10", "(1..<10)"},
+		{"0..n - 1", "(0..(n - 1))"},
+		{"a..b == c", "((a..b) == c)"},
+		{"(0..5)[1:]", "((0..5)[1:])"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestParsingNullishAndOptional(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a ?? b", "(a ?? b)"},
+		{"a ?? b == c", "(a ?? (b == c))"},
+		{"a ?? b ?? c", "((a ?? b) ?? c)"},
+		{"a?.name", `(a?.[name])`},
+		{"a?.[1 + 1]", "(a?.[(1 + 1)])"},
+		{"a?.b?.c ?? d", "(((a?.[b])?.[c]) ?? d)"},
+		{"f(x)?.y", "(f(x)?.[y])"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+
+	p := New(lexer.New("a?.1"))
+	p.ParseProgram()
+	checkErrorPositions(t, p)
+	if len(p.Errors()) == 0 || p.Errors()[0] != "expected identifier or [ after ?., got INT instead" {
+		t.Errorf("wrong parser errors. got=%q", p.Errors())
+	}
+}
+
+func TestTryExpression(t *testing.T) {
+	input := `try { risky(); } catch (e) { e } finally { cleanup(); }`
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	exp, ok := stmt.Expression.(*ast.TryExpression)
+	if !ok {
+		t.Fatalf("exp not *ast.TryExpression. got=%T", stmt.Expression)
+	}
+	if len(exp.Block.Statements) != 1 {
+		t.Errorf("try block has wrong length. got=%d", len(exp.Block.Statements))
+	}
+	if !testIdentifier(t, exp.Param, "e") {
+		return
+	}
+	if exp.Catch == nil || exp.Finally == nil {
+		t.Fatalf("catch or finally block missing")
+	}
+	expected := "try risky() catch (e) e finally cleanup()"
+	if exp.String() != expected {
+		t.Errorf("exp.String() wrong. want=%q got=%q", expected, exp.String())
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"try { 1 } catch { 2 }", "try 1 catch 2"},
+		{"try { 1 } finally { 2 }", "try 1 finally 2"},
+		{"throw 1 + 2;", "throw (1 + 2);"},
+		{`throw error("x", "E")`, `throw error(x, E);`},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+
+	p = New(lexer.New("try { 1 }"))
+	p.ParseProgram()
+	checkErrorPositions(t, p)
+	if len(p.Errors()) != 1 || p.Errors()[0] != "try requires a catch or finally block" {
+		t.Errorf("wrong parser errors. got=%q", p.Errors())
+	}
+}
+
+func TestDeferStatement(t *testing.T) {
+	p := New(lexer.New("defer close(f, 1);"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ast.DeferStatement)
+	if !ok {
+		t.Fatalf("stmt not *ast.DeferStatement. got=%T", program.Statements[0])
+	}
+	call, ok := stmt.Call.(*ast.CallExpression)
+	if !ok {
+		t.Fatalf("stmt.Call not *ast.CallExpression. got=%T", stmt.Call)
+	}
+	if !testIdentifier(t, call.Function, "close") {
+		return
+	}
+	if stmt.String() != "defer close(f, 1);" {
+		t.Errorf("stmt.String() wrong. got=%q", stmt.String())
+	}
+}
+
+func TestMatchExpression(t *testing.T) {
+	input := `match (msg) {
+		0 => "zero",
+		-1 => "minus one",
+		"ping" => "pong",
+		true => "yes",
+		[first, _, ...rest] if (first > 1) => rest,
+		{"type": "add", value: v, id} => v + id,
+		n => { let doubled = n * 2; doubled }
+		_ => { {"empty": true} },
+	}`
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	exp, ok := stmt.Expression.(*ast.MatchExpression)
+	if !ok {
+		t.Fatalf("exp not *ast.MatchExpression. got=%T", stmt.Expression)
+	}
+	if !testIdentifier(t, exp.Subject, "msg") {
+		return
+	}
+
+	expected := []string{
+		`0 => zero`,
+		`-1 => minus one`,
+		`"ping" => pong`,
+		`true => yes`,
+		`[first, _, ...rest] if (first > 1) => rest`,
+		`{"type": "add", "value": v, "id": id} => (v + id)`,
+		`n => { let doubled = (n * 2);doubled }`,
+		`_ => { {empty:true} }`,
+	}
+	if len(exp.Arms) != len(expected) {
+		t.Fatalf("wrong number of arms. want=%d got=%d", len(expected), len(exp.Arms))
+	}
+	for i, arm := range exp.Arms {
+		if arm.String() != expected[i] {
+			t.Errorf("arm %d wrong. want=%q got=%q", i, expected[i], arm.String())
+		}
+	}
+}
+
+func TestMatchExpressionErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"match (x) { + => 1 }", "expected a pattern, got + instead"},
+		{"match (x) { 1 2 }", "expected next token to be =>, got INT instead"},
+		{"match (x) { 1 => 2 3 => 4 }", "expected next token to be }, got INT instead"},
+		{"match (x) { {1 + 2} => 4 }", "expected next token to be :, got + instead"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		checkErrorPositions(t, p)
+		if len(p.Errors()) == 0 || p.Errors()[0] != tt.expected {
+			t.Errorf("%s: wrong parser errors. want=%q got=%q", tt.input, tt.expected, p.Errors())
+		}
+	}
+}
+
 func TestLetStatements(t *testing.T) {
 	tests := []struct {
 		input              string
@@ -56,6 +268,148 @@ func TestLetStatements(t *testing.T) {
 	}
 }
 
+func TestConstStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"const x = 5;", "const x = 5;"},
+		{"const [a, ...b] = xs;", "const [a, ...b] = xs;"},
+		{"const x = 1; if (y) { let x = 2; }", "const x = 1;if y { let x = 2; }"},
+		{"const f = 1; fn() { const f = 2; f }", "const f = 1;fn()const f = 2;f"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		stmt, ok := program.Statements[0].(*ast.LetStatement)
+		if !ok || !stmt.IsConst() {
+			t.Fatalf("first statement is not a const declaration. got=%T", program.Statements[0])
+		}
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+
+	errors := []struct {
+		input    string
+		expected string
+	}{
+		{"const x = 1; let x = 2;", "cannot reassign constant x"},
+		{"const x = 1; const x = 2;", "cannot reassign constant x"},
+		{"const {a, b} = h; let [c, b] = xs;", "cannot reassign constant b"},
+		{"fn() { const y = 1; let y = 2; }", "cannot reassign constant y"},
+	}
+	for _, tt := range errors {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		checkErrorPositions(t, p)
+		if len(p.Errors()) != 1 || p.Errors()[0] != tt.expected {
+			t.Errorf("%s: wrong parser errors. want=%q got=%q", tt.input, tt.expected, p.Errors())
+		}
+	}
+}
+
+func TestImportExportStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`import "lib/math.mk" as m;`, `import "lib/math.mk" as m;`},
+		{`import "setup.mk"`, `import "setup.mk";`},
+		{`import {square, cube as c} from "math.mk";`, `import {square, cube as c} from "math.mk";`},
+		{`import {} from "math.mk";`, `import {} from "math.mk";`},
+		{"export let x = 1;", "export let x = 1;"},
+		{"export const [a, b] = xs;", "export const [a, b] = xs;"},
+		{"let as = 1; let from = as;", "let as = 1;let from = as;"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+
+	errors := []struct {
+		input    string
+		expected string
+	}{
+		{`import math.mk`, "expected next token to be STRING, got IDENT instead"},
+		{`import {a} "math.mk"`, "expected next token to be from, got STRING instead"},
+		{`import "m.mk" as "x"`, "expected next token to be IDENT, got STRING instead"},
+		{"export fn() {}", "expected let or const after export, got FUNC instead"},
+		{`fn() { import "m.mk" as m; }`, "import is only allowed at the top level"},
+		{"if (x) { export let y = 1; }", "export is only allowed at the top level"},
+		{"export const x = 1; let x = 2;", "cannot reassign constant x"},
+	}
+	for _, tt := range errors {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkErrorPositions(t, p)
+		for _, stmt := range program.Statements {
+			switch stmt := stmt.(type) {
+			case *ast.ImportStatement:
+				if stmt == nil {
+					t.Errorf("%s: program holds a nil import statement", tt.input)
+				}
+			case *ast.ExportStatement:
+				if stmt == nil {
+					t.Errorf("%s: program holds a nil export statement", tt.input)
+				}
+			}
+		}
+		if len(p.Errors()) == 0 || p.Errors()[0] != tt.expected {
+			t.Errorf("%s: wrong parser errors. want=%q got=%q", tt.input, tt.expected, p.Errors())
+		}
+	}
+}
+
+func TestDestructuringLetStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let [a, b, ...rest] = arr;", "let [a, b, ...rest] = arr;"},
+		{"let {name, age: years} = person;", `let {"name": name, "age": years} = person;`},
+		{"let [a, [b, c = 2]] = x;", "let [a, [b, c = 2]] = x;"},
+		{`let {"k": {inner = 1 + 1}, tags: [_, t]} = x;`, `let {"k": {"inner": inner = (1 + 1)}, "tags": [_, t]} = x;`},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		stmt, ok := program.Statements[0].(*ast.LetStatement)
+		if !ok {
+			t.Fatalf("stmt not *ast.LetStatement. got=%T", program.Statements[0])
+		}
+		if stmt.Pattern == nil || stmt.Bind != nil {
+			t.Fatalf("let statement not parsed as destructuring")
+		}
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+
+	errors := []struct {
+		input    string
+		expected string
+	}{
+		{"let [a, ...] = x;", "expected next token to be IDENT, got ] instead"},
+		{"let {+} = x;", "expected a hash pattern key, got + instead"},
+		{"match (x) { [a = 1] => a }", "default values are not allowed in match patterns"},
+	}
+	for _, tt := range errors {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		checkErrorPositions(t, p)
+		if len(p.Errors()) == 0 || p.Errors()[0] != tt.expected {
+			t.Errorf("%s: wrong parser errors. want=%q got=%q", tt.input, tt.expected, p.Errors())
+		}
+	}
+}
+
 func testLetStatements(t *testing.T, s ast.Statement, bindl string) bool {
 	if s.TokenLiteral() != "let" {
 		t.Errorf("s.TokenLiteral not 'let'. got=%q", s.TokenLiteral())
@@ -209,6 +563,50 @@ func TestIfElseExpression(t *testing.T) {
 	}
 }
 
+func TestElseIfExpression(t *testing.T) {
+	input := `if (x < 1) { a } else if (x < 2) { b } else if (x < 3) { c } else { d }`
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	exp, ok := stmt.Expression.(*ast.IfExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.IfExpression. got=%T", stmt.Expression)
+	}
+
+	conditions := []int{1, 2, 3}
+	for i, cond := range conditions {
+		if exp == nil {
+			t.Fatalf("chain ended after %d links", i)
+		}
+		if !testInfixExpression(t, exp.Condition, "x", "<", cond) {
+			return
+		}
+		if i < len(conditions)-1 {
+			exp = exp.ElseIf()
+		}
+	}
+	if exp.ElseIf() != nil {
+		t.Fatalf("final else parsed as else if")
+	}
+	alternative := exp.Alternative.Statements[0].(*ast.ExpressionStatement)
+	if !testIdentifier(t, alternative.Expression, "d") {
+		return
+	}
+
+	if program.String() != input {
+		t.Errorf("program.String() wrong. want=%q got=%q", input, program.String())
+	}
+
+	p = New(lexer.New("if (x) { a } else if { b }"))
+	p.ParseProgram()
+	checkErrorPositions(t, p)
+	if len(p.Errors()) == 0 || p.Errors()[0] != "expected next token to be (, got { instead" {
+		t.Errorf("wrong parser errors. got=%q", p.Errors())
+	}
+}
+
 func TestFunctionLiteral(t *testing.T) {
 	input := `fn(x, y) { x + y; }`
 	l := lexer.New(input)
@@ -248,6 +646,45 @@ func TestFunctionLiteral(t *testing.T) {
 	testInfixExpression(t, bodyStmt.Expression, "x", "+", "y")
 }
 
+func TestMacroLiteralParsing(t *testing.T) {
+	input := `macro(x, y) { x + y; }`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d\n",
+			1, len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T",
+			program.Statements[0])
+	}
+
+	macro, ok := stmt.Expression.(*ast.MacroLiteral)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.MacroLiteral. got=%T",
+			stmt.Expression)
+	}
+	if len(macro.Parameters) != 2 {
+		t.Fatalf("macro literal parameters wrong. want 2, got=%d\n",
+			len(macro.Parameters))
+	}
+	testLiteralExpression(t, macro.Parameters[0], "x")
+	testLiteralExpression(t, macro.Parameters[1], "y")
+	if len(macro.Body.Statements) != 1 {
+		t.Fatalf("macro.Body.Statements has not 1 statements. got=%d\n",
+			len(macro.Body.Statements))
+	}
+	bodyStmt, ok := macro.Body.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("macro body stmt is not ast.ExpressionStatement. got=%T",
+			macro.Body.Statements[0])
+	}
+	testInfixExpression(t, bodyStmt.Expression, "x", "+", "y")
+}
+
 func TestFunctionParameterParsing(t *testing.T) {
 	tests := []struct {
 		input          string
@@ -274,6 +711,52 @@ func TestFunctionParameterParsing(t *testing.T) {
 	}
 }
 
+func TestTypeAnnotations(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x: int = 1;", "let x: int = 1;"},
+		{"const s: string = \"a\";", "const s: string = a;"},
+		{"let f: fn = fn(a: int, b) -> bool { a };", "let f: fn = fn(a: int,b) -> bool a;"},
+		{"fn(xs: array, h: hash, e: error, n: null, v: any) {}",
+			"fn(xs: array,h: hash,e: error,n: null,v: any)"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if program.String() != tt.expected {
+			t.Errorf("%s: wrong program. want=%q got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+
+	p := New(lexer.New("let n: int = 1;"))
+	let := p.ParseProgram().Statements[0].(*ast.LetStatement)
+	if let.Bind.Type == nil || let.Bind.Type.Name != "int" || let.Bind.Type.Token.Column != 8 {
+		t.Errorf("wrong annotation. got=%+v", let.Bind.Type)
+	}
+
+	errors := []struct {
+		input    string
+		expected string
+	}{
+		{"let x: integer = 1;", "unknown type integer"},
+		{"let x: = 1;", "expected next token to be IDENT, got = instead"},
+		{"fn(x: 1) {}", "expected next token to be IDENT, got INT instead"},
+		{"fn() -> {}", "expected next token to be IDENT, got { instead"},
+		{"let [a]: array = [1];", "expected next token to be =, got : instead"},
+	}
+	for _, tt := range errors {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		checkErrorPositions(t, p)
+		if len(p.Errors()) == 0 || p.Errors()[0] != tt.expected {
+			t.Errorf("%s: wrong parser errors. want=%q got=%q", tt.input, tt.expected, p.Errors())
+		}
+	}
+}
+
 func TestCallExpression(t *testing.T) {
 	input := "add(1, 2 * 3, 4 + 5);"
 	l := lexer.New(input)
@@ -437,6 +920,78 @@ func TestStringLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestInterpolatedStringExpression(t *testing.T) {
+	input := `"sum ${1 + 2} of ${x}"`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	str, ok := stmt.Expression.(*ast.InterpolatedString)
+	if !ok {
+		t.Fatalf("exp not *ast.InterpolatedString. got=%T", stmt.Expression)
+	}
+	if len(str.Parts) != 4 {
+		t.Fatalf("str.Parts has wrong length. got=%d", len(str.Parts))
+	}
+	if lit, ok := str.Parts[0].(*ast.StringLiteral); !ok || lit.Value != "sum " {
+		t.Errorf("str.Parts[0] not \"sum \". got=%T (%s)", str.Parts[0], str.Parts[0])
+	}
+	testInfixExpression(t, str.Parts[1], 1, "+", 2)
+	if lit, ok := str.Parts[2].(*ast.StringLiteral); !ok || lit.Value != " of " {
+		t.Errorf("str.Parts[2] not \" of \". got=%T (%s)", str.Parts[2], str.Parts[2])
+	}
+	testIdentifier(t, str.Parts[3], "x")
+}
+
+func TestInterpolatedStringErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"${x`, `unterminated interpolation in "${x"`},
+		{`"${x y}"`, "unexpected IDENT in interpolation ${x y}"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		checkErrorPositions(t, p)
+		errors := p.Errors()
+		if len(errors) == 0 || errors[0] != tt.expected {
+			t.Errorf("wrong parser errors. want=%q got=%q", tt.expected, errors)
+		}
+	}
+}
+
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x 5;", "1:7 expected next token to be =, got INT instead"},
+		{"let a = 1;\n  let b = );", "2:11 no prefix parse function for ) found"},
+		{"let y = \"a ${b c}\";", "1:9 unexpected IDENT in interpolation ${b c}"},
+		{"const c = 1;\nconst c = 2;", "2:7 cannot reassign constant c"},
+		{"let n: num = 1;", "1:8 unknown type num"},
+		{"let [", "1:6 expected a pattern, got EOF instead"},
+		{"let f = fn() { import \"x.mk\"; };", "1:16 import is only allowed at the top level"},
+		{"export fn", "1:8 expected let or const after export, got FUNC instead"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		errors, positions := p.Errors(), p.ErrorPositions()
+		if len(errors) == 0 || len(positions) != len(errors) {
+			t.Fatalf("%q: got %d errors and %d positions", tt.input, len(errors), len(positions))
+		}
+		got := fmt.Sprintf("%d:%d %s", positions[0].Line, positions[0].Column, errors[0])
+		if got != tt.expected {
+			t.Errorf("%q: wrong first error. want=%q got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
 func TestParsingArrayLiterals(t *testing.T) {
 	input := "[1, 2 * 2, 3 + 3]"
 	l := lexer.New(input)
@@ -944,3 +1499,11 @@ func checkParserErrors(t *testing.T, p *Parser) {
 	}
 	t.FailNow()
 }
+
+// checkErrorPositions checks that the parser recorded a position for each
+// error it reported.
+func checkErrorPositions(t *testing.T, p *Parser) {
+	if errors, positions := p.Errors(), p.ErrorPositions(); len(positions) != len(errors) {
+		t.Errorf("got %d errors and %d positions: %q", len(errors), len(positions), errors)
+	}
+}
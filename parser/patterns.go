@@ -0,0 +1,237 @@
+package parser
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/token"
+)
+
+func (p *Parser) parseMatchExpression() ast.Expression {
+	//     defer untrace(trace("ParseMatchExpression"))
+	exp := &ast.MatchExpression{Token: p.curToken}
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	exp.Subject = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LCURLY) {
+		return nil
+	}
+
+	for !p.peekTokenIs(token.RCURLY) {
+		p.nextToken()
+		arm := p.parseMatchArm()
+		if arm == nil {
+			return nil
+		}
+		exp.Arms = append(exp.Arms, arm)
+
+		if p.peekTokenIs(token.COMMA) {
+			p.nextToken()
+		} else if !p.peekTokenIs(token.RCURLY) {
+			if _, isBlock := arm.Body.(*ast.BlockStatement); !isBlock {
+				p.addError(token.RCURLY)
+				return nil
+			}
+		}
+	}
+
+	if !p.expectPeek(token.RCURLY) {
+		return nil
+	}
+	return exp
+}
+
+// parseMatchArm parses `pattern [if guard] => body`. A body starting with
+// '{' is a block; wrap a hash literal in a block to return it.
+func (p *Parser) parseMatchArm() *ast.MatchArm {
+	//     defer untrace(trace("ParseMatchArm"))
+	arm := &ast.MatchArm{Pattern: p.parsePattern()}
+	if arm.Pattern == nil {
+		return nil
+	}
+	if hasDefaults(arm.Pattern) {
+		p.errorAt(p.curToken, "default values are not allowed in match patterns")
+		return nil
+	}
+
+	if p.peekTokenIs(token.IF) {
+		p.nextToken()
+		p.nextToken()
+		arm.Guard = p.parseExpression(LOWEST)
+	}
+
+	if !p.expectPeek(token.ARROW) {
+		return nil
+	}
+
+	if p.peekTokenIs(token.LCURLY) {
+		p.nextToken()
+		arm.Body = p.parseBlockStatement()
+	} else {
+		p.nextToken()
+		body := p.parseExpression(LOWEST)
+		if body == nil {
+			return nil
+		}
+		arm.Body = body
+	}
+	return arm
+}
+
+// parsePattern parses the pattern starting at curToken.
+func (p *Parser) parsePattern() ast.Pattern {
+	//     defer untrace(trace("ParsePattern"))
+	switch p.curToken.Type {
+	case token.IDENT:
+		if p.curToken.Literal == "_" {
+			return &ast.WildcardPattern{Token: p.curToken}
+		}
+		return &ast.BindingPattern{Token: p.curToken, Name: p.parseIdentifier().(*ast.Identifier)}
+	case token.INT:
+		return &ast.LiteralPattern{Token: p.curToken, Value: p.parseIntegerLiteral()}
+	case token.STRING:
+		return &ast.LiteralPattern{Token: p.curToken, Value: p.parseStringLiteral()}
+	case token.TRUE, token.FALSE:
+		return &ast.LiteralPattern{Token: p.curToken, Value: p.parseBoolean()}
+	case token.MINUS:
+		if !p.peekTokenIs(token.INT) {
+			p.addError(token.INT)
+			return nil
+		}
+		return &ast.LiteralPattern{Token: p.curToken, Value: p.parsePrefixExpression()}
+	case token.LBRACKET:
+		return p.parseArrayPattern()
+	case token.LCURLY:
+		return p.parseHashPattern()
+	default:
+		msg := fmt.Sprintf("expected a pattern, got %s instead", p.curToken.Type)
+		p.errorAt(p.curToken, msg)
+		return nil
+	}
+}
+
+func (p *Parser) parseArrayPattern() ast.Pattern {
+	//     defer untrace(trace("ParseArrayPattern"))
+	pattern := &ast.ArrayPattern{Token: p.curToken}
+
+	for !p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+
+		if p.curTokenIs(token.ELLIPSIS) {
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			pattern.Rest = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			break
+		}
+
+		el := p.parseElementPattern()
+		if el == nil {
+			return nil
+		}
+		pattern.Elements = append(pattern.Elements, el)
+
+		if !p.peekTokenIs(token.RBRACKET) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+	return pattern
+}
+
+// parseHashPattern accepts `"key": pattern`, `key: pattern` (the key is
+// the identifier's name) and the shorthand `key`, which binds key.
+func (p *Parser) parseHashPattern() ast.Pattern {
+	//     defer untrace(trace("ParseHashPattern"))
+	pattern := &ast.HashPattern{Token: p.curToken}
+
+	for !p.peekTokenIs(token.RCURLY) {
+		p.nextToken()
+
+		var key ast.Expression
+		switch p.curToken.Type {
+		case token.STRING:
+			key = p.parseStringLiteral()
+		case token.INT:
+			key = p.parseIntegerLiteral()
+		case token.IDENT:
+			key = &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+		default:
+			msg := fmt.Sprintf("expected a hash pattern key, got %s instead", p.curToken.Type)
+			p.errorAt(p.curToken, msg)
+			return nil
+		}
+
+		var value ast.Pattern
+		if p.curTokenIs(token.IDENT) && !p.peekTokenIs(token.COLON) {
+			value = p.parseElementPattern()
+		} else {
+			if !p.expectPeek(token.COLON) {
+				return nil
+			}
+			p.nextToken()
+			value = p.parseElementPattern()
+		}
+		if value == nil {
+			return nil
+		}
+		pattern.Keys = append(pattern.Keys, key)
+		pattern.Values = append(pattern.Values, value)
+
+		if !p.peekTokenIs(token.RCURLY) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RCURLY) {
+		return nil
+	}
+	return pattern
+}
+
+// parseElementPattern parses a pattern nested in an array or hash pattern,
+// where a binding may carry a default: `[a, b = 2]`, `{name = "anon"}`.
+func (p *Parser) parseElementPattern() ast.Pattern {
+	pattern := p.parsePattern()
+	binding, ok := pattern.(*ast.BindingPattern)
+	if !ok || !p.peekTokenIs(token.ASSIGN) {
+		return pattern
+	}
+
+	p.nextToken()
+	p.nextToken()
+	binding.Default = p.parseExpression(LOWEST)
+	if binding.Default == nil {
+		return nil
+	}
+	return binding
+}
+
+func hasDefaults(pattern ast.Pattern) bool {
+	switch pattern := pattern.(type) {
+	case *ast.BindingPattern:
+		return pattern.Default != nil
+	case *ast.ArrayPattern:
+		for _, el := range pattern.Elements {
+			if hasDefaults(el) {
+				return true
+			}
+		}
+	case *ast.HashPattern:
+		for _, value := range pattern.Values {
+			if hasDefaults(value) {
+				return true
+			}
+		}
+	}
+	return false
+}
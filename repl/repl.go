@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"monkey/ast"
 	"monkey/eval"
 	"monkey/lexer"
 	"monkey/object"
@@ -12,9 +13,12 @@ import (
 
 const PROMPT = ">>"
 
-func Start(in io.Reader, out io.Writer) {
+// Start reads lines from in and evaluates them, optimizing each first if
+// optimize is set.
+func Start(in io.Reader, out io.Writer, optimize bool) {
 	scanner := bufio.NewScanner(in)
     env := object.NewEnvironment()
+    macroEnv := object.NewEnvironment()
 	for {
 		fmt.Printf(PROMPT)
 		scanned := scanner.Scan()
@@ -34,7 +38,20 @@ func Start(in io.Reader, out io.Writer) {
 			printParserErrors(out, p.Errors())
 			continue
 		}
-        evaluated := eval.Eval(program,env)
+        eval.DefineMacros(program, macroEnv)
+        expanded, err := eval.ExpandMacros(program, macroEnv)
+        if err != nil {
+            io.WriteString(out, err.Inspect()+"\n")
+            continue
+        }
+        if err := eval.CheckLine(expanded.(*ast.Program), env); err != nil {
+            io.WriteString(out, err.Inspect()+"\n")
+            continue
+        }
+        if optimize {
+            eval.Optimize(expanded.(*ast.Program))
+        }
+        evaluated := eval.Eval(expanded,env)
         if evaluated != nil {
             io.WriteString(out, evaluated.Inspect())
             io.WriteString(out,"\n")
@@ -1,19 +1,25 @@
 package token
 
 type TokenType string
+// Token is a lexeme with the 1-based line and column, in bytes, of its
+// first character. Tokens made up by the parser have no position.
 type Token struct {
 	Type    TokenType
 	Literal string
+	Line    int
+	Column  int
 }
 
 const (
 	ILLEGAL = "ILLEGAL"
 	EOF     = "EOF"
+	COMMENT = "COMMENT"
 
 	//IDENTIFIERS+ LITERALS
 	IDENT = "IDENT"
 	INT   = "INT"
     STRING = "STRING"
+    TEMPLATE = "TEMPLATE"
 
 	//OPERATORS
 	ASSIGN   = "="
@@ -26,6 +32,13 @@ const (
 	GT       = ">"
 	EQ       = "=="
 	NOT_EQ   = "!="
+	RANGE    = ".."
+	RANGE_EX = "..<"
+	NULLISH  = "??"
+	OPTIONAL = "?."
+	ARROW    = "=>"
+	THIN_ARROW = "->"
+	ELLIPSIS = "..."
 
 	//DELIMITERS
 	COMMA     = ","
@@ -41,22 +54,42 @@ const (
 
 	//KEYWORDS
 	LET    = "LET"
+	CONST  = "CONST"
 	FUNC   = "FUNC"
 	TRUE   = "TRUE"
 	FALSE  = "FALSE"
 	IF     = "IF"
 	ELSE   = "ELSE"
 	RETURN = "RETURN"
+	TRY     = "TRY"
+	CATCH   = "CATCH"
+	FINALLY = "FINALLY"
+	THROW   = "THROW"
+	DEFER   = "DEFER"
+	MATCH   = "MATCH"
+	IMPORT  = "IMPORT"
+	EXPORT  = "EXPORT"
+	MACRO   = "MACRO"
 )
 
 var keywords = map[string]TokenType{
 	"fn":     FUNC,
 	"let":    LET,
+	"const":  CONST,
 	"true":   TRUE,
 	"false":  FALSE,
 	"else":   ELSE,
 	"if":     IF,
 	"return": RETURN,
+	"try":     TRY,
+	"catch":   CATCH,
+	"finally": FINALLY,
+	"throw":   THROW,
+	"defer":   DEFER,
+	"match":   MATCH,
+	"import":  IMPORT,
+	"export":  EXPORT,
+	"macro":   MACRO,
 }
 
 func LookupKeyword(key string) TokenType {